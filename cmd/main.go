@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/binary"
 	"errors"
 	"math/rand"
@@ -24,12 +25,12 @@ const (
 )
 
 var (
-	initSanityEntry    bool   = false
 	initSanityBlock    bool   = false
 	initSanityBookmark bool   = false
-	sanityEntry        uint64 = 0
 	sanityBlock        uint64 = 0
 	sanityBookmark     uint64 = 0
+	sanityFromEntry    uint64 = 0
+	sanityTotalEntries uint64 = 0
 )
 
 // main runs a datastream server or client
@@ -105,6 +106,11 @@ func main() {
 					Usage: "bookmark to start the sync/streaming from (0..N) (has preference over --from parameter)",
 					Value: "none",
 				},
+				&cli.StringFlag{
+					Name:  "fromtime",
+					Usage: "time (RFC3339) to start the sync/streaming from the first entry committed at or after it (has preference over --from and --frombookmark parameters)",
+					Value: "none",
+				},
 				&cli.BoolFlag{
 					Name:  "header",
 					Usage: "query file header information",
@@ -120,11 +126,26 @@ func main() {
 					Usage: "entry bookmark to query entry data pointed by it (0..N)",
 					Value: "none",
 				},
+				&cli.StringFlag{
+					Name:  "latest",
+					Usage: "entry type to query data of its latest entries",
+					Value: "none",
+				},
+				&cli.Uint64Flag{
+					Name:  "count",
+					Usage: "number of latest entries to query",
+					Value: 1,
+				},
 				&cli.BoolFlag{
 					Name:  "sanitycheck",
 					Usage: "when receiving streaming check entry, bookmark, and block sequence consistency",
 					Value: false,
 				},
+				&cli.BoolFlag{
+					Name:  "compression",
+					Usage: "request the streaming compressed (snappy)",
+					Value: false,
+				},
 				&cli.StringFlag{
 					Name:        "log",
 					Usage:       "log level (debug|info|warn|error)",
@@ -340,13 +361,24 @@ func runClient(ctx *cli.Context) error {
 	}
 	from := ctx.String("from")
 	fromBookmark := ctx.String("frombookmark")
+	fromTime := ctx.String("fromtime")
 	queryHeader := ctx.Bool("header")
 	queryEntry := ctx.String("entry")
 	queryBookmark := ctx.String("bookmark")
+	queryLatest := ctx.String("latest")
+	queryCount := ctx.Uint64("count")
 	sanityCheck := ctx.Bool("sanitycheck")
+	compression := datastreamer.CompressionNone
+	if ctx.Bool("compression") {
+		compression = datastreamer.CompressionSnappy
+	}
 
 	// Create client
-	c, err := datastreamer.NewClient(server, StSequencer)
+	c, err := datastreamer.NewClientWithConfig(datastreamer.ClientConfig{
+		Server:      server,
+		StreamType:  StSequencer,
+		Compression: compression,
+	})
 	if err != nil {
 		return err
 	}
@@ -366,11 +398,11 @@ func runClient(ctx *cli.Context) error {
 
 	// Query file header information
 	if queryHeader {
-		err = c.ExecCommand(datastreamer.CmdHeader)
+		header, err := c.GetHeader(context.Background())
 		if err != nil {
 			log.Infof("Error: %v", err)
 		} else {
-			log.Infof("QUERY HEADER: TotalEntries[%d] TotalLength[%d]", c.Header.TotalEntries, c.Header.TotalLength)
+			log.Infof("QUERY HEADER: TotalEntries[%d] TotalLength[%d]", header.TotalEntries, header.TotalLength)
 		}
 		return nil
 	}
@@ -381,12 +413,11 @@ func runClient(ctx *cli.Context) error {
 		if err != nil {
 			return err
 		}
-		c.FromEntry = uint64(qEntry)
-		err = c.ExecCommand(datastreamer.CmdEntry)
+		entry, err := c.GetEntry(context.Background(), uint64(qEntry))
 		if err != nil {
 			log.Infof("Error: %v", err)
 		} else {
-			log.Infof("QUERY ENTRY %d: Entry[%d] Length[%d] Type[%d] Data[%v]", qEntry, c.Entry.Number, c.Entry.Length, c.Entry.Type, c.Entry.Data)
+			log.Infof("QUERY ENTRY %d: Entry[%d] Length[%d] Type[%d] Data[%v]", qEntry, entry.Number, entry.Length, entry.Type, entry.Data)
 		}
 		return nil
 	}
@@ -399,23 +430,50 @@ func runClient(ctx *cli.Context) error {
 		}
 		qBook := []byte{0} // nolint:gomnd
 		qBook = binary.LittleEndian.AppendUint64(qBook, uint64(qBookmark))
-		c.FromBookmark = qBook
-		err = c.ExecCommand(datastreamer.CmdBookmark)
+		entry, err := c.GetBookmark(context.Background(), qBook)
+		if err != nil {
+			log.Infof("Error: %v", err)
+		} else {
+			log.Infof("QUERY BOOKMARK %v: Entry[%d] Length[%d] Type[%d] Data[%v]", qBook, entry.Number, entry.Length, entry.Type, entry.Data)
+		}
+		return nil
+	}
+
+	// Query latest entries option
+	if queryLatest != "none" {
+		qType, err := strconv.Atoi(queryLatest)
+		if err != nil {
+			return err
+		}
+		entries, err := c.GetLatestEntries(context.Background(), datastreamer.EntryType(qType), queryCount)
 		if err != nil {
 			log.Infof("Error: %v", err)
 		} else {
-			log.Infof("QUERY BOOKMARK %v: Entry[%d] Length[%d] Type[%d] Data[%v]", qBook, c.Entry.Number, c.Entry.Length, c.Entry.Type, c.Entry.Data)
+			for _, entry := range entries {
+				log.Infof("QUERY LATEST %d: Entry[%d] Length[%d] Type[%d] Data[%v]", qType, entry.Number, entry.Length, entry.Type, entry.Data)
+			}
 		}
 		return nil
 	}
 
 	// Command header: Get status
-	err = c.ExecCommand(datastreamer.CmdHeader)
+	header, err := c.GetHeader(context.Background())
 	if err != nil {
 		return err
 	}
+	sanityTotalEntries = header.TotalEntries
 
-	if fromBookmark != "none" {
+	if fromTime != "none" {
+		// Command StartTime: Sync and start streaming receive from the time
+		t, err := time.Parse(time.RFC3339, fromTime)
+		if err != nil {
+			return err
+		}
+		err = c.StreamFromTime(context.Background(), t)
+		if err != nil {
+			return err
+		}
+	} else if fromBookmark != "none" {
 		// Command StartBookmark: Sync and start streaming receive from bookmark
 		fromBookNum, err := strconv.Atoi(fromBookmark)
 		if err != nil {
@@ -423,39 +481,48 @@ func runClient(ctx *cli.Context) error {
 		}
 		bookmark := []byte{0} // nolint:gomnd
 		bookmark = binary.LittleEndian.AppendUint64(bookmark, uint64(fromBookNum))
-		c.FromBookmark = bookmark
-		err = c.ExecCommand(datastreamer.CmdStartBookmark)
+		err = c.StreamFromBookmark(context.Background(), bookmark)
 		if err != nil {
 			return err
 		}
 	} else {
 		// Command start: Sync and start streaming receive from entry number
-		if from == "latest" { // nolint:gomnd
-			c.FromEntry = c.Header.TotalEntries
-		} else {
+		fromEntry := header.TotalEntries
+		if from != "latest" { // nolint:gomnd
 			fromNum, err := strconv.Atoi(from)
 			if err != nil {
 				return err
 			}
-			c.FromEntry = uint64(fromNum)
+			fromEntry = uint64(fromNum)
 		}
-		err = c.ExecCommand(datastreamer.CmdStart)
+		sanityFromEntry = fromEntry
+		err = c.StreamFrom(context.Background(), fromEntry)
 		if err != nil {
 			return err
 		}
 	}
 
-	// After the initial sync, run until Ctl+C
+	// After the initial sync, run until Ctl+C or entry processing error
 	interruptSignal := make(chan os.Signal, 1)
 	signal.Notify(interruptSignal, os.Interrupt, syscall.SIGTERM)
-	<-interruptSignal
+	select {
+	case <-interruptSignal:
+	case err = <-c.Errors():
+		return err
+	}
 
 	// Command stop: Stop streaming
-	err = c.ExecCommand(datastreamer.CmdStop)
+	err = c.StopStreaming(context.Background())
 	if err != nil {
 		return err
 	}
 
+	// Log compression ratio
+	if compression != datastreamer.CompressionNone {
+		stats := c.GetCompressionStats()
+		log.Infof("Compression: RawBytes[%d] CompressedBytes[%d] Ratio[%.2f]", stats.RawBytes, stats.CompressedBytes, stats.Ratio())
+	}
+
 	log.Info("Client stopped")
 	return nil
 }
@@ -468,38 +535,16 @@ func printEntryNum(e *datastreamer.FileEntry, c *datastreamer.StreamClient, s *d
 
 // checkEntryBlockSanity checks entry, bookmark, and block sequence consistency
 func checkEntryBlockSanity(e *datastreamer.FileEntry, c *datastreamer.StreamClient, s *datastreamer.StreamServer) error {
-	// Sanity check initialization
-	if !initSanityEntry {
-		initSanityEntry = true
-		if c.FromEntry > 0 {
-			sanityEntry = c.FromEntry
-		} else {
-			sanityEntry = 0
-		}
-	}
-
 	// Log work in progress
 	if e.Number%100000 == 0 {
 		log.Infof("Checking entry #%d...", e.Number)
 	}
 
-	// Sanity check for entry sequence
-	if sanityEntry > 0 {
-		if e.Number != sanityEntry {
-			if e.Number < sanityEntry {
-				log.Warnf("(X) SANITY CHECK failed: REPEATED entries? Received[%d] | Entry expected[%d]", e.Number, sanityEntry)
-			} else {
-				log.Warnf("(X) SANITY CHECK failed: GAP entries? Received[%d] | Entry expected[%d]", e.Number, sanityEntry)
-			}
-			return errors.New("sanity check failed for entry sequence")
-		}
-	} else {
-		if e.Number != 0 {
-			log.Warnf("(X) SANITY CHECK failed: Entry received[%d] | Entry expected[0]", e.Number)
-			return errors.New("sanity check failed for entry sequence")
-		}
+	// Entry sequence checked by the client (gaps close it, repeated entries are discarded)
+	if stats := c.GetSequenceStats(); stats.Duplicates > 0 {
+		log.Warnf("(X) SANITY CHECK failed: REPEATED entries received: %d", stats.Duplicates)
+		return errors.New("sanity check failed for entry sequence")
 	}
-	sanityEntry++
 
 	// Sanity check for block sequence
 	if e.Type == EtL2BlockStart {
@@ -556,8 +601,8 @@ func checkEntryBlockSanity(e *datastreamer.FileEntry, c *datastreamer.StreamClie
 	}
 
 	// Sanity check end condition
-	if e.Number+1 >= c.Header.TotalEntries {
-		log.Infof("SANITY CHECK finished! From entry [%d] to entry [%d]", c.FromEntry, c.Header.TotalEntries-1)
+	if e.Number+1 >= sanityTotalEntries {
+		log.Infof("SANITY CHECK finished! From entry [%d] to entry [%d]", sanityFromEntry, sanityTotalEntries-1)
 		return errors.New("sanity check finished")
 	}
 
@@ -597,10 +642,15 @@ func runRelay(ctx *cli.Context) error {
 		return err
 	}
 
-	// Run until Ctl+C
+	// Run until Ctl+C or relay error
 	interruptSignal := make(chan os.Signal, 1)
 	signal.Notify(interruptSignal, os.Interrupt, syscall.SIGTERM)
-	<-interruptSignal
+	select {
+	case <-interruptSignal:
+	case err = <-r.Errors():
+		log.Error(">> App error! Relay")
+		return err
+	}
 
 	log.Info(">> App end")
 	return nil
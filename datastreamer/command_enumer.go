@@ -6,9 +6,9 @@ import (
 	"fmt"
 )
 
-const _CommandName = "CmdStartCmdStopCmdHeaderCmdStartBookmarkCmdEntryCmdBookmark"
+const _CommandName = "CmdStartCmdStopCmdHeaderCmdStartBookmarkCmdEntryCmdBookmarkCmdCompressionCmdPingCmdLatestEntriesCmdStartTimeCmdEntryTimeCmdTimestamps"
 
-var _CommandIndex = [...]uint8{0, 8, 15, 24, 40, 48, 59}
+var _CommandIndex = [...]uint8{0, 8, 15, 24, 40, 48, 59, 73, 80, 96, 108, 120, 133}
 
 func (i Command) String() string {
 	i -= 1
@@ -18,15 +18,21 @@ func (i Command) String() string {
 	return _CommandName[_CommandIndex[i]:_CommandIndex[i+1]]
 }
 
-var _CommandValues = []Command{1, 2, 3, 4, 5, 6}
+var _CommandValues = []Command{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}
 
 var _CommandNameToValueMap = map[string]Command{
-	_CommandName[0:8]:   1,
-	_CommandName[8:15]:  2,
-	_CommandName[15:24]: 3,
-	_CommandName[24:40]: 4,
-	_CommandName[40:48]: 5,
-	_CommandName[48:59]: 6,
+	_CommandName[0:8]:     1,
+	_CommandName[8:15]:    2,
+	_CommandName[15:24]:   3,
+	_CommandName[24:40]:   4,
+	_CommandName[40:48]:   5,
+	_CommandName[48:59]:   6,
+	_CommandName[59:73]:   7,
+	_CommandName[73:80]:   8,
+	_CommandName[80:96]:   9,
+	_CommandName[96:108]:  10,
+	_CommandName[108:120]: 11,
+	_CommandName[120:133]: 12,
 }
 
 // CommandString retrieves an enum value from the enum constants string name.
@@ -1,6 +1,24 @@
 package datastreamer
 
-import "github.com/0xPolygonHermez/zkevm-data-streamer/log"
+import (
+	"time"
+
+	"github.com/0xPolygonHermez/zkevm-data-streamer/log"
+)
+
+// DurabilityMode type for the durability level of the commits
+type DurabilityMode string
+
+const (
+	DurabilityBuffered DurabilityMode = "buffered" // DurabilityBuffered for commits buffered by the OS (no fsync)
+	DurabilityFsync    DurabilityMode = "fsync"    // DurabilityFsync for fsync on every commit
+	DurabilityGroup    DurabilityMode = "group"    // DurabilityGroup for fsync of a group of commits every interval or number of commits
+)
+
+const (
+	defaultGroupCommitInterval   = 10 * time.Millisecond // Default maximum time between flushes in group durability mode
+	defaultGroupCommitMaxCommits = 100                   // Default number of pending commits to flush in group durability mode
+)
 
 // Config type for datastreamer server
 type Config struct {
@@ -8,6 +26,86 @@ type Config struct {
 	Port uint16 `mapstructure:"Port"`
 	// Filename of the binary data file
 	Filename string `mapstructure:"Filename"`
+	// Durability level of the commits (buffered|fsync|group), default buffered
+	Durability DurabilityMode `mapstructure:"Durability"`
+	// GroupCommitInterval is the maximum time a commit waits to be flushed in group durability mode
+	GroupCommitInterval time.Duration `mapstructure:"GroupCommitInterval"`
+	// GroupCommitMaxCommits is the number of pending commits that triggers a flush in group durability mode
+	GroupCommitMaxCommits uint64 `mapstructure:"GroupCommitMaxCommits"`
+	// MmapReads enables the reads of the stream file through a shared memory mapping
+	MmapReads bool `mapstructure:"MmapReads"`
+	// CacheEntries is the number of recent committed entries kept in memory to serve reads (0 disables the cache)
+	CacheEntries uint64 `mapstructure:"CacheEntries"`
+	// CompressPages enables the compression of the sealed data pages (the file can't be read by older versions)
+	CompressPages bool `mapstructure:"CompressPages"`
+	// HeartbeatInterval is the interval to send ping packets to the clients (0 disables)
+	HeartbeatInterval time.Duration `mapstructure:"HeartbeatInterval"`
+	// HeartbeatTimeout is the maximum time without commands from a client before killing it (0 disables)
+	HeartbeatTimeout time.Duration `mapstructure:"HeartbeatTimeout"`
+	// CommandTimeout is the maximum time to receive the parameters of a client command once started (0 disables)
+	CommandTimeout time.Duration `mapstructure:"CommandTimeout"`
+	// WriteTimeout is the maximum time to send a packet or a data range to a client (0 disables)
+	WriteTimeout time.Duration `mapstructure:"WriteTimeout"`
 	// Log
 	Log log.Config `mapstructure:"Log"`
 }
+
+// ClientConfig type for datastreamer client
+type ClientConfig struct {
+	// Server address to connect (IP:port)
+	Server string `mapstructure:"Server"`
+	// Endpoints are the server addresses to failover on connection loss (overrides Server)
+	Endpoints []Endpoint `mapstructure:"Endpoints"`
+	// StreamType of the stream
+	StreamType StreamType `mapstructure:"StreamType"`
+	// Compression of the streamed data entries requested to the server (0:none, 1:snappy)
+	Compression CompressionMode `mapstructure:"Compression"`
+	// HeartbeatInterval is the interval to send ping commands to the server (0 disables)
+	HeartbeatInterval time.Duration `mapstructure:"HeartbeatInterval"`
+	// HeartbeatTimeout is the maximum time without packets from the server before reconnecting (0 disables)
+	HeartbeatTimeout time.Duration `mapstructure:"HeartbeatTimeout"`
+	// CommandTimeout is the maximum time to send a command and receive its response (0 disables)
+	CommandTimeout time.Duration `mapstructure:"CommandTimeout"`
+	// Reconnect is the policy of the reconnection attempts to the server
+	Reconnect ReconnectPolicy `mapstructure:"Reconnect"`
+	// GapPolicy is the action on a gap in the streamed entries sequence: 0 error (default), 1 refetch, 2 reconnect
+	GapPolicy GapPolicy `mapstructure:"GapPolicy"`
+	// Timestamps requests to the server the commit time of the streamed entries (FileEntry Timestamp field)
+	Timestamps bool `mapstructure:"Timestamps"`
+}
+
+// ReaderConfig type for the local reader of a stream file
+type ReaderConfig struct {
+	// Filename of the binary data file (the bookmarks database is the one of the server)
+	Filename string `mapstructure:"Filename"`
+	// StreamType of the stream
+	StreamType StreamType `mapstructure:"StreamType"`
+	// PollInterval is the interval to check the file header for new entries when the streaming is tailing (default 100ms)
+	PollInterval time.Duration `mapstructure:"PollInterval"`
+}
+
+// Endpoint type for a server address of the datastreamer client
+type Endpoint struct {
+	// Server address to connect (IP:port)
+	Server string `mapstructure:"Server"`
+	// Priority of the endpoint, the lower value is tried first
+	Priority int `mapstructure:"Priority"`
+}
+
+// ReconnectPolicy type for the reconnection attempts of the datastreamer client
+type ReconnectPolicy struct {
+	// InitialDelay is the delay before the first retry (default 5s)
+	InitialDelay time.Duration `mapstructure:"InitialDelay"`
+	// MaxDelay is the maximum delay between retries (0 for no maximum)
+	MaxDelay time.Duration `mapstructure:"MaxDelay"`
+	// Multiplier of the delay after each retry (default 1, fixed delay)
+	Multiplier float64 `mapstructure:"Multiplier"`
+	// Jitter is the random fraction of the delay added or subtracted to each delay (e.g. 0.2 for ±20%)
+	Jitter float64 `mapstructure:"Jitter"`
+	// MaxAttempts is the number of connection attempts (to every endpoint) before giving up and closing the client (0 for unlimited)
+	MaxAttempts int `mapstructure:"MaxAttempts"`
+	// EndpointAttempts is the number of connection attempts to an endpoint before failing over to the next one (default 1)
+	EndpointAttempts int `mapstructure:"EndpointAttempts"`
+	// FailbackInterval is the interval to try to reconnect to the highest priority endpoint while connected to another one (0 disables)
+	FailbackInterval time.Duration `mapstructure:"FailbackInterval"`
+}
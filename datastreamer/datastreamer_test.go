@@ -1,16 +1,25 @@
 package datastreamer_test
 
 import (
+	"context"
 	"encoding/binary"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/0xPolygonHermez/zkevm-data-streamer/datastreamer"
 	"github.com/0xPolygonHermez/zkevm-data-streamer/log"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
@@ -102,7 +111,9 @@ var (
 			Outputs:     []string{"stdout"},
 		},
 	}
-	leveldb      = config.Filename[0:strings.IndexRune(config.Filename, '.')] + ".db"
+	leveldb      = strings.TrimSuffix(config.Filename, filepath.Ext(config.Filename)) + ".db"
+	tsIndex      = strings.TrimSuffix(config.Filename, filepath.Ext(config.Filename)) + ".tsi"
+	pageJournal  = strings.TrimSuffix(config.Filename, filepath.Ext(config.Filename)) + ".jnl"
 	streamServer *datastreamer.StreamServer
 	streamType   = datastreamer.StreamType(1)
 	entryType1   = datastreamer.EntryType(1)
@@ -187,9 +198,70 @@ func deleteFiles() error {
 		return err
 	}
 
+	// Delete timestamp index from filesystem
+	err = os.Remove(tsIndex)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	// Delete data pages rewrite journal from filesystem
+	err = os.Remove(pageJournal)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
 	return nil
 }
 
+// testFileName returns the name of a stream file in a temporary directory, removed with the bookmarks
+// database and the rest of the files of the stream at the end of the test
+func testFileName(t testing.TB) string {
+	return filepath.Join(t.TempDir(), "datastream.bin")
+}
+
+// waitCompressedPages waits for the background compression of the data pages sealed before a stream length,
+// until the stream file is not being rewritten
+func waitCompressedPages(t testing.TB, fileName string, totalLength uint64) {
+	file, err := os.Open(fileName)
+	require.NoError(t, err)
+	defer file.Close()
+
+	const rewriteSeqPos = 16 + 29 + 1
+	buffer := make([]byte, 8)
+	require.Eventually(t, func() bool {
+		for pos := int64(datastreamer.PageHeaderSize); pos+datastreamer.PageDataSize < int64(totalLength); pos = pos + datastreamer.PageDataSize {
+			_, err = file.ReadAt(buffer[:1], pos)
+			if err != nil || buffer[0] != datastreamer.PtCompressed {
+				return false
+			}
+		}
+		_, err = file.ReadAt(buffer, rewriteSeqPos)
+		return err == nil && binary.BigEndian.Uint64(buffer)%2 == 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+// testPort returns a free TCP port to listen on
+func testPort(t testing.TB) uint16 {
+	ln, err := net.Listen("tcp", ":0")
+	require.NoError(t, err)
+	defer ln.Close()
+	return uint16(ln.Addr().(*net.TCPAddr).Port)
+}
+
+// newTestServer creates and starts a server with the configuration on a free port (with a stream file in a
+// temporary directory if the file name is not set). Returns the server and its address
+func newTestServer(t testing.TB, cfg datastreamer.Config) (*datastreamer.StreamServer, string) {
+	if cfg.Filename == "" {
+		cfg.Filename = testFileName(t)
+	}
+	cfg.Port = testPort(t)
+	server, err := datastreamer.NewServerWithConfig(cfg, streamType)
+	require.NoError(t, err)
+	err = server.Start()
+	require.NoError(t, err)
+	return server, fmt.Sprintf("localhost:%d", cfg.Port)
+}
+
 func TestServer(t *testing.T) {
 	err := deleteFiles()
 	if err != nil {
@@ -473,15 +545,21 @@ func TestClient(t *testing.T) {
 	err = client.ExecCommand(datastreamer.CmdStartBookmark)
 	require.NoError(t, err)
 
-	// Case: Query entry data with streaming started -> FAIL
-	// client.FromEntry = 2
-	// err = client.ExecCommand(datastreamer.CmdEntry)
-	// require.EqualError(t, datastreamer.ErrResultCommandError, err.Error())
+	// Case: Query entry data with streaming started -> OK
+	client.FromEntry = 2
+	err = client.ExecCommand(datastreamer.CmdEntry)
+	require.NoError(t, err)
+	require.Equal(t, testEntries[2], TestEntry{}.Decode(client.Entry.Data))
 
-	// Case: Query bookmark data with streaming started -> FAIL
-	// client.FromBookmark = testBookmark.Encode()
-	// err = client.ExecCommand(datastreamer.CmdBookmark)
-	// require.EqualError(t, datastreamer.ErrResultCommandError, err.Error())
+	// Case: Query bookmark data with streaming started -> OK
+	client.FromBookmark = testBookmark.Encode()
+	err = client.ExecCommand(datastreamer.CmdBookmark)
+	require.NoError(t, err)
+
+	// Case: Query header info with streaming started -> OK
+	err = client.ExecCommand(datastreamer.CmdHeader)
+	require.NoError(t, err)
+	require.Equal(t, headerEntry.TotalEntries, client.Header.TotalEntries)
 
 	// Case: Stop receiving streaming -> OK
 	err = client.ExecCommand(datastreamer.CmdStop)
@@ -493,3 +571,2541 @@ func TestClient(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, testEntries[2], TestEntry{}.Decode(client.Entry.Data))
 }
+
+func TestTruncateBookmarks(t *testing.T) {
+	server, _ := newTestServer(t, datastreamer.Config{})
+
+	// Add 3 bookmarks with an entry after each one
+	bookmarks := [][]byte{{0, 1}, {0, 2}, {0, 3}}
+	for _, b := range bookmarks {
+		err := server.StartAtomicOp()
+		require.NoError(t, err)
+		_, err = server.AddStreamBookmark(b)
+		require.NoError(t, err)
+		_, err = server.AddStreamEntry(entryType1, testEntries[1].Encode())
+		require.NoError(t, err)
+		err = server.CommitAtomicOp()
+		require.NoError(t, err)
+	}
+
+	// Case: Truncate from the second bookmark entry -> bookmarks from it deleted
+	err := server.TruncateFile(2)
+	require.NoError(t, err)
+
+	entryNumber, err := server.GetBookmark(bookmarks[0])
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), entryNumber)
+
+	_, err = server.GetBookmark(bookmarks[1])
+	require.EqualError(t, errors.New("leveldb: not found"), err.Error())
+	_, err = server.GetBookmark(bookmarks[2])
+	require.EqualError(t, errors.New("leveldb: not found"), err.Error())
+
+	// Case: New bookmark after truncate points to the new entry -> OK
+	err = server.StartAtomicOp()
+	require.NoError(t, err)
+	entryNumber, err = server.AddStreamBookmark(bookmarks[1])
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), entryNumber)
+	err = server.CommitAtomicOp()
+	require.NoError(t, err)
+
+	entryNumber, err = server.GetBookmark(bookmarks[1])
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), entryNumber)
+
+	// Update the first bookmark twice (entries 3 and 5)
+	for i := 0; i < 2; i++ {
+		err = server.StartAtomicOp()
+		require.NoError(t, err)
+		_, err = server.AddStreamBookmark(bookmarks[0])
+		require.NoError(t, err)
+		_, err = server.AddStreamEntry(entryType1, testEntries[1].Encode())
+		require.NoError(t, err)
+		err = server.CommitAtomicOp()
+		require.NoError(t, err)
+	}
+	entryNumber, err = server.GetBookmark(bookmarks[0])
+	require.NoError(t, err)
+	require.Equal(t, uint64(5), entryNumber)
+
+	// Case: Truncate the last update of a bookmark -> previous value restored
+	err = server.TruncateFile(5)
+	require.NoError(t, err)
+	entryNumber, err = server.GetBookmark(bookmarks[0])
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), entryNumber)
+
+	// Case: Truncate the restored value -> value located in the stream file
+	err = server.TruncateFile(3)
+	require.NoError(t, err)
+	entryNumber, err = server.GetBookmark(bookmarks[0])
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), entryNumber)
+	entryNumber, err = server.GetBookmark(bookmarks[1])
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), entryNumber)
+}
+
+func TestBookmarksConsistency(t *testing.T) {
+	fileName := testFileName(t)
+	reopenName := testFileName(t)
+	reopenDbName := strings.TrimSuffix(reopenName, ".bin") + ".db"
+
+	server, _ := newTestServer(t, datastreamer.Config{Filename: fileName})
+
+	// Add 2 bookmarks with an entry after each one
+	bookmarks := [][]byte{{0, 1}, {0, 2}}
+	for _, b := range bookmarks {
+		err := server.StartAtomicOp()
+		require.NoError(t, err)
+		_, err = server.AddStreamBookmark(b)
+		require.NoError(t, err)
+		_, err = server.AddStreamEntry(entryType1, testEntries[1].Encode())
+		require.NoError(t, err)
+		err = server.CommitAtomicOp()
+		require.NoError(t, err)
+	}
+
+	// Copy of the stream file with a bookmarks DB having also bookmarks past the end of the file
+	data, err := os.ReadFile(fileName)
+	require.NoError(t, err)
+	err = os.WriteFile(reopenName, data, 0666)
+	require.NoError(t, err)
+
+	db, err := datastreamer.NewBookmark(reopenDbName)
+	require.NoError(t, err)
+	require.NoError(t, db.AddBookmark(bookmarks[0], 0))
+	require.NoError(t, db.AddBookmark(bookmarks[1], 6))
+	require.NoError(t, db.AddBookmark([]byte{0, 3}, 4))
+	require.NoError(t, db.AddBookmark([]byte{0, 4}, 10))
+	require.NoError(t, db.Close())
+
+	// Case: Reopen the stream -> bookmarks past the end restored from the file or removed, valid ones kept
+	reopened, err := datastreamer.NewServerWithConfig(datastreamer.Config{Port: testPort(t), Filename: reopenName}, streamType)
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), reopened.GetHeader().TotalEntries)
+
+	entryNumber, err := reopened.GetBookmark(bookmarks[0])
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), entryNumber)
+	entryNumber, err = reopened.GetBookmark(bookmarks[1])
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), entryNumber)
+
+	_, err = reopened.GetBookmark([]byte{0, 3})
+	require.EqualError(t, errors.New("leveldb: not found"), err.Error())
+	_, err = reopened.GetBookmark([]byte{0, 4})
+	require.EqualError(t, errors.New("leveldb: not found"), err.Error())
+}
+
+func TestBookmarksMigration(t *testing.T) {
+	fileName := testFileName(t)
+	reopenName := testFileName(t)
+	reopenDbName := strings.TrimSuffix(reopenName, ".bin") + ".db"
+
+	server, _ := newTestServer(t, datastreamer.Config{Filename: fileName})
+
+	// Add a bookmark twice and another one after it, with an entry after each one
+	bookmarks := [][]byte{{0, 1}, {0, 1}, {0, 2}}
+	for _, b := range bookmarks {
+		err := server.StartAtomicOp()
+		require.NoError(t, err)
+		_, err = server.AddStreamBookmark(b)
+		require.NoError(t, err)
+		_, err = server.AddStreamEntry(entryType1, testEntries[1].Encode())
+		require.NoError(t, err)
+		err = server.CommitAtomicOp()
+		require.NoError(t, err)
+	}
+
+	// Copy of the stream file with a bookmarks DB written without the previous entry numbers
+	data, err := os.ReadFile(fileName)
+	require.NoError(t, err)
+	err = os.WriteFile(reopenName, data, 0666)
+	require.NoError(t, err)
+
+	db, err := datastreamer.NewBookmark(reopenDbName)
+	require.NoError(t, err)
+	require.NoError(t, db.AddBookmark(bookmarks[1], 2))
+	require.NoError(t, db.AddBookmark(bookmarks[2], 4))
+	require.NoError(t, db.Close())
+
+	reopened, err := datastreamer.NewServerWithConfig(datastreamer.Config{Port: testPort(t), Filename: reopenName}, streamType)
+	require.NoError(t, err)
+
+	// Case: Truncate the last update of a migrated bookmark -> previous value restored
+	err = reopened.TruncateFile(4)
+	require.NoError(t, err)
+	_, err = reopened.GetBookmark(bookmarks[2])
+	require.EqualError(t, errors.New("leveldb: not found"), err.Error())
+
+	err = reopened.TruncateFile(2)
+	require.NoError(t, err)
+	entryNumber, err := reopened.GetBookmark(bookmarks[0])
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), entryNumber)
+}
+
+func TestBookmarksLegacyDbName(t *testing.T) {
+	fileName := testFileName(t)
+	reopenName := filepath.Join(t.TempDir(), "datastream.v1.bin")
+	legacyDbName := strings.TrimSuffix(reopenName, ".v1.bin") + ".db"
+
+	server, _ := newTestServer(t, datastreamer.Config{Filename: fileName})
+
+	// Add a bookmark with an entry after it
+	err := server.StartAtomicOp()
+	require.NoError(t, err)
+	_, err = server.AddStreamBookmark(testBookmark.Encode())
+	require.NoError(t, err)
+	_, err = server.AddStreamEntry(entryType1, testEntries[1].Encode())
+	require.NoError(t, err)
+	err = server.CommitAtomicOp()
+	require.NoError(t, err)
+
+	// Copy of the stream file with a dot in its name and the bookmarks DB named cutting it at the first dot
+	data, err := os.ReadFile(fileName)
+	require.NoError(t, err)
+	err = os.WriteFile(reopenName, data, 0666)
+	require.NoError(t, err)
+
+	db, err := datastreamer.NewBookmark(legacyDbName)
+	require.NoError(t, err)
+	require.NoError(t, db.AddBookmark(testBookmark.Encode(), 0))
+	require.NoError(t, db.Close())
+
+	// Case: Reopen the stream -> bookmarks DB with the legacy name used
+	reopened, err := datastreamer.NewServerWithConfig(datastreamer.Config{Port: testPort(t), Filename: reopenName}, streamType)
+	require.NoError(t, err)
+	entryNumber, err := reopened.GetBookmark(testBookmark.Encode())
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), entryNumber)
+	_, err = os.Stat(strings.TrimSuffix(reopenName, ".bin") + ".db")
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestAtomicOpBookmarks(t *testing.T) {
+	server, _ := newTestServer(t, datastreamer.Config{})
+
+	// Case: Bookmark not visible until the atomic operation is committed -> OK
+	err := server.StartAtomicOp()
+	require.NoError(t, err)
+	entryNumber, err := server.AddStreamBookmark(testBookmark.Encode())
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), entryNumber)
+
+	_, err = server.GetBookmark(testBookmark.Encode())
+	require.EqualError(t, errors.New("leveldb: not found"), err.Error())
+
+	err = server.CommitAtomicOp()
+	require.NoError(t, err)
+
+	entryNumber, err = server.GetBookmark(testBookmark.Encode())
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), entryNumber)
+
+	// Case: Bookmark of a rolled back atomic operation is discarded -> OK
+	err = server.StartAtomicOp()
+	require.NoError(t, err)
+	_, err = server.AddStreamBookmark(nonAddedBookmark.Encode())
+	require.NoError(t, err)
+	err = server.RollbackAtomicOp()
+	require.NoError(t, err)
+
+	_, err = server.GetBookmark(nonAddedBookmark.Encode())
+	require.EqualError(t, errors.New("leveldb: not found"), err.Error())
+}
+
+func TestPageJournalRecovery(t *testing.T) {
+	fileName := testFileName(t)
+	reopenName := testFileName(t)
+	journalName := strings.TrimSuffix(reopenName, ".bin") + ".jnl"
+
+	server, _ := newTestServer(t, datastreamer.Config{Filename: fileName})
+
+	err := server.StartAtomicOp()
+	require.NoError(t, err)
+	for i := 1; i <= 3; i++ {
+		_, err = server.AddStreamEntry(entryType1, testEntries[i].Encode())
+		require.NoError(t, err)
+	}
+	err = server.CommitAtomicOp()
+	require.NoError(t, err)
+	totalLength := server.GetHeader().TotalLength
+
+	// Copy of the stream file with its data entries partially rewritten, and the journal of the original content
+	data, err := os.ReadFile(fileName)
+	require.NoError(t, err)
+	original := append([]byte{}, data[datastreamer.PageHeaderSize:totalLength]...)
+	copy(data[datastreamer.PageHeaderSize+20:totalLength], make([]byte, 30))
+	err = os.WriteFile(reopenName, data, 0666)
+	require.NoError(t, err)
+
+	journal := binary.BigEndian.AppendUint64(nil, datastreamer.PageHeaderSize)
+	journal = binary.BigEndian.AppendUint32(journal, uint32(len(original)))
+	journal = append(journal, original...)
+	journal = binary.BigEndian.AppendUint32(journal, crc32.ChecksumIEEE(journal))
+	err = os.WriteFile(journalName, journal, 0666)
+	require.NoError(t, err)
+
+	// Case: Reopen the stream after a rewrite interrupted by a crash -> original content restored
+	reopened, err := datastreamer.NewServerWithConfig(datastreamer.Config{Port: testPort(t), Filename: reopenName}, streamType)
+	require.NoError(t, err)
+	require.Equal(t, totalLength, reopened.GetHeader().TotalLength)
+
+	for i := 1; i <= 3; i++ {
+		entry, err := reopened.GetEntry(uint64(i - 1))
+		require.NoError(t, err)
+		require.Equal(t, testEntries[i], TestEntry{}.Decode(entry.Data))
+	}
+
+	info, err := os.Stat(journalName)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), info.Size())
+}
+
+func TestUpdateEntryDataMode(t *testing.T) {
+	server, _ := newTestServer(t, datastreamer.Config{})
+
+	err := server.StartAtomicOp()
+	require.NoError(t, err)
+	for i := 1; i <= 3; i++ {
+		_, err = server.AddStreamEntry(entryType1, testEntries[i].Encode())
+		require.NoError(t, err)
+	}
+	err = server.CommitAtomicOp()
+	require.NoError(t, err)
+	totalLength := server.GetHeader().TotalLength
+
+	// Case: Update entry data changing data length without resize mode -> FAIL
+	err = server.UpdateEntryDataMode(1, entryType1, testEntries[4].Encode(), datastreamer.UmStrict)
+	require.EqualError(t, datastreamer.ErrUpdateEntryDifferentSize, err.Error())
+
+	// Case: Update entry data to a bigger data length in the last data page -> OK
+	delta := uint64(len(testEntries[4].Encode()) - len(testEntries[2].Encode()))
+	err = server.UpdateEntryDataMode(1, entryType1, testEntries[4].Encode(), datastreamer.UmAllowResize)
+	require.NoError(t, err)
+	require.Equal(t, totalLength+delta, server.GetHeader().TotalLength)
+
+	for i, expected := range []TestEntry{testEntries[1], testEntries[4], testEntries[3]} {
+		entry, err := server.GetEntry(uint64(i))
+		require.NoError(t, err)
+		require.Equal(t, uint64(i), entry.Number)
+		require.Equal(t, expected, TestEntry{}.Decode(entry.Data))
+	}
+
+	// Case: Update entry data to a smaller data length in the last data page -> OK
+	err = server.UpdateEntryDataMode(1, entryType1, testEntries[2].Encode(), datastreamer.UmAllowResize)
+	require.NoError(t, err)
+	require.Equal(t, totalLength, server.GetHeader().TotalLength)
+
+	entry, err := server.GetEntry(2)
+	require.NoError(t, err)
+	require.Equal(t, testEntries[3], TestEntry{}.Decode(entry.Data))
+
+	// Case: Update entry type without type change mode -> FAIL
+	err = server.UpdateEntryDataMode(1, entryType2, testEntries[2].Encode(), datastreamer.UmAllowResize)
+	require.EqualError(t, datastreamer.ErrUpdateEntryTypeNotAllowed, err.Error())
+
+	// Case: Update entry type with type change mode -> OK
+	err = server.UpdateEntryDataMode(1, entryType2, testEntries[2].Encode(), datastreamer.UmAllowTypeChange)
+	require.NoError(t, err)
+
+	entry, err = server.GetEntry(1)
+	require.NoError(t, err)
+	require.Equal(t, entryType2, entry.Type)
+	require.Equal(t, testEntries[2], TestEntry{}.Decode(entry.Data))
+
+	// Case: Iterate forward while a previous entry is resized, moving the following ones -> OK
+	it := server.NewIterator(false)
+	err = it.From(0)
+	require.NoError(t, err)
+	end, err := it.Next()
+	require.NoError(t, err)
+	require.False(t, end)
+	require.Equal(t, uint64(0), it.Entry().Number)
+
+	err = server.UpdateEntryDataMode(0, entryType1, testEntries[4].Encode(), datastreamer.UmAllowResize)
+	require.NoError(t, err)
+
+	for i, expected := range []TestEntry{testEntries[2], testEntries[3]} {
+		end, err = it.Next()
+		require.NoError(t, err)
+		require.False(t, end)
+		require.Equal(t, uint64(i+1), it.Entry().Number)
+		require.Equal(t, expected, TestEntry{}.Decode(it.Entry().Data))
+	}
+	it.Close()
+
+	// Fill the first data page until an entry goes to the second one
+	pageEnd := uint64(datastreamer.PageHeaderSize + datastreamer.PageDataSize)
+	var padding uint64
+	err = server.StartAtomicOp()
+	require.NoError(t, err)
+	for server.GetHeader().TotalLength < pageEnd {
+		padding = pageEnd - server.GetHeader().TotalLength
+		_, err = server.AddStreamEntry(entryType1, testEntries[4].Encode())
+		require.NoError(t, err)
+		err = server.CommitAtomicOp()
+		require.NoError(t, err)
+		err = server.StartAtomicOp()
+		require.NoError(t, err)
+	}
+	err = server.RollbackAtomicOp()
+	require.NoError(t, err)
+	lastEntry := server.GetHeader().TotalEntries - 1
+
+	// Case: Update entry data in a sealed data page exceeding the pad space (not relocated) -> FAIL
+	grown := append(testEntries[2].Encode(), make([]byte, padding+1)...)
+	err = server.UpdateEntryDataMode(2, entryType1, grown, datastreamer.UmAllowResize)
+	require.EqualError(t, datastreamer.ErrUpdateEntryNotFitInPage, err.Error())
+
+	// Case: Update entry data in a sealed data page using the pad space -> OK
+	grown = append(testEntries[3].Encode(), make([]byte, padding)...)
+	err = server.UpdateEntryDataMode(2, entryType1, grown, datastreamer.UmAllowResize)
+	require.NoError(t, err)
+
+	entry, err = server.GetEntry(2)
+	require.NoError(t, err)
+	require.Equal(t, grown, entry.Data)
+
+	entry, err = server.GetEntry(lastEntry - 1)
+	require.NoError(t, err)
+	require.Equal(t, testEntries[4], TestEntry{}.Decode(entry.Data))
+
+	entry, err = server.GetEntry(lastEntry)
+	require.NoError(t, err)
+	require.Equal(t, lastEntry, entry.Number)
+	require.Equal(t, testEntries[4], TestEntry{}.Decode(entry.Data))
+}
+
+func TestUpdateEntryDataStalledClient(t *testing.T) {
+	server, address := newTestServer(t, datastreamer.Config{})
+
+	// Entries filling several data pages, more than the socket buffers can hold
+	entryData := func(n uint64) []byte {
+		data := make([]byte, 100*1024)
+		binary.BigEndian.PutUint64(data, n)
+		return data
+	}
+	tx, err := server.Begin()
+	require.NoError(t, err)
+	for n := uint64(0); n < 100; n++ {
+		_, err = tx.AddEntry(entryType1, entryData(n))
+		require.NoError(t, err)
+	}
+	err = tx.Commit()
+	require.NoError(t, err)
+
+	// Client starting the streaming from the first entry without reading it
+	conn, err := net.Dial("tcp", address)
+	require.NoError(t, err)
+	defer conn.Close()
+	command := binary.BigEndian.AppendUint64(nil, uint64(datastreamer.CmdStart))
+	command = binary.BigEndian.AppendUint64(command, uint64(streamType))
+	command = binary.BigEndian.AppendUint64(command, 0)
+	_, err = conn.Write(command)
+	require.NoError(t, err)
+	time.Sleep(200 * time.Millisecond)
+
+	// Case: Update entry data while the streaming to the client is stalled -> OK
+	done := make(chan error, 1)
+	go func() {
+		done <- server.UpdateEntryData(1, entryType1, entryData(1001))
+	}()
+	select {
+	case err = <-done:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("update entry data blocked by the stalled client")
+	}
+	entry, err := server.GetEntry(1)
+	require.NoError(t, err)
+	require.Equal(t, entryData(1001), entry.Data)
+}
+
+func TestConcurrentTx(t *testing.T) {
+	server, err := datastreamer.NewServerWithConfig(datastreamer.Config{Port: testPort(t), Filename: testFileName(t)}, streamType)
+	require.NoError(t, err)
+
+	// Case: Begin transaction without starting the server -> FAIL
+	_, err = server.Begin()
+	require.Equal(t, datastreamer.ErrAtomicOpNotAllowed, err)
+
+	err = server.Start()
+	require.NoError(t, err)
+
+	// Case: Legacy API while a transaction is in progress -> FAIL
+	tx, err := server.Begin()
+	require.NoError(t, err)
+	err = server.StartAtomicOp()
+	require.Equal(t, datastreamer.ErrStartAtomicOpNotAllowed, err)
+	_, err = server.AddStreamEntry(entryType1, testEntries[1].Encode())
+	require.Equal(t, datastreamer.ErrAtomicOpOwnedByTx, err)
+	err = tx.Rollback()
+	require.NoError(t, err)
+
+	// Case: Use a finished transaction -> FAIL
+	_, err = tx.AddEntry(entryType1, testEntries[1].Encode())
+	require.Equal(t, datastreamer.ErrTxDone, err)
+
+	// Case: Concurrent writers using transactions -> OK
+	const writers = 8
+	const txPerWriter = 20
+	errs := make(chan error, writers)
+	for w := 0; w < writers; w++ {
+		go func(w int) {
+			for i := 0; i < txPerWriter; i++ {
+				tx, err := server.Begin()
+				if err != nil {
+					errs <- err
+					return
+				}
+				first, err := tx.AddBookmark([]byte{byte(w), byte(i)})
+				if err != nil {
+					errs <- err
+					return
+				}
+				for j := uint64(1); j <= 2; j++ {
+					entryNumber, err := tx.AddEntry(entryType1, testEntries[j].Encode())
+					if err != nil {
+						errs <- err
+						return
+					}
+					if entryNumber != first+j {
+						errs <- fmt.Errorf("entry %d not sequential in transaction from %d", entryNumber, first)
+						return
+					}
+				}
+				if err = tx.Commit(); err != nil {
+					errs <- err
+					return
+				}
+			}
+			errs <- nil
+		}(w)
+	}
+	for w := 0; w < writers; w++ {
+		require.NoError(t, <-errs)
+	}
+
+	require.Equal(t, uint64(writers*txPerWriter*3), server.GetHeader().TotalEntries)
+	entryNumber, err := server.GetBookmark([]byte{3, 7})
+	require.NoError(t, err)
+	entry, err := server.GetEntry(entryNumber)
+	require.NoError(t, err)
+	require.Equal(t, datastreamer.EntryType(datastreamer.EtBookmark), entry.Type)
+	require.Equal(t, []byte{3, 7}, entry.Data)
+}
+
+func TestDurabilityModes(t *testing.T) {
+	// Case: Invalid durability mode -> FAIL
+	_, err := datastreamer.NewServerWithConfig(datastreamer.Config{
+		Port:       testPort(t),
+		Filename:   testFileName(t),
+		Durability: "always",
+	}, streamType)
+	require.Equal(t, datastreamer.ErrInvalidDurabilityMode, err)
+
+	modes := []datastreamer.DurabilityMode{datastreamer.DurabilityBuffered, datastreamer.DurabilityFsync, datastreamer.DurabilityGroup}
+	for i, mode := range modes {
+		server, _ := newTestServer(t, datastreamer.Config{
+			Durability:            mode,
+			GroupCommitInterval:   time.Millisecond,
+			GroupCommitMaxCommits: 4,
+		})
+
+		// Case: Commits acknowledged in any durability mode -> OK
+		for j := 0; j < 10; j++ {
+			tx, err := server.Begin()
+			require.NoError(t, err)
+			_, err = tx.AddBookmark([]byte{byte(i), byte(j)})
+			require.NoError(t, err)
+			_, err = tx.AddEntry(entryType1, testEntries[1].Encode())
+			require.NoError(t, err)
+			err = tx.Commit()
+			require.NoError(t, err)
+		}
+		require.Equal(t, uint64(20), server.GetHeader().TotalEntries)
+
+		// Case: Update entry acknowledged in any durability mode -> OK
+		err = server.UpdateEntryData(1, entryType1, testEntries[2].Encode())
+		require.NoError(t, err)
+		entry, err := server.GetEntry(1)
+		require.NoError(t, err)
+		require.Equal(t, testEntries[2].Encode(), entry.Data)
+	}
+
+	// Group durability mode flushing only when 2 commits are pending
+	server, _ := newTestServer(t, datastreamer.Config{
+		Durability:            datastreamer.DurabilityGroup,
+		GroupCommitInterval:   time.Hour,
+		GroupCommitMaxCommits: 2,
+	})
+	commit := func(bookmark byte) chan error {
+		done := make(chan error, 1)
+		tx, err := server.Begin()
+		require.NoError(t, err)
+		_, err = tx.AddBookmark([]byte{bookmark})
+		require.NoError(t, err)
+		go func() { done <- tx.Commit() }()
+		return done
+	}
+
+	// Case: Commit not acknowledged nor visible until the group flush -> OK
+	first := commit(1)
+	select {
+	case err := <-first:
+		t.Fatalf("commit acknowledged before the group flush: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+	require.Equal(t, uint64(0), server.GetHeader().TotalEntries)
+	_, err = server.GetEntry(0)
+	require.Error(t, err)
+	_, err = server.GetBookmark([]byte{1})
+	require.Error(t, err)
+
+	// Case: Both commits acknowledged and visible after the group flush -> OK
+	second := commit(2)
+	for _, done := range []chan error{first, second} {
+		select {
+		case err := <-done:
+			require.NoError(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("commit not acknowledged after the group flush")
+		}
+	}
+	require.Equal(t, uint64(2), server.GetHeader().TotalEntries)
+	entryNum, err := server.GetBookmark([]byte{2})
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), entryNum)
+}
+
+func BenchmarkCommitDurability(b *testing.B) {
+	modes := []datastreamer.DurabilityMode{datastreamer.DurabilityBuffered, datastreamer.DurabilityFsync, datastreamer.DurabilityGroup}
+	for _, mode := range modes {
+		server, _ := newTestServer(b, datastreamer.Config{Durability: mode})
+
+		b.Run(string(mode), func(b *testing.B) {
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					tx, err := server.Begin()
+					if err != nil {
+						b.Error(err)
+						return
+					}
+					_, err = tx.AddEntry(entryType1, testEntries[1].Encode())
+					if err != nil {
+						b.Error(err)
+						return
+					}
+					err = tx.Commit()
+					if err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestStreamingCatchUp(t *testing.T) {
+	server, address := newTestServer(t, datastreamer.Config{})
+
+	// Entries of different sizes to get pad at the end of the data pages
+	entryData := func(n uint64) []byte {
+		data := make([]byte, 1000+(n*7919)%30000)
+		binary.BigEndian.PutUint64(data, n)
+		return data
+	}
+	addEntries := func(from uint64, count uint64) {
+		for n := from; n < from+count; n++ {
+			tx, err := server.Begin()
+			require.NoError(t, err)
+			_, err = tx.AddEntry(entryType1, entryData(n))
+			require.NoError(t, err)
+			err = tx.Commit()
+			require.NoError(t, err)
+		}
+	}
+	addEntries(0, 300)
+
+	// Client receiving the streaming
+	received := make(chan datastreamer.FileEntry, 1000)
+	client, err := datastreamer.NewClient(address, streamType)
+	require.NoError(t, err)
+	client.SetProcessEntryFunc(func(e *datastreamer.FileEntry, c *datastreamer.StreamClient, s *datastreamer.StreamServer) error {
+		received <- *e
+		return nil
+	})
+	err = client.Start()
+	require.NoError(t, err)
+
+	// Case: Sync from the first entry while adding new entries -> OK
+	client.FromEntry = 0
+	err = client.ExecCommand(datastreamer.CmdStart)
+	require.NoError(t, err)
+	addEntries(300, 100)
+
+	for n := uint64(0); n < 400; n++ {
+		select {
+		case e := <-received:
+			require.Equal(t, n, e.Number)
+			require.Equal(t, entryType1, e.Type)
+			require.Equal(t, entryData(n), e.Data)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("entry %d not received", n)
+		}
+	}
+}
+
+func BenchmarkBroadcast(b *testing.B) {
+	const clients = 100
+	const entriesPerAO = 10
+
+	server, address := newTestServer(b, datastreamer.Config{})
+
+	// Clients counting the received entries
+	var received int64
+	for i := 0; i < clients; i++ {
+		client, err := datastreamer.NewClient(address, streamType)
+		require.NoError(b, err)
+		client.SetProcessEntryFunc(func(e *datastreamer.FileEntry, c *datastreamer.StreamClient, s *datastreamer.StreamServer) error {
+			atomic.AddInt64(&received, 1)
+			return nil
+		})
+		err = client.Start()
+		require.NoError(b, err)
+		client.FromEntry = server.GetHeader().TotalEntries
+		err = client.ExecCommand(datastreamer.CmdStart)
+		require.NoError(b, err)
+	}
+
+	b.Run(fmt.Sprintf("clients=%d", clients), func(b *testing.B) {
+		atomic.StoreInt64(&received, 0)
+		start := time.Now()
+		for n := 0; n < b.N; n++ {
+			tx, err := server.Begin()
+			require.NoError(b, err)
+			for i := 0; i < entriesPerAO; i++ {
+				_, err = tx.AddEntry(entryType1, testEntries[1].Encode())
+				require.NoError(b, err)
+			}
+			err = tx.Commit()
+			require.NoError(b, err)
+		}
+
+		// Wait until all the clients received all the entries
+		total := int64(b.N * entriesPerAO * clients)
+		for atomic.LoadInt64(&received) < total {
+			time.Sleep(time.Millisecond)
+		}
+		b.ReportMetric(float64(total)/time.Since(start).Seconds(), "entries/s")
+	})
+}
+
+func TestMmapReads(t *testing.T) {
+	server, address := newTestServer(t, datastreamer.Config{
+		MmapReads: true,
+	})
+
+	// Entries filling a data page each, to extend the file (and remap it)
+	entryData := func(n uint64) []byte {
+		data := make([]byte, 600*1024)
+		binary.BigEndian.PutUint64(data, n)
+		return data
+	}
+	const entries = 105
+	for n := uint64(0); n < entries; n++ {
+		tx, err := server.Begin()
+		require.NoError(t, err)
+		_, err = tx.AddEntry(entryType1, entryData(n))
+		require.NoError(t, err)
+		err = tx.Commit()
+		require.NoError(t, err)
+	}
+
+	// Case: Get entries before and after the file extension -> OK
+	for _, n := range []uint64{0, 50, 99, 100, entries - 1} {
+		entry, err := server.GetEntry(n)
+		require.NoError(t, err)
+		require.Equal(t, n, entry.Number)
+		require.Equal(t, entryData(n), entry.Data)
+	}
+
+	// Case: Update entry data and read it from the mapping -> OK
+	err := server.UpdateEntryData(100, entryType1, testEntries[1].Encode())
+	require.Equal(t, datastreamer.ErrUpdateEntryDifferentSize, err)
+	updated := entryData(1000)
+	err = server.UpdateEntryData(100, entryType1, updated)
+	require.NoError(t, err)
+	entry, err := server.GetEntry(100)
+	require.NoError(t, err)
+	require.Equal(t, updated, entry.Data)
+
+	// Case: Stream from the mapping -> OK
+	received := make(chan datastreamer.FileEntry, entries)
+	client, err := datastreamer.NewClient(address, streamType)
+	require.NoError(t, err)
+	client.SetProcessEntryFunc(func(e *datastreamer.FileEntry, c *datastreamer.StreamClient, s *datastreamer.StreamServer) error {
+		received <- *e
+		return nil
+	})
+	err = client.Start()
+	require.NoError(t, err)
+	client.FromEntry = 95
+	err = client.ExecCommand(datastreamer.CmdStart)
+	require.NoError(t, err)
+
+	for n := uint64(95); n < entries; n++ {
+		select {
+		case e := <-received:
+			require.Equal(t, n, e.Number)
+			if n == 100 {
+				require.Equal(t, updated, e.Data)
+			} else {
+				require.Equal(t, entryData(n), e.Data)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("entry %d not received", n)
+		}
+	}
+}
+
+func TestEntryCache(t *testing.T) {
+	server, address := newTestServer(t, datastreamer.Config{
+		CacheEntries: 10,
+	})
+
+	entryData := func(n uint64) []byte {
+		data := make([]byte, 8)
+		binary.BigEndian.PutUint64(data, n)
+		return data
+	}
+	addEntries := func(from uint64, count uint64) {
+		tx, err := server.Begin()
+		require.NoError(t, err)
+		for n := from; n < from+count; n++ {
+			_, err = tx.AddEntry(entryType1, entryData(n))
+			require.NoError(t, err)
+		}
+		err = tx.Commit()
+		require.NoError(t, err)
+	}
+	addEntries(0, 30)
+
+	// Case: Get recent entry -> OK (hit)
+	entry, err := server.GetEntry(25)
+	require.NoError(t, err)
+	require.Equal(t, entryData(25), entry.Data)
+	require.Equal(t, datastreamer.CacheStats{Size: 10, Entries: 10, Hits: 1, Misses: 0}, server.GetCacheStats())
+
+	// Case: Get old entry -> OK (miss)
+	entry, err = server.GetEntry(5)
+	require.NoError(t, err)
+	require.Equal(t, entryData(5), entry.Data)
+	require.Equal(t, datastreamer.CacheStats{Size: 10, Entries: 10, Hits: 1, Misses: 1}, server.GetCacheStats())
+
+	// Case: Get updated entry -> OK (hit)
+	err = server.UpdateEntryData(28, entryType2, entryData(1028))
+	require.Equal(t, datastreamer.ErrUpdateEntryTypeNotAllowed, err)
+	err = server.UpdateEntryData(28, entryType1, entryData(1028))
+	require.NoError(t, err)
+	entry, err = server.GetEntry(28)
+	require.NoError(t, err)
+	require.Equal(t, entryData(1028), entry.Data)
+
+	// Case: Get entry after reusing the buffer of its commit -> OK (hit)
+	buffer := entryData(1029)
+	tx, err := server.Begin()
+	require.NoError(t, err)
+	_, err = tx.AddEntry(entryType1, buffer)
+	require.NoError(t, err)
+	err = tx.Commit()
+	require.NoError(t, err)
+	binary.BigEndian.PutUint64(buffer, 0)
+	entry, err = server.GetEntry(30)
+	require.NoError(t, err)
+	require.Equal(t, entryData(1029), entry.Data)
+
+	// Case: Get truncated entry -> FAIL
+	err = server.TruncateFile(27)
+	require.NoError(t, err)
+	_, err = server.GetEntry(27)
+	require.Equal(t, datastreamer.ErrInvalidEntryNumber, err)
+	require.Equal(t, datastreamer.CacheStats{Size: 10, Entries: 6, Hits: 3, Misses: 2}, server.GetCacheStats())
+
+	// Case: Stream from recent entry -> OK (hit)
+	addEntries(27, 5)
+	received := make(chan datastreamer.FileEntry, 100)
+	client, err := datastreamer.NewClient(address, streamType)
+	require.NoError(t, err)
+	client.SetProcessEntryFunc(func(e *datastreamer.FileEntry, c *datastreamer.StreamClient, s *datastreamer.StreamServer) error {
+		received <- *e
+		return nil
+	})
+	err = client.Start()
+	require.NoError(t, err)
+	client.FromEntry = 24
+	err = client.ExecCommand(datastreamer.CmdStart)
+	require.NoError(t, err)
+	addEntries(32, 3)
+
+	for n := uint64(24); n < 35; n++ {
+		select {
+		case e := <-received:
+			require.Equal(t, n, e.Number)
+			require.Equal(t, entryData(n), e.Data)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("entry %d not received", n)
+		}
+	}
+	require.GreaterOrEqual(t, server.GetCacheStats().Hits, uint64(3))
+}
+
+func TestPageCompression(t *testing.T) {
+	fileName := testFileName(t)
+
+	server, address := newTestServer(t, datastreamer.Config{
+		Filename:      fileName,
+		CompressPages: true,
+	})
+
+	// Compressible entries filling several data pages
+	entryData := func(n uint64) []byte {
+		data := []byte(strings.Repeat(fmt.Sprintf("compressible entry %d ", n), 1+int(n%500)))
+		binary.BigEndian.PutUint64(data, n)
+		return data
+	}
+	const entries = 500
+	for n := uint64(0); n < entries; n++ {
+		tx, err := server.Begin()
+		require.NoError(t, err)
+		_, err = tx.AddEntry(entryType1, entryData(n))
+		require.NoError(t, err)
+		err = tx.Commit()
+		require.NoError(t, err)
+	}
+
+	// Case: Sealed data pages stored compressed (in background) -> OK
+	waitCompressedPages(t, fileName, server.GetHeader().TotalLength)
+
+	// Case: Get entries from compressed and uncompressed data pages -> OK
+	for n := uint64(0); n < entries; n = n + 7 {
+		entry, err := server.GetEntry(n)
+		require.NoError(t, err)
+		require.Equal(t, n, entry.Number)
+		require.Equal(t, entryData(n), entry.Data)
+	}
+
+	// Case: Update entry in a compressed data page -> OK
+	err := server.UpdateEntryDataMode(3, entryType2, testEntries[3].Encode(), datastreamer.UmAllowResize|datastreamer.UmAllowTypeChange)
+	require.NoError(t, err)
+	entry, err := server.GetEntry(3)
+	require.NoError(t, err)
+	require.Equal(t, entryType2, entry.Type)
+	require.Equal(t, testEntries[3].Encode(), entry.Data)
+	entry, err = server.GetEntry(4)
+	require.NoError(t, err)
+	require.Equal(t, entryData(4), entry.Data)
+
+	// Case: Truncate into a compressed data page and add entries again -> OK
+	err = server.TruncateFile(10)
+	require.NoError(t, err)
+	for n := uint64(10); n < entries; n++ {
+		tx, err := server.Begin()
+		require.NoError(t, err)
+		_, err = tx.AddEntry(entryType1, entryData(n+1000))
+		require.NoError(t, err)
+		err = tx.Commit()
+		require.NoError(t, err)
+	}
+
+	// Case: Stream from a compressed data page -> OK
+	received := make(chan datastreamer.FileEntry, entries)
+	client, err := datastreamer.NewClient(address, streamType)
+	require.NoError(t, err)
+	client.SetProcessEntryFunc(func(e *datastreamer.FileEntry, c *datastreamer.StreamClient, s *datastreamer.StreamServer) error {
+		received <- *e
+		return nil
+	})
+	err = client.Start()
+	require.NoError(t, err)
+	client.FromEntry = 0
+	err = client.ExecCommand(datastreamer.CmdStart)
+	require.NoError(t, err)
+
+	for n := uint64(0); n < entries; n++ {
+		select {
+		case e := <-received:
+			require.Equal(t, n, e.Number)
+			switch {
+			case n == 3:
+				require.Equal(t, testEntries[3].Encode(), e.Data)
+			case n < 10:
+				require.Equal(t, entryData(n), e.Data)
+			default:
+				require.Equal(t, entryData(n+1000), e.Data)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("entry %d not received", n)
+		}
+	}
+
+	// Case: Data page compressions and decompressions journaled and finished -> OK
+	info, err := os.Stat(strings.TrimSuffix(fileName, ".bin") + ".jnl")
+	require.NoError(t, err)
+	require.Equal(t, int64(0), info.Size())
+}
+
+func TestWireCompression(t *testing.T) {
+	server, address := newTestServer(t, datastreamer.Config{})
+
+	entryData := func(n uint64) []byte {
+		data := []byte(strings.Repeat("compressible entry data ", 20))
+		binary.BigEndian.PutUint64(data, n)
+		return data
+	}
+	addEntries := func(from uint64, count uint64) {
+		tx, err := server.Begin()
+		require.NoError(t, err)
+		for n := from; n < from+count; n++ {
+			_, err = tx.AddEntry(entryType1, entryData(n))
+			require.NoError(t, err)
+		}
+		err = tx.Commit()
+		require.NoError(t, err)
+	}
+	addEntries(0, 100)
+
+	// Case: Invalid compression mode -> FAIL
+	_, err := datastreamer.NewClientWithConfig(datastreamer.ClientConfig{
+		Server:      address,
+		StreamType:  streamType,
+		Compression: 7,
+	})
+	require.Equal(t, datastreamer.ErrInvalidCompressionMode, err)
+
+	// Case: Sync and stream compressed -> OK
+	client, err := datastreamer.NewClientWithConfig(datastreamer.ClientConfig{
+		Server:      address,
+		StreamType:  streamType,
+		Compression: datastreamer.CompressionSnappy,
+	})
+	require.NoError(t, err)
+	received := make(chan datastreamer.FileEntry, 200)
+	client.SetProcessEntryFunc(func(e *datastreamer.FileEntry, c *datastreamer.StreamClient, s *datastreamer.StreamServer) error {
+		received <- *e
+		return nil
+	})
+	err = client.Start()
+	require.NoError(t, err)
+	client.FromEntry = 10
+	err = client.ExecCommand(datastreamer.CmdStart)
+	require.NoError(t, err)
+	addEntries(100, 50)
+
+	for n := uint64(10); n < 150; n++ {
+		select {
+		case e := <-received:
+			require.Equal(t, n, e.Number)
+			require.Equal(t, entryData(n), e.Data)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("entry %d not received", n)
+		}
+	}
+
+	require.Greater(t, client.GetCompressionStats().Ratio(), 1.0)
+	require.Greater(t, server.GetCompressionStats().Ratio(), 1.0)
+	require.Equal(t, server.GetCompressionStats(), client.GetCompressionStats())
+
+	// Server accepting the connection but never answering the commands
+	ln, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+
+	// Case: Compression request on start not answered -> FAIL, client closed and disconnected
+	client, err = datastreamer.NewClientWithConfig(datastreamer.ClientConfig{
+		Server:         ln.Addr().String(),
+		StreamType:     streamType,
+		Compression:    datastreamer.CompressionSnappy,
+		CommandTimeout: 200 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	err = client.Start()
+	require.ErrorIs(t, err, datastreamer.ErrCommandTimeout)
+	err = client.ExecCommand(datastreamer.CmdHeader)
+	require.Equal(t, datastreamer.ErrClientClosed, err)
+
+	conn := <-accepted
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = io.Copy(io.Discard, conn)
+	require.NoError(t, err)
+}
+
+// blackholeProxy forwards TCP connections to a server and can silently drop the traffic of the current ones
+type blackholeProxy struct {
+	t      *testing.T
+	server string
+	ln     net.Listener
+	mutex  sync.Mutex
+	paused []*atomic.Bool
+	conns  []net.Conn
+}
+
+func newBlackholeProxy(t *testing.T, server string) *blackholeProxy {
+	p := &blackholeProxy{t: t, server: server}
+	p.listen("localhost:0")
+	return p
+}
+
+// listen accepts connections on the address and forwards them to the server
+func (p *blackholeProxy) listen(address string) {
+	ln, err := net.Listen("tcp", address)
+	require.NoError(p.t, err)
+	p.ln = ln
+	p.t.Cleanup(func() { ln.Close() })
+
+	forward := func(dst net.Conn, src net.Conn, paused *atomic.Bool) {
+		buffer := make([]byte, 4096)
+		for {
+			n, err := src.Read(buffer)
+			if err != nil {
+				dst.Close()
+				return
+			}
+			if !paused.Load() {
+				_, _ = dst.Write(buffer[:n])
+			}
+		}
+	}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			serverConn, err := net.Dial("tcp", p.server)
+			if err != nil {
+				conn.Close()
+				continue
+			}
+			paused := &atomic.Bool{}
+			p.mutex.Lock()
+			p.paused = append(p.paused, paused)
+			p.conns = append(p.conns, conn, serverConn)
+			p.mutex.Unlock()
+			go forward(serverConn, conn, paused)
+			go forward(conn, serverConn, paused)
+		}
+	}()
+}
+
+// address returns the address of the proxy
+func (p *blackholeProxy) address() string {
+	return p.ln.Addr().String()
+}
+
+// blackhole drops the traffic of the current connections without closing them
+func (p *blackholeProxy) blackhole() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for _, paused := range p.paused {
+		paused.Store(true)
+	}
+}
+
+// drop closes the current connections, still accepting new ones
+func (p *blackholeProxy) drop() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for _, conn := range p.conns {
+		conn.Close()
+	}
+	p.conns = nil
+	p.paused = nil
+}
+
+// close stops accepting connections and closes the current ones
+func (p *blackholeProxy) close() {
+	p.ln.Close()
+	p.drop()
+}
+
+// reopen accepts connections again on the same address after close
+func (p *blackholeProxy) reopen() {
+	p.listen(p.address())
+}
+
+func TestHeartbeat(t *testing.T) {
+	server, address := newTestServer(t, datastreamer.Config{
+		HeartbeatInterval: 100 * time.Millisecond,
+		HeartbeatTimeout:  time.Second,
+	})
+
+	addEntries := func(from uint64, count uint64) {
+		tx, err := server.Begin()
+		require.NoError(t, err)
+		for n := from; n < from+count; n++ {
+			_, err = tx.AddEntry(entryType1, testEntries[n%uint64(len(testEntries))].Encode())
+			require.NoError(t, err)
+		}
+		err = tx.Commit()
+		require.NoError(t, err)
+	}
+	addEntries(0, 10)
+
+	// Case: Idle connection receives pings and is killed after the heartbeat timeout -> OK
+	conn, err := net.Dial("tcp", address)
+	require.NoError(t, err)
+	err = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	require.NoError(t, err)
+	received, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	require.NotEmpty(t, received)
+	for _, b := range received {
+		require.Equal(t, byte(datastreamer.PtPing), b)
+	}
+	conn.Close()
+
+	// Case: Client reconnects when the heartbeat is lost and resumes the streaming -> OK
+	proxy := newBlackholeProxy(t, address)
+	client, err := datastreamer.NewClientWithConfig(datastreamer.ClientConfig{
+		Server:            proxy.address(),
+		StreamType:        streamType,
+		HeartbeatInterval: 100 * time.Millisecond,
+		HeartbeatTimeout:  300 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	entries := make(chan datastreamer.FileEntry, 20)
+	client.SetProcessEntryFunc(func(e *datastreamer.FileEntry, c *datastreamer.StreamClient, s *datastreamer.StreamServer) error {
+		entries <- *e
+		return nil
+	})
+	err = client.Start()
+	require.NoError(t, err)
+	defer client.Close()
+	client.FromEntry = 0
+	err = client.ExecCommand(datastreamer.CmdStart)
+	require.NoError(t, err)
+
+	receive := func(from uint64, to uint64) {
+		for n := from; n < to; n++ {
+			select {
+			case e := <-entries:
+				require.Equal(t, n, e.Number)
+			case <-time.After(5 * time.Second):
+				t.Fatalf("entry %d not received", n)
+			}
+		}
+	}
+	receive(0, 10)
+
+	// Idle streaming is kept alive by the heartbeat
+	time.Sleep(time.Second)
+	addEntries(10, 5)
+	receive(10, 15)
+
+	// Half-open connection (detected by the client before the server)
+	proxy.blackhole()
+	addEntries(15, 5)
+	receive(15, 20)
+}
+
+func TestCommandTimeouts(t *testing.T) {
+	_, address := newTestServer(t, datastreamer.Config{
+		CommandTimeout: 300 * time.Millisecond,
+		WriteTimeout:   time.Second,
+	})
+
+	// Case: Client sends half a command -> Server closes the connection
+	conn, err := net.Dial("tcp", address)
+	require.NoError(t, err)
+	command := binary.BigEndian.AppendUint64(nil, uint64(datastreamer.CmdHeader))
+	_, err = conn.Write(command)
+	require.NoError(t, err)
+	err = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	require.NoError(t, err)
+	received, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	require.Empty(t, received)
+	conn.Close()
+
+	// Case: Client sends an invalid command -> Server answers and closes the connection without delay
+	conn, err = net.Dial("tcp", address)
+	require.NoError(t, err)
+	command = binary.BigEndian.AppendUint64(nil, 999)
+	command = binary.BigEndian.AppendUint64(command, uint64(streamType))
+	_, err = conn.Write(command)
+	require.NoError(t, err)
+	err = conn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+	require.NoError(t, err)
+	received, err = io.ReadAll(conn)
+	require.NoError(t, err)
+	require.Equal(t, byte(datastreamer.PtResult), received[0])
+	conn.Close()
+
+	// Case: Complete commands within the timeout -> OK
+	client, err := datastreamer.NewClientWithConfig(datastreamer.ClientConfig{
+		Server:         address,
+		StreamType:     streamType,
+		CommandTimeout: time.Second,
+	})
+	require.NoError(t, err)
+	err = client.Start()
+	require.NoError(t, err)
+	err = client.ExecCommand(datastreamer.CmdHeader)
+	require.NoError(t, err)
+
+	// Case: Server never answers the command -> FAIL
+	ln, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() { _, _ = io.Copy(io.Discard, conn) }()
+		}
+	}()
+
+	client, err = datastreamer.NewClientWithConfig(datastreamer.ClientConfig{
+		Server:         ln.Addr().String(),
+		StreamType:     streamType,
+		CommandTimeout: 300 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	err = client.Start()
+	require.NoError(t, err)
+	defer client.Close()
+	start := time.Now()
+	err = client.ExecCommand(datastreamer.CmdHeader)
+	require.Equal(t, datastreamer.ErrCommandTimeout, err)
+	require.Less(t, time.Since(start), 2*time.Second)
+}
+
+func TestConnectionLostRequests(t *testing.T) {
+	// Server dying once it receives a command, before answering it
+	ln, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				command := make([]byte, 16)
+				_, _ = io.ReadFull(conn, command)
+				conn.Close()
+			}()
+		}
+	}()
+
+	// No command timeout, the requests wait for the response or the connection loss
+	client, err := datastreamer.NewClientWithConfig(datastreamer.ClientConfig{
+		Server:     ln.Addr().String(),
+		StreamType: streamType,
+		Reconnect:  datastreamer.ReconnectPolicy{InitialDelay: 50 * time.Millisecond},
+	})
+	require.NoError(t, err)
+	err = client.Start()
+	require.NoError(t, err)
+	defer client.Close()
+
+	// Case: Connection lost while waiting for the responses -> FAIL, next requests not blocked
+	for i := 0; i < 3; i++ {
+		done := make(chan error, 1)
+		go func() {
+			_, err := client.GetHeader(context.Background())
+			done <- err
+		}()
+		select {
+		case err = <-done:
+			require.Equal(t, datastreamer.ErrConnectionLost, err)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("request %d not failed after the connection loss", i)
+		}
+	}
+}
+
+func TestClientLifecycle(t *testing.T) {
+	server, address := newTestServer(t, datastreamer.Config{})
+
+	tx, err := server.Begin()
+	require.NoError(t, err)
+	for n := 0; n < 10; n++ {
+		_, err = tx.AddEntry(entryType1, testEntries[n%len(testEntries)].Encode())
+		require.NoError(t, err)
+	}
+	err = tx.Commit()
+	require.NoError(t, err)
+
+	// Case: Entry processing error closes the client and is returned by Run -> OK
+	errProcessing := errors.New("processing error")
+	client, err := datastreamer.NewClient(address, streamType)
+	require.NoError(t, err)
+	client.SetProcessEntryFunc(func(e *datastreamer.FileEntry, c *datastreamer.StreamClient, s *datastreamer.StreamServer) error {
+		if e.Number == 5 {
+			return errProcessing
+		}
+		return nil
+	})
+	err = client.Start()
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err = client.ExecCommandCtx(ctx, datastreamer.CmdStart)
+	require.NoError(t, err)
+	err = client.Run(ctx)
+	require.Equal(t, errProcessing, err)
+	require.Equal(t, errProcessing, <-client.Errors())
+
+	// Case: Command on closed client -> FAIL
+	err = client.ExecCommand(datastreamer.CmdHeader)
+	require.Equal(t, datastreamer.ErrClientClosed, err)
+	err = client.Close()
+	require.NoError(t, err)
+
+	// Case: Run until the context is done -> OK
+	client, err = datastreamer.NewClient(address, streamType)
+	require.NoError(t, err)
+	ctx, cancel = context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	err = client.Run(ctx)
+	require.Equal(t, context.DeadlineExceeded, err)
+	err = client.ExecCommand(datastreamer.CmdHeader)
+	require.Equal(t, datastreamer.ErrClientClosed, err)
+
+	// Case: Command context canceled -> FAIL
+	client, err = datastreamer.NewClient(address, streamType)
+	require.NoError(t, err)
+	err = client.Start()
+	require.NoError(t, err)
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	err = client.ExecCommandCtx(ctx, datastreamer.CmdHeader)
+	require.Equal(t, context.Canceled, err)
+	err = client.Close()
+	require.NoError(t, err)
+}
+
+func TestClientSubscription(t *testing.T) {
+	server, address := newTestServer(t, datastreamer.Config{})
+
+	addEntries := func(from uint64, count uint64) {
+		tx, err := server.Begin()
+		require.NoError(t, err)
+		for n := from; n < from+count; n++ {
+			_, err = tx.AddEntry(entryType1, testEntries[n%uint64(len(testEntries))].Encode())
+			require.NoError(t, err)
+		}
+		err = tx.Commit()
+		require.NoError(t, err)
+	}
+	addEntries(0, 50)
+
+	// Case: Pull without subscription -> FAIL
+	client, err := datastreamer.NewClient(address, streamType)
+	require.NoError(t, err)
+	_, err = client.Next(context.Background())
+	require.Equal(t, datastreamer.ErrNotSubscribed, err)
+
+	// Case: Pull and receive from the subscription with a small buffer -> OK
+	entries := client.Subscribe(1)
+	err = client.Start()
+	require.NoError(t, err)
+	client.FromEntry = 0
+	err = client.ExecCommand(datastreamer.CmdStart)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for n := uint64(0); n < 10; n++ {
+		e, err := client.Next(ctx)
+		require.NoError(t, err)
+		require.Equal(t, n, e.Number)
+	}
+
+	// Slow consumer, the streaming waits for it
+	time.Sleep(500 * time.Millisecond)
+	addEntries(50, 50)
+	for n := uint64(10); n < 100; n++ {
+		select {
+		case e := <-entries:
+			require.Equal(t, n, e.Number)
+		case <-ctx.Done():
+			t.Fatalf("entry %d not received", n)
+		}
+	}
+
+	// Case: Pull with no entries until the context is done -> FAIL
+	ctxShort, cancelShort := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancelShort()
+	_, err = client.Next(ctxShort)
+	require.Equal(t, context.DeadlineExceeded, err)
+
+	// Case: Subscription closed with the client -> OK
+	err = client.Close()
+	require.NoError(t, err)
+	_, err = client.Next(ctx)
+	require.Equal(t, datastreamer.ErrClientClosed, err)
+	_, ok := <-entries
+	require.False(t, ok)
+}
+
+func TestClientConcurrentRequests(t *testing.T) {
+	server, address := newTestServer(t, datastreamer.Config{})
+
+	tx, err := server.Begin()
+	require.NoError(t, err)
+	_, err = tx.AddBookmark(testBookmark.Encode())
+	require.NoError(t, err)
+	for n := 0; n < 20; n++ {
+		_, err = tx.AddEntry(entryType1, testEntries[n%len(testEntries)].Encode())
+		require.NoError(t, err)
+	}
+	err = tx.Commit()
+	require.NoError(t, err)
+
+	client, err := datastreamer.NewClient(address, streamType)
+	require.NoError(t, err)
+	entries := client.Subscribe(32)
+	err = client.Start()
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Case: Concurrent entry queries get their own responses -> OK
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				entryNum := uint64(1 + (g+i)%20)
+				entry, err := client.GetEntry(ctx, entryNum)
+				if !assert.NoError(t, err) {
+					return
+				}
+				assert.Equal(t, entryNum, entry.Number)
+				assert.Equal(t, testEntries[(entryNum-1)%uint64(len(testEntries))], TestEntry{}.Decode(entry.Data))
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	// Case: Query entry that doesn't exist -> FAIL
+	_, err = client.GetEntry(ctx, 5000)
+	require.Equal(t, datastreamer.ErrEntryNotFound, err)
+
+	// Case: Query bookmark -> OK
+	entry, err := client.GetBookmark(ctx, testBookmark.Encode())
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), entry.Number)
+	_, err = client.GetBookmark(ctx, nonAddedBookmark.Encode())
+	require.Equal(t, datastreamer.ErrBookmarkNotFound, err)
+
+	// Case: Query header -> OK
+	header, err := client.GetHeader(ctx)
+	require.NoError(t, err)
+	require.Equal(t, uint64(21), header.TotalEntries)
+
+	// Case: Stream from bookmark and stop -> OK
+	err = client.StreamFromBookmark(ctx, testBookmark.Encode())
+	require.NoError(t, err)
+	for n := uint64(0); n < 21; n++ {
+		e, err := client.Next(ctx)
+		require.NoError(t, err)
+		require.Equal(t, n, e.Number)
+	}
+	err = client.StopStreaming(ctx)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	// Case: Stream from entry -> OK
+	err = client.StreamFrom(ctx, 15)
+	require.NoError(t, err)
+	e, err := client.Next(ctx)
+	require.NoError(t, err)
+	require.Equal(t, uint64(15), e.Number)
+}
+
+func TestQueryWhileStreaming(t *testing.T) {
+	server, address := newTestServer(t, datastreamer.Config{})
+
+	addEntries := func(from uint64, count uint64) {
+		tx, err := server.Begin()
+		require.NoError(t, err)
+		for n := from; n < from+count; n++ {
+			_, err = tx.AddEntry(entryType1, binary.BigEndian.AppendUint64(nil, n))
+			require.NoError(t, err)
+		}
+		err = tx.Commit()
+		require.NoError(t, err)
+	}
+	addEntries(0, 10)
+
+	client, err := datastreamer.NewClient(address, streamType)
+	require.NoError(t, err)
+	client.Subscribe(1000)
+	err = client.Start()
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err = client.StreamFrom(ctx, 0)
+	require.NoError(t, err)
+
+	// Case: Queries interleaved with the streamed entries -> OK
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for n := uint64(10); n < 500; n = n + 10 {
+			addEntries(n, 10)
+		}
+	}()
+	for i := uint64(0); i < 100; i++ {
+		entry, err := client.GetEntry(ctx, i%10)
+		require.NoError(t, err)
+		require.Equal(t, i%10, entry.Number)
+		require.Equal(t, binary.BigEndian.AppendUint64(nil, i%10), entry.Data)
+
+		header, err := client.GetHeader(ctx)
+		require.NoError(t, err)
+		require.GreaterOrEqual(t, header.TotalEntries, uint64(10))
+	}
+	<-done
+
+	for n := uint64(0); n < 500; n++ {
+		e, err := client.Next(ctx)
+		require.NoError(t, err)
+		require.Equal(t, n, e.Number)
+		require.Equal(t, binary.BigEndian.AppendUint64(nil, n), e.Data)
+	}
+}
+
+func TestReconnectPolicy(t *testing.T) {
+	reconnect := datastreamer.ReconnectPolicy{
+		InitialDelay: 50 * time.Millisecond,
+		MaxDelay:     200 * time.Millisecond,
+		Multiplier:   2,
+		Jitter:       0.2,
+		MaxAttempts:  3,
+	}
+
+	// Case: No server, attempts exhausted -> FAIL
+	address := fmt.Sprintf("localhost:%d", testPort(t))
+	client, err := datastreamer.NewClientWithConfig(datastreamer.ClientConfig{
+		Server:     address,
+		StreamType: streamType,
+		Reconnect:  reconnect,
+	})
+	require.NoError(t, err)
+	err = client.Start()
+	require.Equal(t, datastreamer.ErrReconnectAttemptsExhausted, err)
+	require.Equal(t, datastreamer.ErrReconnectAttemptsExhausted, <-client.Errors())
+	err = client.ExecCommand(datastreamer.CmdHeader)
+	require.Equal(t, datastreamer.ErrExecCommandNotAllowed, err)
+
+	// Server closing every connection
+	ln, err := net.Listen("tcp", address)
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	// Case: Connection callbacks, attempts exhausted when the server is gone -> FAIL
+	client, err = datastreamer.NewClientWithConfig(datastreamer.ClientConfig{
+		Server:     address,
+		StreamType: streamType,
+		Reconnect:  reconnect,
+	})
+	require.NoError(t, err)
+	var connects, disconnects atomic.Int32
+	client.SetConnectFunc(func(c *datastreamer.StreamClient) {
+		connects.Add(1)
+	})
+	client.SetDisconnectFunc(func(c *datastreamer.StreamClient, err error) {
+		if disconnects.Add(1) == 3 {
+			ln.Close()
+		}
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err = client.Run(ctx)
+	require.Equal(t, datastreamer.ErrReconnectAttemptsExhausted, err)
+	require.GreaterOrEqual(t, connects.Load(), int32(3))
+	require.GreaterOrEqual(t, disconnects.Load(), int32(3))
+}
+
+func TestClientFailover(t *testing.T) {
+	// Servers with the same stream: master, relay behind and relay synced
+	newServer := func(entries int) string {
+		server, address := newTestServer(t, datastreamer.Config{})
+
+		tx, err := server.Begin()
+		require.NoError(t, err)
+		for i := 0; i < entries; i++ {
+			_, err = tx.AddEntry(entryType1, testEntries[i%len(testEntries)].Encode())
+			require.NoError(t, err)
+		}
+		err = tx.Commit()
+		require.NoError(t, err)
+		return address
+	}
+	master := newBlackholeProxy(t, newServer(20))
+	behind := newServer(5)
+	synced := newServer(30)
+
+	client, err := datastreamer.NewClientWithConfig(datastreamer.ClientConfig{
+		StreamType: streamType,
+		Endpoints: []datastreamer.Endpoint{
+			{Server: synced, Priority: 2},
+			{Server: master.address(), Priority: 0},
+			{Server: behind, Priority: 1},
+		},
+		Reconnect: datastreamer.ReconnectPolicy{InitialDelay: 50 * time.Millisecond, MaxAttempts: 3},
+	})
+	require.NoError(t, err)
+	client.Subscribe(100)
+	err = client.Start()
+	require.NoError(t, err)
+	defer client.Close()
+
+	// Case: Connected to the endpoint with higher priority -> OK
+	require.Equal(t, master.address(), client.Server())
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err = client.StreamFrom(ctx, 0)
+	require.NoError(t, err)
+	for n := uint64(0); n < 20; n++ {
+		e, err := client.Next(ctx)
+		require.NoError(t, err)
+		require.Equal(t, n, e.Number)
+	}
+
+	// Case: Master lost, failover skipping the relay behind, resumed without gaps or duplicates -> OK
+	master.close()
+	for n := uint64(20); n < 30; n++ {
+		e, err := client.Next(ctx)
+		require.NoError(t, err)
+		require.Equal(t, n, e.Number)
+	}
+	require.Equal(t, synced, client.Server())
+}
+
+func TestClientFailback(t *testing.T) {
+	// Primary and secondary servers with the same stream
+	primaryServer, primaryAddress := newTestServer(t, datastreamer.Config{})
+	secondaryServer, secondary := newTestServer(t, datastreamer.Config{})
+	addEntries := func(count int) {
+		for _, server := range []*datastreamer.StreamServer{primaryServer, secondaryServer} {
+			tx, err := server.Begin()
+			require.NoError(t, err)
+			for i := 0; i < count; i++ {
+				_, err = tx.AddEntry(entryType1, testEntries[i%len(testEntries)].Encode())
+				require.NoError(t, err)
+			}
+			err = tx.Commit()
+			require.NoError(t, err)
+		}
+	}
+	addEntries(10)
+	primary := newBlackholeProxy(t, primaryAddress)
+
+	client, err := datastreamer.NewClientWithConfig(datastreamer.ClientConfig{
+		StreamType: streamType,
+		Endpoints: []datastreamer.Endpoint{
+			{Server: primary.address(), Priority: 0},
+			{Server: secondary, Priority: 1},
+		},
+		Reconnect: datastreamer.ReconnectPolicy{InitialDelay: 50 * time.Millisecond, FailbackInterval: 100 * time.Millisecond},
+	})
+	require.NoError(t, err)
+	client.Subscribe(100)
+	err = client.Start()
+	require.NoError(t, err)
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err = client.StreamFrom(ctx, 0)
+	require.NoError(t, err)
+	next := uint64(0)
+	receive := func(to uint64) {
+		for ; next < to; next++ {
+			e, err := client.Next(ctx)
+			require.NoError(t, err)
+			require.Equal(t, next, e.Number)
+		}
+	}
+	receive(10)
+
+	// Case: Connection to the primary lost but reachable, reconnected to it -> OK
+	primary.drop()
+	addEntries(10)
+	receive(20)
+	require.Equal(t, primary.address(), client.Server())
+
+	// Case: Primary down, failover to the secondary -> OK
+	primary.close()
+	addEntries(10)
+	receive(30)
+	require.Equal(t, secondary, client.Server())
+
+	// Case: Primary up again, fail back to it without gaps or duplicates -> OK
+	primary.reopen()
+	require.Eventually(t, func() bool { return client.Server() == primary.address() }, 5*time.Second, 20*time.Millisecond)
+	addEntries(10)
+	receive(40)
+	require.Equal(t, primary.address(), client.Server())
+}
+
+func TestClientCheckpoint(t *testing.T) {
+	checkpointFile := filepath.Join(t.TempDir(), "checkpoint.cp")
+	checkpointDB := filepath.Join(t.TempDir(), "checkpoint.db")
+
+	server, address := newTestServer(t, datastreamer.Config{})
+
+	addEntries := func(from uint64, count uint64) {
+		tx, err := server.Begin()
+		require.NoError(t, err)
+		for n := from; n < from+count; n++ {
+			_, err = tx.AddEntry(entryType1, testEntries[n%uint64(len(testEntries))].Encode())
+			require.NoError(t, err)
+		}
+		err = tx.Commit()
+		require.NoError(t, err)
+	}
+	addEntries(0, 10)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Case: File checkpoint saved after the callback processing -> OK
+	checkpointer := datastreamer.NewFileCheckpointer(checkpointFile)
+	_, found, err := checkpointer.Load()
+	require.NoError(t, err)
+	require.False(t, found)
+
+	newClient := func(cp datastreamer.Checkpointer, entries chan datastreamer.FileEntry) *datastreamer.StreamClient {
+		client, err := datastreamer.NewClient(address, streamType)
+		require.NoError(t, err)
+		client.SetCheckpointer(cp)
+		client.SetProcessEntryFunc(func(e *datastreamer.FileEntry, c *datastreamer.StreamClient, s *datastreamer.StreamServer) error {
+			entries <- *e
+			return nil
+		})
+		err = client.Start()
+		require.NoError(t, err)
+		return client
+	}
+
+	entries := make(chan datastreamer.FileEntry, 100)
+	client := newClient(checkpointer, entries)
+	require.Equal(t, uint64(0), client.FromEntry)
+	err = client.StreamFromCheckpoint(ctx)
+	require.NoError(t, err)
+	for n := uint64(0); n < 10; n++ {
+		e := <-entries
+		require.Equal(t, n, e.Number)
+	}
+	require.Eventually(t, func() bool {
+		nextEntry, found, err := checkpointer.Load()
+		return err == nil && found && nextEntry == 10
+	}, 5*time.Second, 10*time.Millisecond)
+	err = client.Close()
+	require.NoError(t, err)
+
+	// Case: Restarted client resumes from the file checkpoint -> OK
+	addEntries(10, 10)
+	client = newClient(checkpointer, entries)
+	require.Equal(t, uint64(10), client.FromEntry)
+	err = client.StreamFromCheckpoint(ctx)
+	require.NoError(t, err)
+	e := <-entries
+	require.Equal(t, uint64(10), e.Number)
+	err = client.Close()
+	require.NoError(t, err)
+
+	// Case: LevelDB checkpoint saved for the entries pulled from the subscription -> OK
+	checkpointerDB, err := datastreamer.NewLevelDBCheckpointer(checkpointDB, []byte("client1"))
+	require.NoError(t, err)
+	defer checkpointerDB.Close()
+
+	client, err = datastreamer.NewClient(address, streamType)
+	require.NoError(t, err)
+	client.SetCheckpointer(checkpointerDB)
+	client.Subscribe(100)
+	err = client.Start()
+	require.NoError(t, err)
+	err = client.StreamFromCheckpoint(ctx)
+	require.NoError(t, err)
+	for n := uint64(0); n < 20; n++ {
+		e, err := client.Next(ctx)
+		require.NoError(t, err)
+		require.Equal(t, n, e.Number)
+	}
+
+	// The last entry pulled is checkpointed on the next call
+	nextEntry, found, err := checkpointerDB.Load()
+	require.NoError(t, err)
+	require.True(t, !found || nextEntry < 20)
+	ctxShort, cancelShort := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancelShort()
+	_, err = client.Next(ctxShort)
+	require.Equal(t, context.DeadlineExceeded, err)
+	nextEntry, found, err = checkpointerDB.Load()
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, uint64(20), nextEntry)
+	err = client.Close()
+	require.NoError(t, err)
+
+	// Case: Restarted client resumes from the LevelDB checkpoint -> OK
+	client = newClient(checkpointerDB, entries)
+	require.Equal(t, uint64(20), client.FromEntry)
+	err = client.Close()
+	require.NoError(t, err)
+}
+
+// newSequenceServer starts a fake server streaming the faulty entries sequence in the first session,
+// and the entries in sequence up to total in the next ones. Returns its address and the sessions counter
+func newSequenceServer(t *testing.T, faulty []uint64, total uint64) (string, *atomic.Int32) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	resultOK := []byte{datastreamer.PtResult, 0, 0, 0, datastreamer.FixedSizeResultEntry, 0, 0, 0, 0}
+	entry := func(packetType byte, n uint64) []byte {
+		b := []byte{packetType}
+		b = binary.BigEndian.AppendUint32(b, datastreamer.FixedSizeFileEntry+1)
+		b = binary.BigEndian.AppendUint32(b, uint32(entryType1))
+		b = binary.BigEndian.AppendUint64(b, n)
+		return append(b, byte(n))
+	}
+
+	sessions := &atomic.Int32{}
+	serve := func(conn net.Conn) {
+		defer conn.Close()
+		for {
+			// Command and stream type
+			cmd := make([]byte, 16)
+			_, err := io.ReadFull(conn, cmd)
+			if err != nil {
+				return
+			}
+
+			out := append([]byte{}, resultOK...)
+			switch datastreamer.Command(binary.BigEndian.Uint64(cmd[:8])) {
+			case datastreamer.CmdStart:
+				param := make([]byte, 8)
+				_, err = io.ReadFull(conn, param)
+				if err != nil {
+					return
+				}
+				if sessions.Add(1) == 1 {
+					for _, n := range faulty {
+						out = append(out, entry(datastreamer.PtData, n)...)
+					}
+				} else {
+					for n := binary.BigEndian.Uint64(param); n < total; n++ {
+						out = append(out, entry(datastreamer.PtData, n)...)
+					}
+				}
+			case datastreamer.CmdHeader:
+				out = append(out, datastreamer.PtHeader, 0, 0, 0, 29) // nolint:gomnd
+				out = binary.BigEndian.AppendUint64(out, uint64(streamType))
+				out = binary.BigEndian.AppendUint64(out, 0)
+				out = binary.BigEndian.AppendUint64(out, total)
+			case datastreamer.CmdEntry:
+				param := make([]byte, 8)
+				_, err = io.ReadFull(conn, param)
+				if err != nil {
+					return
+				}
+				out = append(out, entry(datastreamer.PtDataRsp, binary.BigEndian.Uint64(param))...)
+			}
+			_, err = conn.Write(out)
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serve(conn)
+		}
+	}()
+	return ln.Addr().String(), sessions
+}
+
+func TestClientSequence(t *testing.T) {
+	newClient := func(address string, policy datastreamer.GapPolicy) *datastreamer.StreamClient {
+		client, err := datastreamer.NewClientWithConfig(datastreamer.ClientConfig{
+			Server:     address,
+			StreamType: streamType,
+			GapPolicy:  policy,
+			Reconnect:  datastreamer.ReconnectPolicy{InitialDelay: 50 * time.Millisecond},
+		})
+		require.NoError(t, err)
+		client.Subscribe(100)
+		err = client.Start()
+		require.NoError(t, err)
+		t.Cleanup(func() { client.Close() })
+		return client
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	receive := func(client *datastreamer.StreamClient, from uint64, to uint64) {
+		for n := from; n < to; n++ {
+			e, err := client.Next(ctx)
+			require.NoError(t, err)
+			require.Equal(t, n, e.Number)
+		}
+	}
+
+	// Case: Invalid gap policy -> FAIL
+	_, err := datastreamer.NewClientWithConfig(datastreamer.ClientConfig{GapPolicy: 3})
+	require.Equal(t, datastreamer.ErrInvalidGapPolicy, err)
+
+	// Case: Duplicated entries discarded -> OK
+	address, _ := newSequenceServer(t, []uint64{0, 1, 1, 2, 0, 3}, 4)
+	client := newClient(address, datastreamer.GapPolicyError)
+	err = client.StreamFrom(ctx, 0)
+	require.NoError(t, err)
+	receive(client, 0, 4)
+	require.Equal(t, datastreamer.SequenceStats{Duplicates: 2}, client.GetSequenceStats())
+
+	// Case: Gap with error policy closes the client -> FAIL
+	address, _ = newSequenceServer(t, []uint64{0, 1, 2, 5}, 6)
+	client = newClient(address, datastreamer.GapPolicyError)
+	err = client.StreamFrom(ctx, 0)
+	require.NoError(t, err)
+	receive(client, 0, 3)
+	_, err = client.Next(ctx)
+	require.Equal(t, datastreamer.ErrStreamGap, err)
+	require.Equal(t, datastreamer.SequenceStats{Gaps: 1, Missing: 2}, client.GetSequenceStats())
+
+	// Case: Gap with refetch policy gets the missing entries -> OK
+	address, _ = newSequenceServer(t, []uint64{0, 1, 4, 5}, 6)
+	client = newClient(address, datastreamer.GapPolicyRefetch)
+	err = client.StreamFrom(ctx, 0)
+	require.NoError(t, err)
+	receive(client, 0, 6)
+	require.Equal(t, datastreamer.SequenceStats{Gaps: 1, Missing: 2, Refetched: 2}, client.GetSequenceStats())
+
+	// Case: Gap with reconnect policy streams again from the next entry expected -> OK
+	address, sessions := newSequenceServer(t, []uint64{0, 1, 4, 5, 6}, 7)
+	client = newClient(address, datastreamer.GapPolicyReconnect)
+	err = client.StreamFrom(ctx, 0)
+	require.NoError(t, err)
+	receive(client, 0, 7)
+	require.Equal(t, datastreamer.SequenceStats{Gaps: 1, Missing: 2}, client.GetSequenceStats())
+	require.Equal(t, int32(2), sessions.Load())
+}
+
+func TestStreamReader(t *testing.T) {
+	fileName := testFileName(t)
+
+	// Case: Open a stream file that doesn't exist -> FAIL
+	_, err := datastreamer.NewReader(fileName, streamType)
+	require.Error(t, err)
+
+	server, _ := newTestServer(t, datastreamer.Config{
+		Filename:      fileName,
+		CompressPages: true,
+	})
+
+	entryData := func(n uint64) []byte {
+		data := []byte(strings.Repeat(fmt.Sprintf("reader entry %d ", n), 1+int(n%500)))
+		binary.BigEndian.PutUint64(data, n)
+		return data
+	}
+	addEntries := func(from uint64, count uint64) {
+		tx, err := server.Begin()
+		require.NoError(t, err)
+		for n := from; n < from+count; n++ {
+			_, err = tx.AddEntry(entryType1, entryData(n))
+			require.NoError(t, err)
+		}
+		err = tx.Commit()
+		require.NoError(t, err)
+	}
+
+	// Entries filling several data pages (compressed) and a bookmark
+	const entries = 400
+	addEntries(0, 300)
+	err = server.StartAtomicOp()
+	require.NoError(t, err)
+	bookmarkEntry, err := server.AddStreamBookmark(testBookmark.Encode())
+	require.NoError(t, err)
+	err = server.CommitAtomicOp()
+	require.NoError(t, err)
+	addEntries(301, entries-301)
+	waitCompressedPages(t, fileName, server.GetHeader().TotalLength)
+
+	// Case: Stream file with the wrong stream type -> FAIL
+	_, err = datastreamer.NewReader(fileName, streamType+1)
+	require.Error(t, err)
+
+	reader, err := datastreamer.NewReaderWithConfig(datastreamer.ReaderConfig{
+		Filename:     fileName,
+		StreamType:   streamType,
+		PollInterval: 10 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	defer reader.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Case: Get header -> OK
+	header, err := reader.GetHeader(ctx)
+	require.NoError(t, err)
+	require.Equal(t, server.GetHeader(), header)
+
+	// Case: Get entries from compressed and uncompressed data pages -> OK
+	for n := uint64(0); n < entries; n = n + 7 {
+		entry, err := reader.GetEntry(ctx, n)
+		require.NoError(t, err)
+		expected, err := server.GetEntry(n)
+		require.NoError(t, err)
+		require.Equal(t, expected, entry)
+	}
+
+	// Case: Get entry not committed -> FAIL
+	_, err = reader.GetEntry(ctx, entries)
+	require.Equal(t, datastreamer.ErrEntryNotFound, err)
+
+	// Case: Get bookmark with the database locked by the server -> OK
+	entry, err := reader.GetBookmark(ctx, testBookmark.Encode())
+	require.NoError(t, err)
+	require.Equal(t, bookmarkEntry+1, entry.Number)
+
+	// Case: Get bookmark not added -> FAIL
+	_, err = reader.GetBookmark(ctx, nonAddedBookmark.Encode())
+	require.Equal(t, datastreamer.ErrBookmarkNotFound, err)
+
+	// Case: Get entry while the writer rewrites data pages (odd rewrite sequence) -> FAIL, until it finishes
+	const rewriteSeqPos = 16 + 29 + 1
+	file, err := os.OpenFile(fileName, os.O_RDWR, 0666)
+	require.NoError(t, err)
+	seq := make([]byte, 8)
+	_, err = file.ReadAt(seq, rewriteSeqPos)
+	require.NoError(t, err)
+	_, err = file.WriteAt(binary.BigEndian.AppendUint64(nil, binary.BigEndian.Uint64(seq)+1), rewriteSeqPos)
+	require.NoError(t, err)
+	_, err = reader.GetEntry(ctx, 5)
+	require.Equal(t, datastreamer.ErrDataPageRewritten, err)
+	_, err = file.WriteAt(seq, rewriteSeqPos)
+	require.NoError(t, err)
+	file.Close()
+	entry, err = reader.GetEntry(ctx, 5)
+	require.NoError(t, err)
+	require.Equal(t, entryData(5), entry.Data)
+
+	// Case: Stream from bookmark -> OK
+	err = reader.StreamFromBookmark(ctx, testBookmark.Encode())
+	require.NoError(t, err)
+	entry, err = reader.Next(ctx)
+	require.NoError(t, err)
+	require.Equal(t, bookmarkEntry, entry.Number)
+	require.Equal(t, datastreamer.EntryType(datastreamer.EtBookmark), entry.Type)
+
+	// Case: Stream from entry and tail the new committed entries -> OK
+	err = reader.StreamFrom(ctx, 0)
+	require.NoError(t, err)
+	entriesCh := reader.Subscribe(10)
+	go addEntries(entries, 50)
+	for n := uint64(0); n < entries+50; n++ {
+		select {
+		case entry, ok := <-entriesCh:
+			require.True(t, ok)
+			require.Equal(t, n, entry.Number)
+			if n != bookmarkEntry {
+				require.Equal(t, entryData(n), entry.Data)
+			}
+		case <-ctx.Done():
+			require.FailNow(t, "entry not received", "entry %d", n)
+		}
+	}
+
+	// Case: Next waits for new entries until the context is done -> FAIL
+	shortCtx, shortCancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer shortCancel()
+	_, err = reader.Next(shortCtx)
+	require.Equal(t, context.DeadlineExceeded, err)
+
+	// Case: Reader closed -> FAIL
+	err = reader.Close()
+	require.NoError(t, err)
+	_, err = reader.Next(ctx)
+	require.Equal(t, datastreamer.ErrReaderClosed, err)
+	_, err = reader.GetHeader(ctx)
+	require.Equal(t, datastreamer.ErrReaderClosed, err)
+}
+
+func TestIterator(t *testing.T) {
+	server, _ := newTestServer(t, datastreamer.Config{
+		CompressPages: true,
+	})
+
+	entryData := func(n uint64) []byte {
+		data := []byte(strings.Repeat(fmt.Sprintf("iterator entry %d ", n), 1+int(n%500)))
+		binary.BigEndian.PutUint64(data, n)
+		return data
+	}
+	addEntries := func(from uint64, count uint64) {
+		tx, err := server.Begin()
+		require.NoError(t, err)
+		for n := from; n < from+count; n++ {
+			_, err = tx.AddEntry(entryType1, entryData(n))
+			require.NoError(t, err)
+		}
+		err = tx.Commit()
+		require.NoError(t, err)
+	}
+
+	// Entries filling several data pages (compressed) and a bookmark
+	const entries = 400
+	addEntries(0, 200)
+	err := server.StartAtomicOp()
+	require.NoError(t, err)
+	bookmarkEntry, err := server.AddStreamBookmark(testBookmark.Encode())
+	require.NoError(t, err)
+	err = server.CommitAtomicOp()
+	require.NoError(t, err)
+	addEntries(201, entries-201)
+
+	checkEntry := func(e datastreamer.FileEntry, n uint64) {
+		require.Equal(t, n, e.Number)
+		if n == bookmarkEntry {
+			require.Equal(t, datastreamer.EntryType(datastreamer.EtBookmark), e.Type)
+		} else {
+			require.Equal(t, entryData(n), e.Data)
+		}
+	}
+
+	// Case: Next without starting entry -> FAIL
+	it := server.NewIterator(false)
+	defer it.Close()
+	_, err = it.Next()
+	require.Equal(t, datastreamer.ErrInvalidEntryNumber, err)
+
+	// Case: Iterate from entry not committed -> FAIL
+	err = it.From(entries)
+	require.Equal(t, datastreamer.ErrInvalidEntryNumber, err)
+
+	// Case: Iterate forward from entry across data pages -> OK
+	err = it.From(10)
+	require.NoError(t, err)
+	for n := uint64(10); n < entries; n++ {
+		end, err := it.Next()
+		require.NoError(t, err)
+		require.False(t, end)
+		checkEntry(it.Entry(), n)
+	}
+	end, err := it.Next()
+	require.NoError(t, err)
+	require.True(t, end)
+
+	// Case: Iterate forward the entries committed after the end -> OK
+	addEntries(entries, 5)
+	for n := uint64(entries); n < entries+5; n++ {
+		end, err := it.Next()
+		require.NoError(t, err)
+		require.False(t, end)
+		checkEntry(it.Entry(), n)
+	}
+
+	// Case: Iterate forward from bookmark -> OK
+	err = it.FromBookmark(testBookmark.Encode())
+	require.NoError(t, err)
+	end, err = it.Next()
+	require.NoError(t, err)
+	require.False(t, end)
+	checkEntry(it.Entry(), bookmarkEntry)
+
+	// Case: Iterate from bookmark not added -> FAIL
+	err = it.FromBookmark(nonAddedBookmark.Encode())
+	require.Equal(t, datastreamer.ErrBookmarkNotFound, err)
+
+	// Case: Iterate in reverse from the last entry across data pages -> OK
+	rit := server.NewIterator(true)
+	defer rit.Close()
+	err = rit.From(entries + 4)
+	require.NoError(t, err)
+	for n := int64(entries + 4); n >= 0; n-- {
+		end, err := rit.Next()
+		require.NoError(t, err)
+		require.False(t, end)
+		checkEntry(rit.Entry(), uint64(n))
+	}
+	end, err = rit.Next()
+	require.NoError(t, err)
+	require.True(t, end)
+
+	// Case: Iterate in reverse from bookmark -> OK
+	err = rit.FromBookmark(testBookmark.Encode())
+	require.NoError(t, err)
+	for n := int64(bookmarkEntry); n >= int64(bookmarkEntry)-3; n-- {
+		end, err := rit.Next()
+		require.NoError(t, err)
+		require.False(t, end)
+		checkEntry(rit.Entry(), uint64(n))
+	}
+}
+
+func TestLatestEntries(t *testing.T) {
+	server, address := newTestServer(t, datastreamer.Config{})
+
+	// Case: Latest entries of an empty stream -> OK
+	entries, err := server.GetLatestEntries(entryType1, 10)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	// Entries filling several data pages, every tenth of type 2
+	entryData := func(n uint64) []byte {
+		data := make([]byte, 10000)
+		binary.BigEndian.PutUint64(data, n)
+		return data
+	}
+	const total = 300
+	tx, err := server.Begin()
+	require.NoError(t, err)
+	for n := uint64(0); n < total; n++ {
+		entryType := entryType1
+		if n%10 == 0 {
+			entryType = entryType2
+		}
+		_, err = tx.AddEntry(entryType, entryData(n))
+		require.NoError(t, err)
+	}
+	err = tx.Commit()
+	require.NoError(t, err)
+
+	checkEntries := func(entries []datastreamer.FileEntry, from uint64, step uint64, count int) {
+		require.Len(t, entries, count)
+		for i, e := range entries {
+			n := from - uint64(i)*step
+			require.Equal(t, n, e.Number)
+			require.Equal(t, entryData(n), e.Data)
+		}
+	}
+
+	// Case: Latest entries of a type across data pages -> OK
+	entries, err = server.GetLatestEntries(entryType2, 15)
+	require.NoError(t, err)
+	checkEntries(entries, 290, 10, 15)
+
+	// Case: More entries than the ones of the type -> OK
+	entries, err = server.GetLatestEntries(entryType2, 100)
+	require.NoError(t, err)
+	checkEntries(entries, 290, 10, 30)
+
+	// Case: Latest entry of a type -> OK
+	entries, err = server.GetLatestEntries(entryType1, 1)
+	require.NoError(t, err)
+	checkEntries(entries, 299, 1, 1)
+
+	// Case: Entry type not in the stream -> OK
+	entries, err = server.GetLatestEntries(datastreamer.EntryType(7), 10)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	client, err := datastreamer.NewClient(address, streamType)
+	require.NoError(t, err)
+	defer client.Close()
+	err = client.Start()
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Case: Latest entries of a type with the client command -> OK
+	entries, err = client.GetLatestEntries(ctx, entryType2, 15)
+	require.NoError(t, err)
+	checkEntries(entries, 290, 10, 15)
+
+	// Case: Entry type not in the stream with the client command -> OK
+	entries, err = client.GetLatestEntries(ctx, datastreamer.EntryType(7), 10)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	// Case: Latest entries with the client command while streaming -> OK
+	err = client.StreamFrom(ctx, total)
+	require.NoError(t, err)
+	entries, err = client.GetLatestEntries(ctx, entryType1, 2)
+	require.NoError(t, err)
+	checkEntries(entries, 299, 1, 2)
+}
+
+func TestTimestamps(t *testing.T) {
+	server, address := newTestServer(t, datastreamer.Config{})
+
+	// Atomic operations committed at different times, returns the time just before the commit
+	addEntries := func(from uint64, count uint64) time.Time {
+		time.Sleep(20 * time.Millisecond)
+		mark := time.UnixMilli(time.Now().UnixMilli())
+		tx, err := server.Begin()
+		require.NoError(t, err)
+		for n := from; n < from+count; n++ {
+			_, err = tx.AddEntry(entryType1, testEntries[n%uint64(len(testEntries))].Encode())
+			require.NoError(t, err)
+		}
+		err = tx.Commit()
+		require.NoError(t, err)
+		return mark
+	}
+	mark1 := addEntries(0, 3)
+	mark2 := addEntries(3, 3)
+	mark3 := addEntries(6, 4)
+
+	// Case: Commit time of entries -> OK
+	ts1, err := server.GetEntryTimestamp(0)
+	require.NoError(t, err)
+	require.False(t, ts1.Before(mark1))
+	require.True(t, ts1.Before(mark2))
+	ts2, err := server.GetEntryTimestamp(5)
+	require.NoError(t, err)
+	require.False(t, ts2.Before(mark2))
+	require.True(t, ts2.Before(mark3))
+	ts, err := server.GetEntryTimestamp(3)
+	require.NoError(t, err)
+	require.Equal(t, ts2, ts)
+	ts3, err := server.GetEntryTimestamp(9)
+	require.NoError(t, err)
+	require.False(t, ts3.Before(mark3))
+
+	// Case: Commit time of entry not committed -> FAIL
+	_, err = server.GetEntryTimestamp(10)
+	require.Equal(t, datastreamer.ErrInvalidEntryNumber, err)
+
+	// Case: First entry from time -> OK
+	entryNum, err := server.GetEntryFromTime(time.Time{})
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), entryNum)
+	entryNum, err = server.GetEntryFromTime(mark2)
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), entryNum)
+
+	// Case: First entry from a time after the last commit -> FAIL
+	_, err = server.GetEntryFromTime(time.Now().Add(time.Hour))
+	require.Equal(t, datastreamer.ErrEntryNotFound, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	newClient := func(compression datastreamer.CompressionMode, timestamps bool) *datastreamer.StreamClient {
+		client, err := datastreamer.NewClientWithConfig(datastreamer.ClientConfig{
+			Server:      address,
+			StreamType:  streamType,
+			Compression: compression,
+			Timestamps:  timestamps,
+		})
+		require.NoError(t, err)
+		client.Subscribe(100)
+		err = client.Start()
+		require.NoError(t, err)
+		t.Cleanup(func() { client.Close() })
+		return client
+	}
+	receive := func(client *datastreamer.StreamClient, from uint64, to uint64, ts time.Time) {
+		for n := from; n < to; n++ {
+			e, err := client.Next(ctx)
+			require.NoError(t, err)
+			require.Equal(t, n, e.Number)
+			require.Equal(t, uint64(ts.UnixMilli()), e.Timestamp)
+		}
+	}
+
+	// Case: Stream from time with the entries commit time -> OK
+	client := newClient(datastreamer.CompressionNone, true)
+	err = client.StreamFromTime(ctx, mark2)
+	require.NoError(t, err)
+	receive(client, 3, 6, ts2)
+	receive(client, 6, 10, ts3)
+
+	// Case: Stream compressed from entry with the entries commit time -> OK
+	compressedClient := newClient(datastreamer.CompressionSnappy, true)
+	err = compressedClient.StreamFrom(ctx, 1)
+	require.NoError(t, err)
+	receive(compressedClient, 1, 3, ts1)
+	receive(compressedClient, 3, 6, ts2)
+	receive(compressedClient, 6, 10, ts3)
+
+	// Case: Entries committed while streaming with their commit time -> OK
+	mark4 := addEntries(10, 2)
+	ts4, err := server.GetEntryTimestamp(10)
+	require.NoError(t, err)
+	require.False(t, ts4.Before(mark4))
+	receive(client, 10, 12, ts4)
+	receive(compressedClient, 10, 12, ts4)
+
+	// Case: Get first entry from time with the client command -> OK
+	plainClient := newClient(datastreamer.CompressionNone, false)
+	entry, err := plainClient.GetEntryFromTime(ctx, mark3)
+	require.NoError(t, err)
+	require.Equal(t, uint64(6), entry.Number)
+
+	// Case: Get first entry from a time after the last commit with the client command -> FAIL
+	_, err = plainClient.GetEntryFromTime(ctx, time.Now().Add(time.Hour))
+	require.Equal(t, datastreamer.ErrEntryNotFound, err)
+
+	// Case: Stream without the entries commit time requested -> OK
+	err = plainClient.StreamFromTime(ctx, mark4)
+	require.NoError(t, err)
+	receive(plainClient, 10, 12, time.UnixMilli(0))
+
+	// Case: Truncate removes the commit time of the truncated entries -> OK
+	err = server.TruncateFile(6)
+	require.NoError(t, err)
+	_, err = server.GetEntryFromTime(mark3)
+	require.Equal(t, datastreamer.ErrEntryNotFound, err)
+	mark5 := addEntries(6, 1)
+	entryNum, err = server.GetEntryFromTime(mark3)
+	require.NoError(t, err)
+	require.Equal(t, uint64(6), entryNum)
+	ts, err = server.GetEntryTimestamp(6)
+	require.NoError(t, err)
+	require.False(t, ts.Before(mark5))
+}
+
+func TestSidecarFileNames(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "stream.v1")
+	err := os.Mkdir(dir, 0755)
+	require.NoError(t, err)
+
+	// Case: Stream file in a directory with a dot in its name -> sidecar files next to it
+	newTestServer(t, datastreamer.Config{Filename: filepath.Join(dir, "datastream.bin")})
+	for _, name := range []string{"datastream.tsi", "datastream.jnl"} {
+		_, err = os.Stat(filepath.Join(dir, name))
+		require.NoError(t, err)
+	}
+}
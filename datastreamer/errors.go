@@ -51,6 +51,10 @@ var (
 	ErrUpdateEntryTypeNotAllowed = fmt.Errorf("update entry to a different entry type not allowed")
 	// ErrUpdateEntryDifferentSize is returned when the update entry is a different size
 	ErrUpdateEntryDifferentSize = fmt.Errorf("update entry to a different size not allowed")
+	// ErrUpdateEntryNotFitInPage is returned when the updated entry doesn't fit in its data page (entries are not relocated)
+	ErrUpdateEntryNotFitInPage = fmt.Errorf("update entry to a different size not allowed, doesn't fit in its data page")
+	// ErrUpdateResizeNotAllowed is returned when the update changes the last data page with an atomic operation in progress
+	ErrUpdateResizeNotAllowed = fmt.Errorf("update entry to a different size not allowed in the last data page, atomic operation in progress")
 	// ErrAtomicOpNotAllowed is returned when the atomic operation is not allowed
 	ErrAtomicOpNotAllowed = fmt.Errorf("atomicop not allowed, server is not started")
 	// ErrStartAtomicOpNotAllowed is returned when the start atomic operation is not allowed
@@ -61,6 +65,10 @@ var (
 	ErrCommitNotAllowed = fmt.Errorf("commit not allowed, atomicop not in started state")
 	// ErrRollbackNotAllowed is returned when the rollback is not allowed
 	ErrRollbackNotAllowed = fmt.Errorf("rollback not allowed, atomicop not in started state")
+	// ErrAtomicOpOwnedByTx is returned when the atomic operation in progress is owned by a transaction handle
+	ErrAtomicOpOwnedByTx = fmt.Errorf("atomicop owned by a transaction, use the transaction handle")
+	// ErrTxDone is returned when using a transaction already committed or rollbacked
+	ErrTxDone = fmt.Errorf("transaction already finished")
 	// ErrInvalidEntryNumber is returned when the entry number is invalid
 	ErrInvalidEntryNumber = fmt.Errorf("invalid entry number, doesn't exist")
 	// ErrUpdateNotAllowed is returned when the update is not allowed
@@ -91,4 +99,48 @@ var (
 	ErrBookmarkNotFound = fmt.Errorf("bookmark not found")
 	// ErrBookmarkMaxLength is returned when the bookmark length exceeds maximum length
 	ErrBookmarkMaxLength = fmt.Errorf("bookmark max length")
+	// ErrInvalidDurabilityMode is returned when the durability mode in the configuration is unknown
+	ErrInvalidDurabilityMode = fmt.Errorf("invalid durability mode")
+	// ErrMmapNotSupported is returned when the memory mapping of the stream file is not supported in the platform
+	ErrMmapNotSupported = fmt.Errorf("memory mapped file not supported")
+	// ErrInvalidSeekPosition is returned when seeking to a negative position of the stream file
+	ErrInvalidSeekPosition = fmt.Errorf("invalid seek position")
+	// ErrDecompressingDataPage is returned when a compressed data page can't be decompressed
+	ErrDecompressingDataPage = fmt.Errorf("error decompressing data page")
+	// ErrCompressionCommandNotAllowed is returned when compression command is not allowed because streaming is started
+	ErrCompressionCommandNotAllowed = fmt.Errorf("compression command not allowed, streaming started")
+	// ErrInvalidCompressionMode is returned when the compression mode is unknown
+	ErrInvalidCompressionMode = fmt.Errorf("invalid compression mode")
+	// ErrReadingDataBatch is returned when a compressed batch of data entries can't be decoded
+	ErrReadingDataBatch = fmt.Errorf("error reading data batch")
+	// ErrCommandTimeout is returned when a command is not completed within the command timeout
+	ErrCommandTimeout = fmt.Errorf("command timeout")
+	// ErrConnectionLost is returned when the connection to the server is lost before the response of a command
+	ErrConnectionLost = fmt.Errorf("connection lost")
+	// ErrClientClosed is returned when executing a command on a closed client
+	ErrClientClosed = fmt.Errorf("client closed")
+	// ErrNotSubscribed is returned when pulling entries from a client without subscription
+	ErrNotSubscribed = fmt.Errorf("client not subscribed")
+	// ErrReconnectAttemptsExhausted is returned when the client can't connect to the server within the reconnect policy attempts
+	ErrReconnectAttemptsExhausted = fmt.Errorf("reconnect attempts exhausted")
+	// ErrServerBehind is returned when the server stream doesn't have the entries to resume the client streaming
+	ErrServerBehind = fmt.Errorf("server stream behind the client")
+	// ErrInvalidCheckpoint is returned when the checkpoint stored is not valid
+	ErrInvalidCheckpoint = fmt.Errorf("invalid checkpoint")
+	// ErrInvalidGapPolicy is returned when the client gap policy is unknown
+	ErrInvalidGapPolicy = fmt.Errorf("invalid gap policy")
+	// ErrStreamGap is returned when the streamed entries have a gap and the gap policy is error
+	ErrStreamGap = fmt.Errorf("gap in the streamed entries")
+	// ErrReaderClosed is returned when the stream reader is closed
+	ErrReaderClosed = fmt.Errorf("reader closed")
+	// ErrTimestampNotFound is returned when the commit time of an entry is not in the timestamp index
+	ErrTimestampNotFound = fmt.Errorf("timestamp not found")
+	// ErrTimestampsCommandNotAllowed is returned when timestamps command is not allowed because streaming is started
+	ErrTimestampsCommandNotAllowed = fmt.Errorf("timestamps command not allowed, streaming started")
+	// ErrInvalidPageJournal is returned when the data pages rewrite journal is not valid
+	ErrInvalidPageJournal = fmt.Errorf("invalid data pages rewrite journal")
+	// ErrDataPageRewritten is returned when a data page is rewritten by another process while reading it
+	ErrDataPageRewritten = fmt.Errorf("data page rewritten while reading it")
+	// ErrServerInconsistent is returned when writing to a server whose stream file or indexes are in an unknown state after a failed write
+	ErrServerInconsistent = fmt.Errorf("server inconsistent, restart required")
 )
@@ -1,10 +1,29 @@
 package datastreamer
 
 import (
+	"bytes"
 	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/0xPolygonHermez/zkevm-data-streamer/log"
 	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+const (
+	// noPreviousBookmark is the previous entry number stored with a bookmark that didn't exist before
+	noPreviousBookmark = math.MaxUint64
+
+	// entryIndexPrefix is the reserved key prefix of the index of the bookmarks by entry number (prefix, entry
+	// number and bookmark). The prefix alone is the key flagging the index is built
+	entryIndexPrefix = "\xff\xffentry-index\x00"
+
+	// migrateBatchSize is the number of bookmarks indexed per write when building the index
+	migrateBatchSize = 10000
 )
 
 // StreamBookmark type to manage index of bookmarks
@@ -13,6 +32,26 @@ type StreamBookmark struct {
 	db     *leveldb.DB
 }
 
+// bookmarksDbName returns the name of the bookmarks database of a stream file. Older versions named it cutting
+// the file name at its first dot, that name is kept if the database exists with it
+func bookmarksDbName(fileName string) string {
+	name := strings.TrimSuffix(fileName, filepath.Ext(fileName)) + ".db"
+	if ind := strings.IndexRune(fileName, '.'); ind != -1 {
+		legacy := fileName[0:ind] + ".db"
+		if legacy != name && dbExists(legacy) && !dbExists(name) {
+			log.Warnf("Using bookmarks DB %s named by an older version", legacy)
+			return legacy
+		}
+	}
+	return name
+}
+
+// dbExists returns if a database exists
+func dbExists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
 // NewBookmark creates bookmark struct and opens or creates the bookmark database
 func NewBookmark(fn string) (*StreamBookmark, error) {
 	b := StreamBookmark{
@@ -32,14 +71,37 @@ func NewBookmark(fn string) (*StreamBookmark, error) {
 	return &b, nil
 }
 
+// entryIndexKey returns the key of a bookmark in the index by entry number
+func entryIndexKey(entryNum uint64, bookmark []byte) []byte {
+	key := binary.BigEndian.AppendUint64([]byte(entryIndexPrefix), entryNum)
+	return append(key, bookmark...)
+}
+
+// isEntryIndexKey returns if a key belongs to the index by entry number instead of a bookmark
+func isEntryIndexKey(key []byte) bool {
+	return bytes.HasPrefix(key, []byte(entryIndexPrefix))
+}
+
 // AddBookmark inserts or updates a bookmark
 func (b *StreamBookmark) AddBookmark(bookmark []byte, entryNum uint64) error {
 	// Convert entry number to bytes slice
 	var entry []byte
 	entry = binary.BigEndian.AppendUint64(entry, entryNum)
 
+	// Current value of the bookmark, replaced in the index
+	batch := new(leveldb.Batch)
+	value, err := b.db.Get(bookmark, nil)
+	if err == nil {
+		batch.Delete(entryIndexKey(binary.BigEndian.Uint64(value), bookmark))
+	} else if err != leveldb.ErrNotFound {
+		log.Errorf("Error getting bookmark [%v]: %v", bookmark, err)
+		return err
+	}
+	batch.Put(bookmark, entry)
+	batch.Put(entryIndexKey(entryNum, bookmark), nil)
+
 	// Insert or update the bookmark into DB
-	err := b.db.Put(bookmark, entry, nil)
+	err = b.db.Write(batch, nil)
 	if err != nil {
 		log.Errorf("Error inserting or updating bookmark [%v] value [%d]", bookmark, entryNum)
 		return err
@@ -51,6 +113,63 @@ func (b *StreamBookmark) AddBookmark(bookmark []byte, entryNum uint64) error {
 	return nil
 }
 
+// AddBookmarks inserts or updates a set of bookmarks in a single write (flushed to disk if sync).
+// The value before the write of each bookmark is kept to restore it if its entries are not committed
+func (b *StreamBookmark) AddBookmarks(bookmarks []bookmarkAO, sync bool) error {
+	// Nothing to write
+	if len(bookmarks) == 0 {
+		return nil
+	}
+
+	// Get the current value of the bookmarks
+	previous := map[string][]byte{}
+	for _, bm := range bookmarks {
+		if _, ok := previous[string(bm.bookmark)]; ok {
+			continue
+		}
+		value, err := b.db.Get(bm.bookmark, nil)
+		if err == leveldb.ErrNotFound {
+			value = nil
+		} else if err != nil {
+			log.Errorf("Error getting bookmark [%v]: %v", bm.bookmark, err)
+			return err
+		}
+		previous[string(bm.bookmark)] = value
+	}
+
+	// Prepare the batch: entry number followed by the previous entry number (noPreviousBookmark if none),
+	// a bookmark repeated in the set is preceded by its previous occurrence. The index keeps just the current
+	// entry number of each bookmark
+	batch := new(leveldb.Batch)
+	for _, bm := range bookmarks {
+		var entry []byte
+		entry = binary.BigEndian.AppendUint64(entry, bm.entryNum)
+		if prev := previous[string(bm.bookmark)]; prev != nil {
+			entry = append(entry, prev[0:8]...)
+			batch.Delete(entryIndexKey(binary.BigEndian.Uint64(prev), bm.bookmark))
+		} else {
+			entry = binary.BigEndian.AppendUint64(entry, noPreviousBookmark)
+		}
+		batch.Put(bm.bookmark, entry)
+		batch.Put(entryIndexKey(bm.entryNum, bm.bookmark), nil)
+		previous[string(bm.bookmark)] = entry
+	}
+
+	// Insert or update the bookmarks into DB
+	err := b.db.Write(batch, &opt.WriteOptions{Sync: sync})
+	if err != nil {
+		log.Errorf("Error inserting or updating %d bookmarks: %v", len(bookmarks), err)
+		return err
+	}
+
+	// Log
+	for _, bm := range bookmarks {
+		log.Debugf("Bookmark added[%v] value[%d]", bm.bookmark, bm.entryNum)
+	}
+
+	return nil
+}
+
 // GetBookmark gets a bookmark value
 func (b *StreamBookmark) GetBookmark(bookmark []byte) (uint64, error) {
 	// Get the bookmark from DB
@@ -72,6 +191,156 @@ func (b *StreamBookmark) GetBookmark(bookmark []byte) (uint64, error) {
 	return entryNum, nil
 }
 
+// migrateBookmarks builds the index by entry number of the bookmarks written by older versions. It's done once
+// on start, in a single pass over the bookmarks writing them in batches
+func (b *StreamBookmark) migrateBookmarks() error {
+	// Index already built
+	_, err := b.db.Get([]byte(entryIndexPrefix), nil)
+	if err == nil {
+		return nil
+	} else if err != leveldb.ErrNotFound {
+		log.Errorf("Error getting bookmarks index flag: %v", err)
+		return err
+	}
+
+	// Index the bookmarks
+	var count uint64 = 0
+	err = nil
+	batch := new(leveldb.Batch)
+	iter := b.db.NewIterator(nil, nil)
+	for iter.Next() {
+		if isEntryIndexKey(iter.Key()) {
+			continue
+		}
+		batch.Put(entryIndexKey(binary.BigEndian.Uint64(iter.Value()), iter.Key()), nil)
+		count++
+
+		if batch.Len() >= migrateBatchSize {
+			err = b.db.Write(batch, nil)
+			if err != nil {
+				break
+			}
+			batch.Reset()
+		}
+	}
+
+	// Check if error
+	if err == nil {
+		err = iter.Error()
+	}
+	iter.Release()
+	if err != nil {
+		log.Errorf("Error indexing bookmarks: %v", err)
+		return err
+	}
+
+	// Flag the index built in the last synced write
+	batch.Put([]byte(entryIndexPrefix), nil)
+	err = b.db.Write(batch, &opt.WriteOptions{Sync: true})
+	if err != nil {
+		log.Errorf("Error indexing bookmarks: %v", err)
+		return err
+	}
+
+	if count > 0 {
+		log.Infof("Indexed %d bookmarks by entry number", count)
+	}
+	return nil
+}
+
+// restoreBookmarksFrom restores the bookmarks pointing to an entry number equal or greater than entryNum to
+// their previous value, the ones without a previous value are deleted. The bookmarks are located by the index
+// by entry number. The previous value is located in the stream file if it's also from entryNum, or unknown
+// (written by older versions). Returns the number of bookmarks changed
+func (b *StreamBookmark) restoreBookmarksFrom(entryNum uint64, f *StreamFile) (uint64, error) {
+	// Counter
+	var count uint64 = 0
+
+	// Collect the bookmarks to restore or delete in a batch
+	batch := new(leveldb.Batch)
+	lost := map[string]struct{}{}
+	indexRange := util.BytesPrefix([]byte(entryIndexPrefix))
+	indexRange.Start = entryIndexKey(entryNum, nil)
+	iter := b.db.NewIterator(indexRange, nil)
+	for iter.Next() {
+		indexKey := append([]byte{}, iter.Key()...)
+		batch.Delete(indexKey)
+		n := binary.BigEndian.Uint64(indexKey[len(entryIndexPrefix):])
+		key := indexKey[len(entryIndexPrefix)+8:]
+
+		// Skip the index keys not matching the bookmark value
+		value, err := b.db.Get(key, nil)
+		if err == leveldb.ErrNotFound {
+			continue
+		} else if err != nil {
+			iter.Release()
+			log.Errorf("Error getting bookmark [%v]: %v", key, err)
+			return 0, err
+		}
+		if binary.BigEndian.Uint64(value) != n {
+			continue
+		}
+		count++
+
+		if len(value) < 16 { // nolint:gomnd
+			lost[string(key)] = struct{}{}
+		} else if prev := binary.BigEndian.Uint64(value[8:16]); prev == noPreviousBookmark {
+			batch.Delete(key)
+		} else if prev < entryNum {
+			batch.Put(key, append([]byte{}, value[8:16]...))
+			batch.Put(entryIndexKey(prev, key), nil)
+		} else {
+			lost[string(key)] = struct{}{}
+		}
+	}
+
+	// Check if error
+	err := iter.Error()
+	iter.Release()
+	if err != nil {
+		log.Errorf("Iterator error restoring bookmarks from entry [%d]: %v", entryNum, err)
+		return 0, err
+	}
+
+	// Nothing to restore
+	if batch.Len() == 0 {
+		return 0, nil
+	}
+
+	// Locate the bookmarks whose previous value is unknown or also from entryNum
+	if len(lost) > 0 {
+		found, err := f.scanBookmarks(lost, entryNum)
+		if err != nil {
+			return 0, err
+		}
+		for key := range lost {
+			if n, ok := found[key]; ok {
+				batch.Put([]byte(key), binary.BigEndian.AppendUint64(nil, n))
+				batch.Put(entryIndexKey(n, []byte(key)), nil)
+			} else {
+				batch.Delete([]byte(key))
+			}
+		}
+	}
+
+	// Restore the bookmarks in a single synced write
+	err = b.db.Write(batch, &opt.WriteOptions{Sync: true})
+	if err != nil {
+		log.Errorf("Error restoring bookmarks from entry [%d]: %v", entryNum, err)
+		return 0, err
+	}
+
+	// Log
+	log.Debugf("Bookmarks restored from entry [%d]: %d", entryNum, count)
+
+	return count, nil
+}
+
+// Close closes the bookmarks database
+func (b *StreamBookmark) Close() error {
+	return b.db.Close()
+}
+
 // PrintDump prints all bookmarks stored in the database
 func (b *StreamBookmark) PrintDump() error {
 	// Counter
@@ -82,6 +351,9 @@ func (b *StreamBookmark) PrintDump() error {
 
 	// Iterator loop
 	for iter.Next() {
+		if isEntryIndexKey(iter.Key()) {
+			continue
+		}
 		count++
 		bookmark := iter.Key()
 		entry := iter.Value()
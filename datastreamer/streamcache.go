@@ -0,0 +1,138 @@
+package datastreamer
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// CacheStats type for the counters of the recent entries cache
+type CacheStats struct {
+	Size    uint64 // Maximum number of entries in the cache
+	Entries uint64 // Current number of entries in the cache
+	Hits    uint64 // Reads served from the cache
+	Misses  uint64 // Reads served from the stream file
+}
+
+// entryCache type for a ring buffer of the most recent committed entries
+type entryCache struct {
+	entries   []FileEntry
+	nextEntry uint64 // Number of the entry next to the last one in the cache
+	count     uint64 // Number of entries in the cache
+	mutex     sync.RWMutex
+
+	hits   uint64
+	misses uint64
+}
+
+// newEntryCache creates a cache for the given number of recent entries
+func newEntryCache(size uint64) *entryCache {
+	return &entryCache{
+		entries: make([]FileEntry, size),
+	}
+}
+
+// add adds committed entries (sequential and next to the last one in the cache)
+func (c *entryCache) add(entries []FileEntry) {
+	if len(entries) == 0 {
+		return
+	}
+	size := uint64(len(c.entries))
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	// Not the next entries (the cache is restarted)
+	if entries[0].Number != c.nextEntry {
+		c.count = 0
+	}
+
+	for _, entry := range entries {
+		// Own copy of the data, the caller may reuse its buffer
+		entry.Data = append([]byte(nil), entry.Data...)
+		c.entries[entry.Number%size] = entry
+		if c.count < size {
+			c.count++
+		}
+	}
+	c.nextEntry = entries[len(entries)-1].Number + 1
+}
+
+// get returns an entry if it's in the cache
+func (c *entryCache) get(entryNum uint64) (FileEntry, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	if !c.contains(entryNum) {
+		atomic.AddUint64(&c.misses, 1)
+		return FileEntry{}, false
+	}
+	atomic.AddUint64(&c.hits, 1)
+
+	entry := c.entries[entryNum%uint64(len(c.entries))]
+	entry.Data = append([]byte(nil), entry.Data...)
+	return entry, true
+}
+
+// getFrom returns the encoded entries from an entry number to the last one if it's in the cache
+func (c *entryCache) getFrom(entryNum uint64) ([]byte, uint64, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	if !c.contains(entryNum) {
+		atomic.AddUint64(&c.misses, 1)
+		return nil, 0, false
+	}
+	atomic.AddUint64(&c.hits, 1)
+
+	size := uint64(len(c.entries))
+	var data []byte
+	for n := entryNum; n < c.nextEntry; n++ {
+		data = appendFileEntryToBinary(data, c.entries[n%size])
+	}
+	return data, c.nextEntry - entryNum, true
+}
+
+// contains returns if an entry is in the cache
+func (c *entryCache) contains(entryNum uint64) bool {
+	return c.count > 0 && entryNum < c.nextEntry && entryNum >= c.nextEntry-c.count
+}
+
+// update replaces an entry if it's in the cache
+func (c *entryCache) update(entry FileEntry) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.contains(entry.Number) {
+		entry.Data = append([]byte(nil), entry.Data...)
+		c.entries[entry.Number%uint64(len(c.entries))] = entry
+	}
+}
+
+// truncate removes the entries from an entry number onwards
+func (c *entryCache) truncate(entryNum uint64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if entryNum >= c.nextEntry {
+		return
+	}
+	removed := c.nextEntry - entryNum
+	if removed > c.count {
+		removed = c.count
+	}
+	c.count = c.count - removed
+	c.nextEntry = entryNum
+}
+
+// stats returns the counters of the cache
+func (c *entryCache) stats() CacheStats {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return CacheStats{
+		Size:    uint64(len(c.entries)),
+		Entries: c.count,
+		Hits:    atomic.LoadUint64(&c.hits),
+		Misses:  atomic.LoadUint64(&c.misses),
+	}
+}
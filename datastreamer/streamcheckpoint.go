@@ -0,0 +1,146 @@
+package datastreamer
+
+import (
+	"encoding/binary"
+	"os"
+
+	"github.com/0xPolygonHermez/zkevm-data-streamer/log"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+const (
+	checkpointSize     = 8    // Size of a checkpoint value (next entry number)
+	checkpointMaxDelay = 1000 // Maximum entries processed without saving the checkpoint
+)
+
+// Checkpointer interface to persist the streaming position of a client
+type Checkpointer interface {
+	// Load returns the next entry number to process, false if there is no checkpoint
+	Load() (uint64, bool, error)
+	// Save persists the next entry number to process
+	Save(nextEntry uint64) error
+	// Close releases the checkpointer resources
+	Close() error
+}
+
+// FileCheckpointer type to persist the checkpoint in a file
+type FileCheckpointer struct {
+	fileName string
+}
+
+// NewFileCheckpointer creates a checkpointer on the file (created on the first save)
+func NewFileCheckpointer(fn string) *FileCheckpointer {
+	return &FileCheckpointer{fileName: fn}
+}
+
+// Load reads the checkpoint from the file
+func (f *FileCheckpointer) Load() (uint64, bool, error) {
+	data, err := os.ReadFile(f.fileName)
+	if os.IsNotExist(err) {
+		return 0, false, nil
+	} else if err != nil {
+		log.Errorf("Error reading checkpoint file %s: %v", f.fileName, err)
+		return 0, false, err
+	}
+
+	if len(data) != checkpointSize {
+		log.Errorf("Invalid checkpoint file %s size: %d", f.fileName, len(data))
+		return 0, false, ErrInvalidCheckpoint
+	}
+	return binary.BigEndian.Uint64(data), true, nil
+}
+
+// Save writes the checkpoint to a temporary file and renames it, so a crash never leaves a partial checkpoint.
+// The directory is flushed after the rename, so a saved checkpoint survives a crash
+func (f *FileCheckpointer) Save(nextEntry uint64) error {
+	tmpName := f.fileName + ".tmp"
+	file, err := os.OpenFile(tmpName, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, fileMode)
+	if err != nil {
+		log.Errorf("Error creating checkpoint file %s: %v", tmpName, err)
+		return err
+	}
+
+	data := binary.BigEndian.AppendUint64(nil, nextEntry)
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if errClose := file.Close(); err == nil {
+		err = errClose
+	}
+	if err != nil {
+		log.Errorf("Error writing checkpoint file %s: %v", tmpName, err)
+		return err
+	}
+
+	err = os.Rename(tmpName, f.fileName)
+	if err != nil {
+		log.Errorf("Error renaming checkpoint file %s: %v", tmpName, err)
+		return err
+	}
+
+	// Make the rename durable
+	return syncDir(f.fileName)
+}
+
+// Close does nothing, the file is only open while saving
+func (f *FileCheckpointer) Close() error {
+	return nil
+}
+
+// LevelDBCheckpointer type to persist the checkpoint in a LevelDB database
+type LevelDBCheckpointer struct {
+	dbName string
+	key    []byte
+	db     *leveldb.DB
+}
+
+// NewLevelDBCheckpointer opens or creates the database to persist the checkpoint under the key
+func NewLevelDBCheckpointer(fn string, key []byte) (*LevelDBCheckpointer, error) {
+	log.Infof("Opening/creating checkpoint DB: %s", fn)
+	db, err := leveldb.OpenFile(fn, nil)
+	if err != nil {
+		log.Errorf("Error opening or creating checkpoint DB %s: %v", fn, err)
+		return nil, err
+	}
+
+	return &LevelDBCheckpointer{
+		dbName: fn,
+		key:    key,
+		db:     db,
+	}, nil
+}
+
+// Load gets the checkpoint from the database
+func (l *LevelDBCheckpointer) Load() (uint64, bool, error) {
+	data, err := l.db.Get(l.key, nil)
+	if err == leveldb.ErrNotFound {
+		return 0, false, nil
+	} else if err != nil {
+		log.Errorf("Error getting checkpoint [%v] from DB %s: %v", l.key, l.dbName, err)
+		return 0, false, err
+	}
+
+	if len(data) != checkpointSize {
+		log.Errorf("Invalid checkpoint [%v] size: %d", l.key, len(data))
+		return 0, false, ErrInvalidCheckpoint
+	}
+	return binary.BigEndian.Uint64(data), true, nil
+}
+
+// Save puts the checkpoint into the database, flushed to disk
+func (l *LevelDBCheckpointer) Save(nextEntry uint64) error {
+	data := binary.BigEndian.AppendUint64(nil, nextEntry)
+	err := l.db.Put(l.key, data, &opt.WriteOptions{Sync: true})
+	if err != nil {
+		log.Errorf("Error saving checkpoint [%v] value [%d] to DB %s: %v", l.key, nextEntry, l.dbName, err)
+		return err
+	}
+	return nil
+}
+
+// Close closes the database
+func (l *LevelDBCheckpointer) Close() error {
+	return l.db.Close()
+}
@@ -1,9 +1,17 @@
 package datastreamer
 
 import (
+	"context"
 	"encoding/binary"
+	"errors"
 	"io"
+	"math"
+	"math/rand"
 	"net"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/0xPolygonHermez/zkevm-data-streamer/log"
@@ -14,55 +22,212 @@ const (
 	headersBuffer  = 32  // Buffers for the headers channel
 	entriesBuffer  = 128 // Buffers for the entries channel
 	entryRspBuffer = 32  // Buffers for data command response
+	errorsBuffer   = 16  // Buffers for the errors channel
+
+	defaultReconnectDelay = 5 * time.Second  // Default delay between reconnection attempts
+	endpointCheckTimeout  = 5 * time.Second  // Maximum time to get the header of a server before resuming the streaming
+	startCommandsTimeout  = 10 * time.Second // Maximum time to get the response of the commands sent on start
+	refetchTimeout        = 10 * time.Second // Maximum time to get a missing entry with the Entry command
+)
+
+// commandParams type for the parameters sent with a TCP command
+type commandParams struct {
+	fromEntry    uint64    // Entry number for the Start and Entry commands
+	fromBookmark []byte    // Bookmark for the StartBookmark and Bookmark commands
+	entryType    EntryType // Entry type for the LatestEntries command
+	count        uint64    // Number of entries for the LatestEntries command
+	fromTime     uint64    // Time in Unix milliseconds for the StartTime and EntryTime commands
+}
+
+// commandResponse type for the data received in response to a TCP command
+type commandResponse struct {
+	header  HeaderEntry // Header received from the Header command
+	entry   FileEntry   // Entry received from the Entry and Bookmark commands
+	entries []FileEntry // Entries received from the LatestEntries command
+}
+
+// GapPolicy type for the client action on a gap in the streamed entries sequence
+type GapPolicy uint32
+
+const (
+	GapPolicyError     GapPolicy = 0 // GapPolicyError for closing the client with ErrStreamGap
+	GapPolicyRefetch   GapPolicy = 1 // GapPolicyRefetch for getting the missing entries with the Entry command
+	GapPolicyReconnect GapPolicy = 2 // GapPolicyReconnect for reconnecting and streaming from the next entry expected
 )
 
+// SequenceStats type for the counters of the streamed entries out of sequence
+type SequenceStats struct {
+	Gaps       uint64 // Gaps detected in the sequence
+	Missing    uint64 // Entries missing in the gaps
+	Refetched  uint64 // Missing entries got with the Entry command
+	Duplicates uint64 // Entries received again, discarded
+}
+
 // ProcessEntryFunc type of the callback function to process the received entry
 type ProcessEntryFunc func(*FileEntry, *StreamClient, *StreamServer) error
 
+// ConnectFunc type of the callback function called when the client connects to the server
+type ConnectFunc func(*StreamClient)
+
+// DisconnectFunc type of the callback function called when the client loses the connection to the server
+type DisconnectFunc func(*StreamClient, error)
+
 // StreamClient type to manage a data stream client
 type StreamClient struct {
-	server     string // Server address to connect IP:port
+	server     string     // Server address connected IP:port
+	endpoints  []Endpoint // Server endpoints sorted by priority
+	endpoint   int        // Index of the endpoint to connect
 	streamType StreamType
 	conn       net.Conn
 	Id         string // Client id
 	started    bool   // Flag client started
 	connected  bool   // Flag client connected to server
-	streaming  bool   // Flag client streaming started
-
-	FromEntry    uint64      // Set starting entry number for the Start command
-	FromBookmark []byte      // Set starting bookmark for the StartBookmark command
-	Header       HeaderEntry // Header info received from the Header command
-	Entry        FileEntry   // Entry info received from the Entry command
+	streaming  bool   // Flag client streaming started (protected by the write mutex)
+
+	// FromEntry sets the entry number for the Start and Entry commands.
+	//
+	// Deprecated: use StreamFrom and GetEntry, safe for concurrent use.
+	FromEntry uint64
+	// FromBookmark sets the bookmark for the StartBookmark and Bookmark commands.
+	//
+	// Deprecated: use StreamFromBookmark and GetBookmark, safe for concurrent use.
+	FromBookmark []byte
+	// Header is filled with the header info received from the Header command.
+	//
+	// Deprecated: use GetHeader, safe for concurrent use.
+	Header HeaderEntry
+	// Entry is filled with the entry info received from the Entry and Bookmark commands.
+	//
+	// Deprecated: use GetEntry and GetBookmark, safe for concurrent use.
+	Entry FileEntry
+
+	commands chan struct{} // Semaphore to serialize the commands (the responses are matched by order)
+	connLost chan struct{} // Channel closed when the current connection is lost (protected by the write mutex)
 
 	results  chan ResultEntry // Channel to read command results
 	headers  chan HeaderEntry // Channel to read header entries from the command Header
 	entries  chan FileEntry   // Channel to read data entries from the streaming
 	entryRsp chan FileEntry   // Channel to read data entries from the commands response
 
-	nextEntry    uint64           // Next entry number to receive from streaming
+	resumeCmd    Command          // Command to resume the streaming on reconnection (CmdStart, CmdStartBookmark or CmdStartTime)
+	resumeFrom   commandParams    // Parameters of the resume command, updated with the entries received
+	rewinding    bool             // Flag resume point set to reconnect, not updated until the session is restored
 	processEntry ProcessEntryFunc // Callback function to process the entry
 	relayServer  *StreamServer    // Only used by the client on the stream relay server
+	subscription chan FileEntry   // Channel to deliver the streamed entries when subscribed (nil if using the callback)
+
+	gapPolicy     GapPolicy     // Action on a gap in the streamed entries sequence
+	expected      uint64        // Next entry number expected from the streaming
+	expectedKnown bool          // Flag next entry known (not when streaming from a bookmark until the first entry)
+	rewind        bool          // Flag reconnecting from the expected entry, discarding the entries received after it
+	mutexSequence sync.Mutex    // Mutex for the expected entry, set when the streaming starts
+	seqStats      SequenceStats // Entries out of sequence counters
+
+	checkpointer    Checkpointer  // Persists the next entry to process (nil if not set)
+	checkpointed    uint64        // Next entry of the last checkpoint saved
+	pulled          atomic.Uint64 // Next entry of the last one returned by Next (0 if none), checkpointed on the next call
+	mutexCheckpoint sync.Mutex
+
+	compression CompressionMode  // Compression of the streamed data entries
+	compressed  bool             // Flag compression accepted by the server (protected by the write mutex)
+	wireStats   CompressionStats // Data entries bytes received compressed
+
+	timestamps  bool   // Request the commit time of the streamed entries
+	timestamped bool   // Flag timestamps accepted by the server (protected by the write mutex)
+	timestamp   uint64 // Commit time of the entries being received (read goroutine)
+
+	heartbeatInterval time.Duration // Interval to send ping commands to the server (0 disables)
+	heartbeatTimeout  time.Duration // Maximum time without packets from the server (0 disables)
+	mutexWrite        sync.Mutex    // Mutex to serialize the writes to the server connection
+
+	commandTimeout time.Duration // Maximum time to send a command and receive its response (0 disables)
+
+	reconnect        ReconnectPolicy // Policy of the reconnection attempts
+	attempts         int             // Connection attempts since the last established connection
+	retries          int             // Waits before a connection attempt since the last established connection
+	endpointAttempts int             // Failed connection attempts to the current endpoint
+	failingBack      bool            // Flag connection closed to reconnect to the highest priority endpoint (protected by the write mutex)
+	onConnect        ConnectFunc     // Callback function called on connection (nil if not set)
+	onDisconnect     DisconnectFunc  // Callback function called on connection loss (nil if not set)
+
+	closed    chan struct{} // Channel closed when the client is closed, stops the client goroutines
+	closeOnce sync.Once
+	closeErr  error      // Error that closed the client (nil if closed by Close)
+	errs      chan error // Channel to report the errors of the client goroutines
 }
 
 // NewClient creates a new data stream client
 func NewClient(server string, streamType StreamType) (*StreamClient, error) {
+	return NewClientWithConfig(ClientConfig{Server: server, StreamType: streamType})
+}
+
+// NewClientWithConfig creates a new data stream client using the configuration
+func NewClientWithConfig(cfg ClientConfig) (*StreamClient, error) {
+	// Check compression mode
+	if cfg.Compression != CompressionNone && cfg.Compression != CompressionSnappy {
+		log.Errorf("Invalid compression mode: %d", cfg.Compression)
+		return nil, ErrInvalidCompressionMode
+	}
+
+	// Check gap policy
+	if cfg.GapPolicy > GapPolicyReconnect {
+		log.Errorf("Invalid gap policy: %d", cfg.GapPolicy)
+		return nil, ErrInvalidGapPolicy
+	}
+
+	// Reconnection defaults (fixed delay, unlimited attempts)
+	if cfg.Reconnect.InitialDelay == 0 {
+		cfg.Reconnect.InitialDelay = defaultReconnectDelay
+	}
+	if cfg.Reconnect.Multiplier < 1 {
+		cfg.Reconnect.Multiplier = 1
+	}
+
+	// Server endpoints by priority, the single server if not set
+	endpoints := []Endpoint{{Server: cfg.Server}}
+	if len(cfg.Endpoints) > 0 {
+		endpoints = make([]Endpoint, len(cfg.Endpoints))
+		copy(endpoints, cfg.Endpoints)
+		sort.SliceStable(endpoints, func(i, j int) bool {
+			return endpoints[i].Priority < endpoints[j].Priority
+		})
+	}
+
 	// Create the client data stream
 	c := StreamClient{
-		server:     server,
-		streamType: streamType,
+		server:     endpoints[0].Server,
+		endpoints:  endpoints,
+		endpoint:   0,
+		streamType: cfg.StreamType,
 		Id:         "",
 		started:    false,
 		connected:  false,
 		streaming:  false,
 		FromEntry:  0,
 
+		commands: make(chan struct{}, 1),
+
 		results:  make(chan ResultEntry, resultsBuffer),
 		headers:  make(chan HeaderEntry, headersBuffer),
 		entries:  make(chan FileEntry, entriesBuffer),
 		entryRsp: make(chan FileEntry, entryRspBuffer),
 
-		nextEntry:   0,
 		relayServer: nil,
+
+		compression: cfg.Compression,
+		timestamps:  cfg.Timestamps,
+
+		heartbeatInterval: cfg.HeartbeatInterval,
+		heartbeatTimeout:  cfg.HeartbeatTimeout,
+
+		commandTimeout: cfg.CommandTimeout,
+
+		reconnect: cfg.Reconnect,
+
+		gapPolicy: cfg.GapPolicy,
+
+		closed: make(chan struct{}),
+		errs:   make(chan error, errorsBuffer),
 	}
 
 	// Set default callback function to process entry
@@ -73,8 +238,27 @@ func NewClient(server string, streamType StreamType) (*StreamClient, error) {
 
 // Start connects to the data stream server and starts getting data from the server
 func (c *StreamClient) Start() error {
+	// Resume from the checkpoint
+	if c.checkpointer != nil {
+		nextEntry, found, err := c.checkpointer.Load()
+		if err != nil {
+			return err
+		}
+		if found {
+			log.Infof("Checkpoint loaded, next entry to process: %d", nextEntry)
+			c.FromEntry = nextEntry
+			c.checkpointed = nextEntry
+		}
+	}
+
 	// Connect to server
 	c.connectServer()
+	if c.isClosed() {
+		if c.closeErr != nil {
+			return c.closeErr
+		}
+		return ErrClientClosed
+	}
 
 	// Goroutine to read from the server all entry types
 	go c.readEntries()
@@ -82,77 +266,698 @@ func (c *StreamClient) Start() error {
 	// Goroutine to consume streaming entries
 	go c.getStreaming()
 
+	// Goroutine to send the heartbeat to the server
+	if c.heartbeatInterval > 0 {
+		go c.heartbeat()
+	}
+
+	// Goroutine to fail back to the highest priority endpoint
+	if c.reconnect.FailbackInterval > 0 && len(c.endpoints) > 1 {
+		go c.failback()
+	}
+
 	// Flag stared
 	c.started = true
 
+	// Request the session options, the client is closed (connection and goroutines) if they fail
+	err := c.requestOptions()
+	if err != nil {
+		c.closeWithError(err)
+		return err
+	}
+
 	return nil
 }
 
-// connectServer waits until the server connection is established and returns if a command result is pending
-func (c *StreamClient) connectServer() bool {
-	var err error
+// requestOptions requests the compression and the commit time of the streamed data entries if configured
+func (c *StreamClient) requestOptions() error {
+	ctx, cancel := context.WithTimeout(context.Background(), startCommandsTimeout)
+	defer cancel()
+
+	// Request the compression of the streamed data entries
+	if c.compression != CompressionNone {
+		_, err := c.request(ctx, CmdCompression, commandParams{})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Request the commit time of the streamed data entries
+	if c.timestamps {
+		_, err := c.request(ctx, CmdTimestamps, commandParams{})
+		return err
+	}
+
+	return nil
+}
+
+// Run starts the client (if not started) and waits until the context is done or the client is closed.
+// Returns the error of the entry processing callback if it closed the client, or the context error
+func (c *StreamClient) Run(ctx context.Context) error {
+	if !c.started {
+		err := c.Start()
+		if err != nil {
+			return err
+		}
+	}
+
+	select {
+	case <-ctx.Done():
+		_ = c.Close()
+		return ctx.Err()
+	case <-c.closed:
+		return c.closeErr
+	}
+}
+
+// Close closes the connection to the server and stops the client goroutines
+func (c *StreamClient) Close() error {
+	c.closeWithError(nil)
+	return nil
+}
+
+// Errors returns the channel where the errors of the client goroutines are reported. An entry processing
+// callback error is reported and closes the client. Errors are dropped if the channel buffer is full
+func (c *StreamClient) Errors() <-chan error {
+	return c.errs
+}
+
+// closeWithError closes the client reporting the error that caused it (if any)
+func (c *StreamClient) closeWithError(err error) {
+	c.closeOnce.Do(func() {
+		if err != nil {
+			c.reportError(err)
+		}
+		c.closeErr = err
+		close(c.closed)
+
+		c.mutexWrite.Lock()
+		if c.conn != nil {
+			c.conn.Close()
+		}
+		c.mutexWrite.Unlock()
+	})
+}
+
+// reportError sends the error to the errors channel without blocking
+func (c *StreamClient) reportError(err error) {
+	select {
+	case c.errs <- err:
+	default:
+		log.Warnf("%s Error not reported, errors channel full: %v", c.Id, err)
+	}
+}
+
+// isClosed returns if the client is closed
+func (c *StreamClient) isClosed() bool {
+	select {
+	case <-c.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// wait sleeps for the duration or until the client is closed
+func (c *StreamClient) wait(d time.Duration) {
+	select {
+	case <-time.After(d):
+	case <-c.closed:
+	}
+}
+
+// connectServer waits until the server connection is established and returns the number of command results pending.
+// The current endpoint is retried up to the reconnect policy attempts per endpoint, then the next ones by priority,
+// and the client is closed if the reconnect policy attempts are exhausted
+func (c *StreamClient) connectServer() int {
+	round := len(c.endpoints) * c.reconnect.endpointAttempts()
 
 	// Connect to server
-	for !c.connected {
-		c.conn, err = net.Dial("tcp", c.server)
+	for !c.connected && !c.isClosed() {
+		// Wait before retrying the same endpoint or when all the endpoints failed
+		if c.attempts > 0 && (c.endpointAttempts > 0 || c.attempts%round == 0) {
+			if c.reconnect.MaxAttempts > 0 && c.attempts%round == 0 && c.attempts/round >= c.reconnect.MaxAttempts {
+				log.Errorf("Unable to connect to any server endpoint after %d attempts", c.attempts/round)
+				c.closeWithError(ErrReconnectAttemptsExhausted)
+				return 0
+			}
+			c.retries++
+			c.wait(c.reconnect.delay(c.retries))
+		}
+		c.attempts++
+
+		server := c.endpoints[c.endpoint].Server
+		conn, err := net.Dial("tcp", server)
 		if err != nil {
-			log.Infof("Error connecting to server %s: %v", c.server, err)
-			time.Sleep(5 * time.Second) // nolint:gomnd
+			log.Infof("Error connecting to server %s (attempt %d): %v", server, c.attempts, err)
+			c.endpointFailed()
 			continue
 		} else {
-			// Connected
+			// Connected, the session is restored before any other command is sent
+			c.mutexWrite.Lock()
+			if server != c.server {
+				log.Infof("Failover from server %s to %s", c.server, server)
+			}
+			c.conn = conn
+			c.server = server
 			c.connected = true
+			c.connLost = make(chan struct{})
 			c.Id = c.conn.LocalAddr().String()
 			log.Infof("%s Connected to server: %s", c.Id, c.server)
 
-			// Restore streaming
-			if c.streaming {
-				c.FromEntry = c.nextEntry
-				err = c.execCommand(CmdStart, true)
-				if err != nil {
-					c.closeConnection()
-					time.Sleep(5 * time.Second) // nolint:gomnd
-					continue
-				}
-				return true
-			} else {
-				return false
+			// Discard the responses of the commands sent to the previous connection
+			c.drainResponses()
+
+			err := c.checkEndpoint()
+			pending := 0
+			if err == nil {
+				pending, err = c.restoreSession()
 			}
+			c.mutexWrite.Unlock()
+			if err != nil {
+				c.closeConnection(err)
+				c.endpointFailed()
+				continue
+			}
+
+			// Established, otherwise when the session restore results are received
+			if pending == 0 {
+				c.established()
+			}
+
+			// Notify the connection
+			if c.onConnect != nil {
+				c.onConnect(c)
+			}
+			return pending
 		}
 	}
-	return false
+	return 0
+}
+
+// established resets the reconnection attempts once the connection is established
+func (c *StreamClient) established() {
+	c.attempts = 0
+	c.retries = 0
+	c.endpointAttempts = 0
+}
+
+// endpointFailed counts a failed connection attempt to the current endpoint, failing over to the next
+// endpoint by priority when the reconnect policy attempts per endpoint are exhausted
+func (c *StreamClient) endpointFailed() {
+	c.endpointAttempts++
+	if c.endpointAttempts >= c.reconnect.endpointAttempts() {
+		c.nextEndpoint()
+	}
+}
+
+// nextEndpoint selects the next server endpoint by priority to connect (the first after the last one)
+func (c *StreamClient) nextEndpoint() {
+	c.endpoint = (c.endpoint + 1) % len(c.endpoints)
+	c.endpointAttempts = 0
 }
 
-// closeConnection closes connection to the server
-func (c *StreamClient) closeConnection() {
+// failback checks every failback interval if the highest priority endpoint can resume the streaming while
+// connected to another one, and then reconnects to it
+func (c *StreamClient) failback() {
+	ticker := time.NewTicker(c.reconnect.FailbackInterval)
+	defer ticker.Stop()
+
+	primary := c.endpoints[0].Server
+	for {
+		select {
+		case <-ticker.C:
+		case <-c.closed:
+			return
+		}
+
+		// Entry to resume the streaming from
+		c.mutexWrite.Lock()
+		secondary := c.connected && c.server != primary
+		var fromEntry uint64
+		if c.streaming && c.resumeCmd == CmdStart {
+			fromEntry = c.resumeFrom.fromEntry
+		}
+		c.mutexWrite.Unlock()
+		if !secondary {
+			continue
+		}
+
+		// Check the endpoint is reachable and has the entries to resume the streaming
+		header, err := c.probeEndpoint(primary)
+		if err != nil || header.TotalEntries < fromEntry {
+			log.Debugf("%s Server %s not ready to fail back: %v", c.Id, primary, err)
+			continue
+		}
+
+		// Reconnect to it, the streaming is resumed as on a connection loss
+		c.mutexWrite.Lock()
+		if c.connected && c.server != primary {
+			log.Infof("%s Failing back from server %s to %s", c.Id, c.server, primary)
+			c.failingBack = true
+			c.conn.Close()
+		}
+		c.mutexWrite.Unlock()
+	}
+}
+
+// probeEndpoint gets the header of the server of an endpoint with a short-lived connection
+func (c *StreamClient) probeEndpoint(server string) (HeaderEntry, error) {
+	probe, err := NewClientWithConfig(ClientConfig{
+		Server:     server,
+		StreamType: c.streamType,
+		Reconnect:  ReconnectPolicy{MaxAttempts: 1},
+	})
+	if err != nil {
+		return HeaderEntry{}, err
+	}
+	defer probe.Close() // nolint:errcheck
+
+	err = probe.Start()
+	if err != nil {
+		return HeaderEntry{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), endpointCheckTimeout)
+	defer cancel()
+	return probe.GetHeader(ctx)
+}
+
+// checkEndpoint verifies that the server stream has the entries to resume the streaming without gaps.
+// The write mutex must be held
+func (c *StreamClient) checkEndpoint() error {
+	if !c.streaming || c.resumeCmd != CmdStart || c.resumeFrom.fromEntry == 0 {
+		return nil
+	}
+
+	// Get the header synchronously, the session is not restored yet
+	_ = c.conn.SetDeadline(time.Now().Add(endpointCheckTimeout))
+	defer c.conn.SetDeadline(time.Time{}) // nolint:errcheck
+	err := c.writeCommand(CmdHeader, commandParams{})
+	if err != nil {
+		return err
+	}
+
+	var h HeaderEntry
+	for received := false; !received; {
+		packet := make([]byte, 1)
+		_, err = io.ReadFull(c.conn, packet)
+		if err != nil {
+			log.Errorf("%s Error reading the header to resume: %v", c.Id, err)
+			return err
+		}
+
+		switch packet[0] {
+		case PtResult:
+			r, err := c.readResultEntry()
+			if err != nil {
+				return err
+			}
+			if r.errorNum != uint32(CmdErrOK) {
+				return ErrResultCommandError
+			}
+		case PtHeader:
+			h, err = c.readHeaderEntry()
+			if err != nil {
+				return err
+			}
+			received = true
+		case PtPing, PtPong:
+			// Heartbeat from the server
+		default:
+			log.Warnf("%s Unexpected packet type %d reading the header to resume", c.Id, packet[0])
+			return ErrGettingHeaderInfo
+		}
+	}
+
+	// The previous entries must be in the server stream
+	if h.TotalEntries < c.resumeFrom.fromEntry {
+		log.Warnf("%s Server %s has %d entries, behind the entry %d to resume", c.Id, c.server, h.TotalEntries, c.resumeFrom.fromEntry)
+		return ErrServerBehind
+	}
+	return nil
+}
+
+// endpointAttempts returns the number of connection attempts to an endpoint before failing over to the next one
+func (p ReconnectPolicy) endpointAttempts() int {
+	if p.EndpointAttempts < 1 {
+		return 1
+	}
+	return p.EndpointAttempts
+}
+
+// delay returns the time to wait before the reconnection attempt (1 for the first retry)
+func (p ReconnectPolicy) delay(attempt int) time.Duration {
+	d := float64(p.InitialDelay) * math.Pow(p.Multiplier, float64(attempt-1))
+	if p.MaxDelay > 0 && d > float64(p.MaxDelay) {
+		d = float64(p.MaxDelay)
+	}
+	if p.Jitter > 0 {
+		d = d * (1 + p.Jitter*(2*rand.Float64()-1)) // nolint:gosec,gomnd
+	}
+	return time.Duration(d)
+}
+
+// restoreSession sends the commands to restore the compression, the timestamps and the streaming in a new connection,
+// returning the number of command results pending. The write mutex must be held
+func (c *StreamClient) restoreSession() (int, error) {
+	pending := 0
+
+	// Restore compression
+	if c.compressed {
+		err := c.writeCommand(CmdCompression, commandParams{})
+		if err != nil {
+			return 0, err
+		}
+		pending++
+	}
+
+	// Restore timestamps
+	if c.timestamped {
+		err := c.writeCommand(CmdTimestamps, commandParams{})
+		if err != nil {
+			return 0, err
+		}
+		pending++
+	}
+
+	// Restore streaming from the entry next to the last one received
+	if c.streaming {
+		err := c.writeCommand(c.resumeCmd, c.resumeFrom)
+		if err != nil {
+			return 0, err
+		}
+		c.rewinding = false
+		pending++
+	}
+
+	return pending, nil
+}
+
+// closeConnection closes connection to the server, notifying the disconnection cause
+func (c *StreamClient) closeConnection(cause error) {
 	if c.conn != nil {
 		log.Infof("%s Close connection", c.Id)
 		c.conn.Close()
 	}
+	c.mutexWrite.Lock()
+	connected := c.connected
 	c.connected = false
+
+	// Fail the commands waiting for their responses
+	if connected {
+		close(c.connLost)
+	}
+
+	// Reconnect to the highest priority endpoint if failing back, otherwise retry the current one first
+	if c.failingBack {
+		c.failingBack = false
+		c.endpoint = 0
+		c.endpointAttempts = 0
+	}
+	c.mutexWrite.Unlock()
+
+	// Notify the disconnection
+	if connected && c.onDisconnect != nil {
+		c.onDisconnect(c, cause)
+	}
+}
+
+// heartbeat sends a ping command to the server every heartbeat interval
+func (c *StreamClient) heartbeat() {
+	ticker := time.NewTicker(c.heartbeatInterval)
+	defer ticker.Stop()
+
+	ping := make([]byte, 0, 16) // nolint:gomnd
+	ping = binary.BigEndian.AppendUint64(ping, uint64(CmdPing))
+	ping = binary.BigEndian.AppendUint64(ping, uint64(c.streamType))
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-c.closed:
+			return
+		}
+
+		c.mutexWrite.Lock()
+		if c.connected {
+			err := writeFullBytes(ping, c.conn)
+			if err != nil {
+				log.Warnf("%s Error sending ping: %v", c.Id, err)
+			}
+		}
+		c.mutexWrite.Unlock()
+	}
 }
 
 // ExecCommand executes a valid client TCP command
 func (c *StreamClient) ExecCommand(cmd Command) error {
-	return c.execCommand(cmd, false)
+	return c.ExecCommandCtx(context.Background(), cmd)
+}
+
+// ExecCommandCtx executes a valid client TCP command until the context is done
+func (c *StreamClient) ExecCommandCtx(ctx context.Context, cmd Command) error {
+	rsp, err := c.request(ctx, cmd, commandParams{fromEntry: c.FromEntry, fromBookmark: c.FromBookmark})
+	if err != nil {
+		return err
+	}
+
+	// Fill the response fields
+	switch cmd {
+	case CmdHeader:
+		c.Header = rsp.header
+	case CmdEntry, CmdBookmark:
+		c.Entry = rsp.entry
+	}
+	return nil
+}
+
+// GetHeader returns the header of the server stream file
+func (c *StreamClient) GetHeader(ctx context.Context) (HeaderEntry, error) {
+	rsp, err := c.request(ctx, CmdHeader, commandParams{})
+	return rsp.header, err
+}
+
+// GetEntry returns the entry of the server stream file by entry number
+func (c *StreamClient) GetEntry(ctx context.Context, entryNum uint64) (FileEntry, error) {
+	rsp, err := c.request(ctx, CmdEntry, commandParams{fromEntry: entryNum})
+	return rsp.entry, err
+}
+
+// GetBookmark returns the first entry of the server stream file after the bookmark
+func (c *StreamClient) GetBookmark(ctx context.Context, bookmark []byte) (FileEntry, error) {
+	rsp, err := c.request(ctx, CmdBookmark, commandParams{fromBookmark: bookmark})
+	return rsp.entry, err
+}
+
+// GetLatestEntries returns up to count entries of the entry type of the server stream file, from the last one
+// backwards (the server returns 1000 entries at most)
+func (c *StreamClient) GetLatestEntries(ctx context.Context, entryType EntryType, count uint64) ([]FileEntry, error) {
+	rsp, err := c.request(ctx, CmdLatestEntries, commandParams{entryType: entryType, count: count})
+	return rsp.entries, err
+}
+
+// StreamFrom starts the streaming from the entry number
+func (c *StreamClient) StreamFrom(ctx context.Context, fromEntry uint64) error {
+	_, err := c.request(ctx, CmdStart, commandParams{fromEntry: fromEntry})
+	return err
+}
+
+// StreamFromBookmark starts the streaming from the entry pointed by the bookmark
+func (c *StreamClient) StreamFromBookmark(ctx context.Context, bookmark []byte) error {
+	_, err := c.request(ctx, CmdStartBookmark, commandParams{fromBookmark: bookmark})
+	return err
+}
+
+// StreamFromTime starts the streaming from the first entry committed at or after the time (or the next
+// entry committed if none)
+func (c *StreamClient) StreamFromTime(ctx context.Context, t time.Time) error {
+	_, err := c.request(ctx, CmdStartTime, commandParams{fromTime: unixMilli(t)})
+	return err
+}
+
+// GetEntryFromTime returns the first entry of the server stream file committed at or after the time
+func (c *StreamClient) GetEntryFromTime(ctx context.Context, t time.Time) (FileEntry, error) {
+	rsp, err := c.request(ctx, CmdEntryTime, commandParams{fromTime: unixMilli(t)})
+	return rsp.entry, err
+}
+
+// StreamFromCheckpoint starts receiving the stream from the entry next to the last one checkpointed (0 if none)
+func (c *StreamClient) StreamFromCheckpoint(ctx context.Context) error {
+	c.mutexCheckpoint.Lock()
+	fromEntry := c.checkpointed
+	c.mutexCheckpoint.Unlock()
+	return c.StreamFrom(ctx, fromEntry)
+}
+
+// StopStreaming stops the streaming
+func (c *StreamClient) StopStreaming(ctx context.Context) error {
+	_, err := c.request(ctx, CmdStop, commandParams{})
+	return err
+}
+
+// request executes a TCP command waiting for the commands in progress, limited by the command timeout
+func (c *StreamClient) request(ctx context.Context, cmd Command, params commandParams) (commandResponse, error) {
+	if c.commandTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.commandTimeout)
+		defer cancel()
+	}
+
+	// Commands serialized, the responses are matched to the commands by order
+	select {
+	case c.commands <- struct{}{}:
+		defer func() { <-c.commands }()
+	case <-ctx.Done():
+		return commandResponse{}, c.commandError(ctx, cmd, ctx.Err())
+	}
+
+	return c.execCommand(ctx, cmd, params)
 }
 
-// execCommand executes a valid client TCP command with deferred command result possibility
-func (c *StreamClient) execCommand(cmd Command, deferredResult bool) error {
+// execCommand executes a valid client TCP command until the context is done
+func (c *StreamClient) execCommand(ctx context.Context, cmd Command, params commandParams) (commandResponse, error) {
+	var rsp commandResponse
 	log.Infof("%s Executing command %d[%s]...", c.Id, cmd, StrCommand[cmd])
 
 	// Check status of the client
 	if !c.started {
 		log.Errorf("Execute command not allowed. Client is not started")
-		return ErrExecCommandNotAllowed
+		return rsp, ErrExecCommandNotAllowed
+	}
+	if c.isClosed() {
+		log.Errorf("Execute command not allowed. Client is closed")
+		return rsp, ErrClientClosed
 	}
 
 	// Check valid command
 	if !cmd.IsACommand() {
 		log.Errorf("%s Invalid command %d", c.Id, cmd)
-		return ErrInvalidCommand
+		return rsp, ErrInvalidCommand
+	}
+
+	// Check the context before sending
+	if ctx.Err() != nil {
+		return rsp, ctx.Err()
+	}
+
+	// Send command and its parameters
+	lost, err := c.sendCommand(ctx, cmd, params)
+	if err != nil {
+		return rsp, c.commandError(ctx, cmd, err)
+	}
+
+	// Get the command result (failed if the connection is lost)
+	r, err := c.getResult(ctx, cmd, lost)
+	if err != nil {
+		return rsp, err
+	}
+	if r.errorNum != uint32(CmdErrOK) {
+		return rsp, ErrResultCommandError
+	}
+
+	// Get the data response and update streaming flag
+	switch cmd {
+	case CmdStart, CmdStartBookmark, CmdStartTime:
+		c.setStreaming(true)
+	case CmdStop:
+		c.setStreaming(false)
+	case CmdCompression:
+		c.mutexWrite.Lock()
+		c.compressed = true
+		c.mutexWrite.Unlock()
+	case CmdTimestamps:
+		c.mutexWrite.Lock()
+		c.timestamped = true
+		c.mutexWrite.Unlock()
+	case CmdHeader:
+		rsp.header, err = c.getHeader(ctx, cmd, lost)
+		if err != nil {
+			return rsp, err
+		}
+	case CmdEntry:
+		rsp.entry, err = c.getEntry(ctx, cmd, lost)
+		if err != nil {
+			return rsp, err
+		}
+		if rsp.entry.Type == EntryTypeNotFound {
+			return rsp, ErrEntryNotFound
+		}
+	case CmdBookmark:
+		rsp.entry, err = c.getEntry(ctx, cmd, lost)
+		if err != nil {
+			return rsp, err
+		}
+		if rsp.entry.Type == EntryTypeNotFound {
+			return rsp, ErrBookmarkNotFound
+		}
+	case CmdEntryTime:
+		rsp.entry, err = c.getEntry(ctx, cmd, lost)
+		if err != nil {
+			return rsp, err
+		}
+		if rsp.entry.Type == EntryTypeNotFound {
+			return rsp, ErrEntryNotFound
+		}
+	case CmdLatestEntries:
+		// Entries until the not found entry (end of the list)
+		rsp.entries = []FileEntry{}
+		for {
+			e, err := c.getEntry(ctx, cmd, lost)
+			if err != nil {
+				return rsp, err
+			}
+			if e.Type == EntryTypeNotFound {
+				break
+			}
+			rsp.entries = append(rsp.entries, e)
+		}
+	}
+
+	return rsp, nil
+}
+
+// setStreaming sets the streaming flag, read when the session is restored
+func (c *StreamClient) setStreaming(streaming bool) {
+	c.mutexWrite.Lock()
+	c.streaming = streaming
+	c.mutexWrite.Unlock()
+}
+
+// sendCommand sends to the server a TCP command with its parameters. Returns the channel closed when the
+// connection where it's sent is lost
+func (c *StreamClient) sendCommand(ctx context.Context, cmd Command, params commandParams) (chan struct{}, error) {
+	c.mutexWrite.Lock()
+	defer c.mutexWrite.Unlock()
+
+	// Not sent while reconnecting
+	if !c.connected {
+		return nil, ErrConnectionLost
+	}
+
+	// Writes limited by the context deadline
+	if deadline, ok := ctx.Deadline(); ok && c.conn != nil {
+		_ = c.conn.SetWriteDeadline(deadline)
+		defer c.conn.SetWriteDeadline(time.Time{}) // nolint:errcheck
+	}
+
+	// Streaming resumed from the start point until entries are received
+	if !c.streaming && (cmd == CmdStart || cmd == CmdStartBookmark || cmd == CmdStartTime) {
+		c.resumeCmd = cmd
+		c.resumeFrom = params
+
+		c.mutexSequence.Lock()
+		c.expected = params.fromEntry
+		c.expectedKnown = cmd == CmdStart
+		c.rewind = false
+		c.mutexSequence.Unlock()
 	}
 
+	return c.connLost, c.writeCommand(cmd, params)
+}
+
+// writeCommand writes to the server connection a TCP command with its parameters. The write mutex must be held
+func (c *StreamClient) writeCommand(cmd Command, params commandParams) error {
 	// Send command
 	err := writeFullUint64(uint64(cmd), c.conn)
 	if err != nil {
@@ -167,76 +972,69 @@ func (c *StreamClient) execCommand(cmd Command, deferredResult bool) error {
 	// Send the command parameters
 	switch cmd {
 	case CmdStart:
-		log.Infof("%s ...from entry %d", c.Id, c.FromEntry)
+		log.Infof("%s ...from entry %d", c.Id, params.fromEntry)
 		// Send starting/from entry number
-		err = writeFullUint64(c.FromEntry, c.conn)
+		err = writeFullUint64(params.fromEntry, c.conn)
 		if err != nil {
 			return err
 		}
 	case CmdStartBookmark:
-		log.Infof("%s ...from bookmark [%v]", c.Id, c.FromBookmark)
+		log.Infof("%s ...from bookmark [%v]", c.Id, params.fromBookmark)
 		// Send starting/from bookmark length
-		err = writeFullUint32(uint32(len(c.FromBookmark)), c.conn)
+		err = writeFullUint32(uint32(len(params.fromBookmark)), c.conn)
 		if err != nil {
 			return err
 		}
 		// Send starting/from bookmark
-		err = writeFullBytes(c.FromBookmark, c.conn)
+		err = writeFullBytes(params.fromBookmark, c.conn)
 		if err != nil {
 			return err
 		}
 	case CmdEntry:
-		log.Infof("%s ...get entry %d", c.Id, c.FromEntry)
+		log.Infof("%s ...get entry %d", c.Id, params.fromEntry)
 		// Send entry to retrieve
-		err = writeFullUint64(c.FromEntry, c.conn)
+		err = writeFullUint64(params.fromEntry, c.conn)
 		if err != nil {
 			return err
 		}
 	case CmdBookmark:
-		log.Infof("%s ...get bookmark [%v]", c.Id, c.FromBookmark)
+		log.Infof("%s ...get bookmark [%v]", c.Id, params.fromBookmark)
 		// Send bookmark length
-		err = writeFullUint32(uint32(len(c.FromBookmark)), c.conn)
+		err = writeFullUint32(uint32(len(params.fromBookmark)), c.conn)
 		if err != nil {
 			return err
 		}
 		// Send bookmark to retrieve
-		err = writeFullBytes(c.FromBookmark, c.conn)
+		err = writeFullBytes(params.fromBookmark, c.conn)
 		if err != nil {
 			return err
 		}
-	}
-
-	// Get the command result
-	if !deferredResult {
-		r := c.getResult(cmd)
-		if r.errorNum != uint32(CmdErrOK) {
-			return ErrResultCommandError
+	case CmdStartTime, CmdEntryTime:
+		log.Infof("%s ...from time %d", c.Id, params.fromTime)
+		// Send time
+		err = writeFullUint64(params.fromTime, c.conn)
+		if err != nil {
+			return err
 		}
-	}
-
-	// Get the data response and update streaming flag
-	switch cmd {
-	case CmdStart:
-		c.streaming = true
-	case CmdStartBookmark:
-		c.streaming = true
-	case CmdStop:
-		c.streaming = false
-	case CmdHeader:
-		h := c.getHeader()
-		c.Header = h
-	case CmdEntry:
-		e := c.getEntry()
-		if e.Type == EntryTypeNotFound {
-			return ErrEntryNotFound
+	case CmdLatestEntries:
+		log.Infof("%s ...get latest %d entries of type %d", c.Id, params.count, params.entryType)
+		// Send entry type
+		err = writeFullUint32(uint32(params.entryType), c.conn)
+		if err != nil {
+			return err
 		}
-		c.Entry = e
-	case CmdBookmark:
-		e := c.getEntry()
-		if e.Type == EntryTypeNotFound {
-			return ErrBookmarkNotFound
+		// Send number of entries
+		err = writeFullUint64(params.count, c.conn)
+		if err != nil {
+			return err
+		}
+	case CmdCompression:
+		log.Infof("%s ...compression mode %d", c.Id, c.compression)
+		// Send compression mode
+		err = writeFullUint32(uint32(c.compression), c.conn)
+		if err != nil {
+			return err
 		}
-		c.Entry = e
 	}
 
 	return nil
@@ -339,6 +1137,31 @@ func (c *StreamClient) readDataEntry() (FileEntry, error) {
 	return d, nil
 }
 
+// readDataBatch reads bytes from server connection and returns the data entries of a compressed batch
+func (c *StreamClient) readDataBatch() ([]FileEntry, error) {
+	// Read the rest of fixed size fields
+	buffer := make([]byte, fixedSizeDataBatch-1)
+	_, err := io.ReadFull(c.conn, buffer)
+	if err != nil {
+		log.Errorf("%s Error reading from server: %v", c.Id, err)
+		return nil, err
+	}
+	compressedLength := binary.BigEndian.Uint32(buffer[0:4])
+	dataLength := binary.BigEndian.Uint32(buffer[4:8])
+
+	// Read variable field (compressed data)
+	compressed := make([]byte, compressedLength)
+	_, err = io.ReadFull(c.conn, compressed)
+	if err != nil {
+		log.Errorf("%s Error reading from server: %v", c.Id, err)
+		return nil, err
+	}
+	c.wireStats.add(int(dataLength), fixedSizeDataBatch+int(compressedLength))
+
+	// Decompress and decode the data entries
+	return decodeDataBatch(compressed, dataLength)
+}
+
 // readHeaderEntry reads bytes from server connection and returns a header entry type
 func (c *StreamClient) readHeaderEntry() (HeaderEntry, error) {
 	h := HeaderEntry{}
@@ -415,11 +1238,24 @@ func (c *StreamClient) readResultEntry() (ResultEntry, error) {
 
 // readEntries reads from the server all type of packets
 func (c *StreamClient) readEntries() {
-	defer c.closeConnection()
+	defer c.closeConnection(ErrClientClosed)
 
+	deferredResults := 0
 	for {
-		// Wait for connection
-		deferredResult := c.connectServer()
+		// Wait for connection (results pending from a previous connection are lost)
+		connected := c.connected
+		pending := c.connectServer()
+		if !connected {
+			deferredResults = pending
+		}
+		if c.isClosed() {
+			return
+		}
+
+		// Reconnect if no packets (data or heartbeat) are received in the heartbeat timeout
+		if c.heartbeatTimeout > 0 {
+			_ = c.conn.SetReadDeadline(time.Now().Add(c.heartbeatTimeout))
+		}
 
 		// Read packet type
 		packet := make([]byte, 1)
@@ -427,10 +1263,12 @@ func (c *StreamClient) readEntries() {
 		if err != nil {
 			if err == io.EOF {
 				log.Warnf("%s Server close connection", c.Id)
+			} else if errors.Is(err, os.ErrDeadlineExceeded) {
+				log.Warnf("%s Heartbeat lost, reconnecting", c.Id)
 			} else {
 				log.Errorf("%s Error reading from server: %v", c.Id, err)
 			}
-			c.closeConnection()
+			c.closeConnection(err)
 			continue
 		}
 
@@ -440,49 +1278,106 @@ func (c *StreamClient) readEntries() {
 			// Read result entry data
 			r, err := c.readResultEntry()
 			if err != nil {
-				c.closeConnection()
+				c.closeConnection(err)
 				continue
 			}
-			// Send data to results channel
-			c.results <- r
-			// Get the command deferred result
-			if deferredResult {
-				r := c.getResult(CmdStart)
+			// Results of the commands restoring the session are consumed here
+			if deferredResults > 0 {
+				deferredResults--
+				log.Infof("%s Result %d[%s] received for session restore", c.Id, r.errorNum, r.errorStr)
 				if r.errorNum != uint32(CmdErrOK) {
-					c.closeConnection()
-					time.Sleep(5 * time.Second) // nolint:gomnd
-					continue
+					deferredResults = 0
+					c.closeConnection(ErrResultCommandError)
+					c.endpointFailed()
+				} else if deferredResults == 0 {
+					c.established()
 				}
+				continue
+			}
+			// Send data to results channel
+			select {
+			case c.results <- r:
+			case <-c.closed:
+				return
 			}
 
 		case PtDataRsp:
 			// Read result entry data
 			r, err := c.readDataEntry()
 			if err != nil {
-				c.closeConnection()
+				c.closeConnection(err)
 				continue
 			}
-			c.entryRsp <- r
+			select {
+			case c.entryRsp <- r:
+			case <-c.closed:
+				return
+			}
 
 		case PtHeader:
 			// Read header entry data
 			h, err := c.readHeaderEntry()
 			if err != nil {
-				c.closeConnection()
+				c.closeConnection(err)
 				continue
 			}
 			// Send data to headers channel
-			c.headers <- h
+			select {
+			case c.headers <- h:
+			case <-c.closed:
+				return
+			}
 
 		case PtData:
 			// Read file/stream entry data
 			e, err := c.readDataEntry()
 			if err != nil {
-				c.closeConnection()
+				c.closeConnection(err)
 				continue
 			}
+			c.resumed(e.Number)
+			e.Timestamp = c.timestamp
+
 			// Send data to stream entries channel
-			c.entries <- e
+			select {
+			case c.entries <- e:
+			case <-c.closed:
+				return
+			}
+
+		case PtDataBatch:
+			// Read compressed batch of file/stream entries data
+			entries, err := c.readDataBatch()
+			if err != nil {
+				c.closeConnection(err)
+				continue
+			}
+			if len(entries) > 0 {
+				c.resumed(entries[len(entries)-1].Number)
+			}
+
+			// Send data to stream entries channel
+			for _, e := range entries {
+				e.Timestamp = c.timestamp
+				select {
+				case c.entries <- e:
+				case <-c.closed:
+					return
+				}
+			}
+
+		case PtTimestamp:
+			// Read commit time of the next entries
+			timestamp, err := readFullUint64(c.conn)
+			if err != nil {
+				c.closeConnection(err)
+				continue
+			}
+			c.timestamp = timestamp
+
+		case PtPing, PtPong:
+			// Heartbeat from the server
+			log.Debugf("%s Heartbeat packet %d received", c.Id, packet[0])
 
 		default:
 			// Unknown type
@@ -492,40 +1387,376 @@ func (c *StreamClient) readEntries() {
 	}
 }
 
-// getResult consumes a result entry
-func (c *StreamClient) getResult(cmd Command) ResultEntry {
+// resumed sets the resume point of the streaming next to the entry received (kept if reconnecting from an entry)
+func (c *StreamClient) resumed(entryNum uint64) {
+	c.mutexWrite.Lock()
+	if !c.rewinding {
+		c.resumeCmd = CmdStart
+		c.resumeFrom = commandParams{fromEntry: entryNum + 1}
+	}
+	c.mutexWrite.Unlock()
+}
+
+// getResult consumes a result entry, until the connection where the command was sent is lost
+func (c *StreamClient) getResult(ctx context.Context, cmd Command, lost chan struct{}) (ResultEntry, error) {
 	// Get result entry
-	r := <-c.results
-	log.Infof("%s Result %d[%s] received for command %d[%s]", c.Id, r.errorNum, r.errorStr, cmd, StrCommand[cmd])
-	return r
+	select {
+	case r := <-c.results:
+		log.Infof("%s Result %d[%s] received for command %d[%s]", c.Id, r.errorNum, r.errorStr, cmd, StrCommand[cmd])
+		return r, nil
+	case <-c.closed:
+		return ResultEntry{}, ErrClientClosed
+	case <-lost:
+		return ResultEntry{}, ErrConnectionLost
+	case <-ctx.Done():
+		return ResultEntry{}, c.commandError(ctx, cmd, ctx.Err())
+	}
+}
+
+// getHeader consumes a header entry, until the connection where the command was sent is lost
+func (c *StreamClient) getHeader(ctx context.Context, cmd Command, lost chan struct{}) (HeaderEntry, error) {
+	select {
+	case h := <-c.headers:
+		log.Infof("%s Header received info: TotalEntries[%d], TotalLength[%d]", c.Id, h.TotalEntries, h.TotalLength)
+		return h, nil
+	case <-c.closed:
+		return HeaderEntry{}, ErrClientClosed
+	case <-lost:
+		return HeaderEntry{}, ErrConnectionLost
+	case <-ctx.Done():
+		return HeaderEntry{}, c.commandError(ctx, cmd, ctx.Err())
+	}
 }
 
-// getHeader consumes a header entry
-func (c *StreamClient) getHeader() HeaderEntry {
-	h := <-c.headers
-	log.Infof("%s Header received info: TotalEntries[%d], TotalLength[%d]", c.Id, h.TotalEntries, h.TotalLength)
-	return h
+// getEntry consumes a entry from commands response, until the connection where the command was sent is lost
+func (c *StreamClient) getEntry(ctx context.Context, cmd Command, lost chan struct{}) (FileEntry, error) {
+	select {
+	case e := <-c.entryRsp:
+		log.Infof("%s Entry received info: Number[%d]", c.Id, e.Number)
+		return e, nil
+	case <-c.closed:
+		return FileEntry{}, ErrClientClosed
+	case <-lost:
+		return FileEntry{}, ErrConnectionLost
+	case <-ctx.Done():
+		return FileEntry{}, c.commandError(ctx, cmd, ctx.Err())
+	}
+}
+
+// commandError returns the error of a command, closing the connection if the command timed out
+// so a late response is not taken as the response of the next command
+func (c *StreamClient) commandError(ctx context.Context, cmd Command, err error) error {
+	timeout := ctx.Err() == context.DeadlineExceeded || errors.Is(err, os.ErrDeadlineExceeded)
+	if ctx.Err() == nil && !timeout {
+		return err
+	}
+
+	log.Warnf("%s Command %d[%s] not completed: %v", c.Id, cmd, StrCommand[cmd], err)
+	c.mutexWrite.Lock()
+	if c.conn != nil {
+		c.conn.Close()
+	}
+	c.mutexWrite.Unlock()
+
+	if timeout {
+		return ErrCommandTimeout
+	}
+	return ctx.Err()
 }
 
-// getEntry consumes a entry from commands response
-func (c *StreamClient) getEntry() FileEntry {
-	e := <-c.entryRsp
-	log.Infof("%s Entry received info: Number[%d]", c.Id, e.Number)
-	return e
+// drainResponses discards the command responses received from a previous connection
+func (c *StreamClient) drainResponses() {
+	for {
+		select {
+		case <-c.results:
+		case <-c.headers:
+		case <-c.entryRsp:
+		default:
+			return
+		}
+	}
 }
 
 // getStreaming consumes streaming data entries
 func (c *StreamClient) getStreaming() {
+	// No more entries to the subscription once stopped
+	if c.subscription != nil {
+		defer close(c.subscription)
+	}
+
+	var queue []FileEntry // Entries received while getting the missing ones
 	for {
-		e := <-c.entries
-		c.nextEntry = e.Number + 1
+		var e FileEntry
+		if len(queue) > 0 {
+			e, queue = queue[0], queue[1:]
+		} else {
+			select {
+			case e = <-c.entries:
+			case <-c.closed:
+				return
+			}
+		}
 
-		// Process the data entry
-		err := c.processEntry(&e, c, c.relayServer)
+		// Check the entry sequence, the client is closed on error
+		entries, err := c.checkSequence(e, &queue)
 		if err != nil {
-			log.Fatalf("%s Processing entry %d: %s. HALTED!", c.Id, e.Number, err.Error())
+			if c.isClosed() {
+				return
+			}
+			c.closeWithError(err)
+			return
+		}
+
+		for i := range entries {
+			// Process the data entry, the client is closed on error
+			err := c.processEntry(&entries[i], c, c.relayServer)
+			if err != nil {
+				if c.isClosed() {
+					return
+				}
+				log.Errorf("%s Processing entry %d: %s. HALTED!", c.Id, entries[i].Number, err.Error())
+				c.closeWithError(err)
+				return
+			}
+
+			// Entry processed by the callback (delivered, if subscribed)
+			if c.subscription == nil {
+				idle := i == len(entries)-1 && len(queue) == 0 && len(c.entries) == 0
+				err = c.checkpoint(entries[i].Number+1, idle)
+				if err != nil {
+					c.closeWithError(err)
+					return
+				}
+			}
+		}
+	}
+}
+
+// checkSequence validates that the entry is the next one expected, returning the entries to process in order:
+// none if duplicated or discarded, or the missing ones followed by the entry if refetched
+func (c *StreamClient) checkSequence(e FileEntry, queue *[]FileEntry) ([]FileEntry, error) {
+	c.mutexSequence.Lock()
+	expected, known, rewind := c.expected, c.expectedKnown, c.rewind
+	c.mutexSequence.Unlock()
+
+	entries := []FileEntry{e}
+	switch {
+	case !known || e.Number == expected:
+		// In sequence
+
+	case e.Number < expected:
+		atomic.AddUint64(&c.seqStats.Duplicates, 1)
+		log.Warnf("Duplicated entry %d received, expected %d. Discarded", e.Number, expected)
+		return nil, nil
+
+	case rewind:
+		// Received before reconnecting
+		log.Debugf("Entry %d received reconnecting from %d. Discarded", e.Number, expected)
+		return nil, nil
+
+	default:
+		atomic.AddUint64(&c.seqStats.Gaps, 1)
+		atomic.AddUint64(&c.seqStats.Missing, e.Number-expected)
+		log.Warnf("Gap of %d entries, entry %d received, expected %d", e.Number-expected, e.Number, expected)
+
+		switch c.gapPolicy {
+		case GapPolicyRefetch:
+			missing, err := c.refetch(expected, e.Number, queue)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(missing, e)
+		case GapPolicyReconnect:
+			c.reconnectFrom(expected)
+			return nil, nil
+		default:
+			log.Errorf("Gap in the streamed entries from entry %d", expected)
+			return nil, ErrStreamGap
 		}
 	}
+
+	// Next entry expected
+	c.mutexSequence.Lock()
+	c.expected = e.Number + 1
+	c.expectedKnown = true
+	c.rewind = false
+	c.mutexSequence.Unlock()
+
+	return entries, nil
+}
+
+// refetch gets the missing entries [from, to) with the Entry command, queueing the streamed entries meanwhile
+func (c *StreamClient) refetch(from uint64, to uint64, queue *[]FileEntry) ([]FileEntry, error) {
+	type result struct {
+		entries []FileEntry
+		err     error
+	}
+
+	// Requests canceled when returning (e.g. the client is closed), each one limited by the refetch timeout
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan result, 1)
+	go func() {
+		var r result
+		for n := from; n < to; n++ {
+			entryCtx, entryCancel := context.WithTimeout(ctx, refetchTimeout)
+			e, err := c.GetEntry(entryCtx, n)
+			entryCancel()
+			if err != nil {
+				r.err = err
+				break
+			}
+			r.entries = append(r.entries, e)
+		}
+		done <- r
+	}()
+
+	// The streamed entries keep being read, the responses come after them
+	for {
+		select {
+		case r := <-done:
+			if r.err != nil {
+				log.Errorf("Error getting the missing entries [%d, %d): %v", from, to, r.err)
+				return nil, r.err
+			}
+			atomic.AddUint64(&c.seqStats.Refetched, uint64(len(r.entries)))
+			log.Infof("Missing entries [%d, %d) got", from, to)
+			return r.entries, nil
+		case e := <-c.entries:
+			*queue = append(*queue, e)
+		case <-c.closed:
+			return nil, ErrClientClosed
+		}
+	}
+}
+
+// reconnectFrom closes the connection to reconnect and resume the streaming from the entry number
+func (c *StreamClient) reconnectFrom(entryNum uint64) {
+	log.Infof("Reconnecting to stream from entry %d", entryNum)
+
+	c.mutexSequence.Lock()
+	c.rewind = true
+	c.mutexSequence.Unlock()
+
+	c.mutexWrite.Lock()
+	c.resumeCmd = CmdStart
+	c.resumeFrom = commandParams{fromEntry: entryNum}
+	c.rewinding = true
+	conn := c.conn
+	c.mutexWrite.Unlock()
+
+	if conn != nil {
+		conn.Close()
+	}
+}
+
+// GetSequenceStats returns the counters of the streamed entries out of sequence
+func (c *StreamClient) GetSequenceStats() SequenceStats {
+	return SequenceStats{
+		Gaps:       atomic.LoadUint64(&c.seqStats.Gaps),
+		Missing:    atomic.LoadUint64(&c.seqStats.Missing),
+		Refetched:  atomic.LoadUint64(&c.seqStats.Refetched),
+		Duplicates: atomic.LoadUint64(&c.seqStats.Duplicates),
+	}
+}
+
+// GetCompressionStats returns the bytes of data entries received compressed
+func (c *StreamClient) GetCompressionStats() CompressionStats {
+	return c.wireStats.load()
+}
+
+// Subscribe returns a channel delivering the streamed data entries instead of the callback function (call it
+// before Start). The streaming is paused while the channel buffer is full. The channel is closed with the client
+func (c *StreamClient) Subscribe(buffer int) <-chan FileEntry {
+	c.subscription = make(chan FileEntry, buffer)
+	c.setProcessEntryFunc(sendSubscribedEntry, nil)
+	return c.subscription
+}
+
+// Next returns the next streamed data entry of the subscription, waiting until it's received or the context is done
+func (c *StreamClient) Next(ctx context.Context) (FileEntry, error) {
+	if c.subscription == nil {
+		return FileEntry{}, ErrNotSubscribed
+	}
+
+	// The entry returned by the previous call is processed
+	if pulled := c.pulled.Load(); pulled > 0 {
+		err := c.checkpoint(pulled, len(c.subscription) == 0)
+		if err != nil {
+			return FileEntry{}, err
+		}
+	}
+
+	select {
+	case e, ok := <-c.subscription:
+		if !ok {
+			if c.closeErr != nil {
+				return FileEntry{}, c.closeErr
+			}
+			return FileEntry{}, ErrClientClosed
+		}
+		c.pulled.Store(e.Number + 1)
+		return e, nil
+	case <-ctx.Done():
+		return FileEntry{}, ctx.Err()
+	}
+}
+
+// checkpoint saves the next entry to process when all the entries received are processed (end of batch),
+// or every checkpointMaxDelay entries
+func (c *StreamClient) checkpoint(nextEntry uint64, idle bool) error {
+	if c.checkpointer == nil {
+		return nil
+	}
+
+	c.mutexCheckpoint.Lock()
+	defer c.mutexCheckpoint.Unlock()
+	if nextEntry == c.checkpointed || (!idle && nextEntry-c.checkpointed < checkpointMaxDelay) {
+		return nil
+	}
+
+	err := c.checkpointer.Save(nextEntry)
+	if err != nil {
+		log.Errorf("%s Error saving checkpoint %d: %v", c.Id, nextEntry, err)
+		return err
+	}
+	c.checkpointed = nextEntry
+	return nil
+}
+
+// sendSubscribedEntry sends the entry to the subscription channel (callback function when subscribed)
+func sendSubscribedEntry(e *FileEntry, c *StreamClient, s *StreamServer) error {
+	select {
+	case c.subscription <- *e:
+		return nil
+	case <-c.closed:
+		return ErrClientClosed
+	}
+}
+
+// Server returns the address of the server endpoint connected (or the last one)
+func (c *StreamClient) Server() string {
+	c.mutexWrite.Lock()
+	defer c.mutexWrite.Unlock()
+	return c.server
+}
+
+// SetCheckpointer sets the checkpointer to resume from the last entry processed (call it before Start).
+// The next entry to process is loaded on Start into FromEntry, and saved after the entries are processed
+// by the callback function, or when Next is called again if subscribed. The checkpointer is not closed by the client
+func (c *StreamClient) SetCheckpointer(cp Checkpointer) {
+	c.checkpointer = cp
+}
+
+// SetConnectFunc sets the callback function called when the client connects to the server (call it before Start)
+func (c *StreamClient) SetConnectFunc(f ConnectFunc) {
+	c.onConnect = f
+}
+
+// SetDisconnectFunc sets the callback function called when the client loses the connection (call it before Start)
+func (c *StreamClient) SetDisconnectFunc(f DisconnectFunc) {
+	c.onDisconnect = f
 }
 
 // SetProcessEntryFunc sets the callback function to process entry
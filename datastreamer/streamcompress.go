@@ -0,0 +1,499 @@
+package datastreamer
+
+import (
+	"encoding/binary"
+	"io"
+	"sync/atomic"
+
+	"github.com/0xPolygonHermez/zkevm-data-streamer/log"
+	"github.com/golang/snappy"
+)
+
+const (
+	fileFlagsPos          = magicNumSize + headerSize // Position of the file flags byte in the header page
+	FlagCompressedPages   = 1 << 0                    // FlagCompressedPages is the file flag for files with compressed data pages
+	fixedSizeCompressPage = 17                        // Fixed size in bytes of a compressed data page header (1+4+4+8)
+	fixedSizeDataBatch    = 9                         // Fixed size in bytes of a compressed batch of data entries header (1+4+4)
+)
+
+// CompressionStats type for the counters of the data entries bytes sent compressed over the connections
+type CompressionStats struct {
+	RawBytes        uint64 // Data entries bytes before compression
+	CompressedBytes uint64 // Compressed batches bytes (headers included)
+}
+
+// pageReader type for an iterator reader of a file with compressed data pages. It reads the logical
+// (uncompressed) content of the file, so a compressed data page is read as its data entries followed by pad
+type pageReader struct {
+	f    *StreamFile
+	file iteratorReader // Reader of the physical file
+	pos  int64
+
+	page       int64  // Start position of the decompressed data page
+	data       []byte // Data entries of the decompressed data page
+	generation uint64 // Compressed pages generation of the decompressed data page
+
+	checked    int64 // Start position of the data page checked for compression (read-only file)
+	compressed bool  // Flag data page checked is compressed
+}
+
+// readFileFlags reads the file flags from the header page and locates the compressed data pages
+func (f *StreamFile) readFileFlags() error {
+	buffer := make([]byte, 1)
+	_, err := f.fileHeader.ReadAt(buffer, fileFlagsPos)
+	if err != nil {
+		log.Errorf("Error reading file flags: %v", err)
+		return err
+	}
+	f.flags = buffer[0]
+
+	if f.flags&FlagCompressedPages == 0 {
+		return nil
+	}
+
+	// Locate the compressed data pages
+	header := make([]byte, 1)
+	for pageStart := int64(PageHeaderSize); pageStart+PageDataSize < int64(f.header.TotalLength); pageStart = pageStart + PageDataSize {
+		_, err = f.file.ReadAt(header, pageStart)
+		if err != nil {
+			log.Errorf("Error reading data page type: %v", err)
+			return err
+		}
+		if header[0] == PtCompressed {
+			f.compressedPages[pageStart] = struct{}{}
+		}
+	}
+	log.Infof("Compressed data pages: %d", len(f.compressedPages))
+
+	return nil
+}
+
+// enableCompression sets the file flag for compressed data pages and compresses the data pages sealed from now on
+func (f *StreamFile) enableCompression() error {
+	if f.flags&FlagCompressedPages == 0 {
+		_, err := f.fileHeader.WriteAt([]byte{f.flags | FlagCompressedPages}, fileFlagsPos)
+		if err != nil {
+			log.Errorf("Error writing file flags: %v", err)
+			return err
+		}
+		err = f.fileHeader.Sync()
+		if err != nil {
+			log.Errorf("Error flushing file flags: %v", err)
+			return err
+		}
+		f.flags = f.flags | FlagCompressedPages
+	}
+
+	f.compressPages = true
+	f.nextCompressPage = ((int64(f.writtenHead.TotalLength)-PageHeaderSize)/PageDataSize)*PageDataSize + PageHeaderSize
+
+	// Background compactor of the sealed data pages
+	f.compressRequests = make(chan struct{}, 1)
+	go f.runCompactor()
+
+	log.Infof("Data pages compression enabled from data page position %d", f.nextCompressPage)
+	return nil
+}
+
+// requestCompression wakes up the background compactor to compress the data pages sealed by the committed entries
+func (f *StreamFile) requestCompression() {
+	if !f.compressPages {
+		return
+	}
+
+	select {
+	case f.compressRequests <- struct{}{}:
+	default:
+	}
+}
+
+// runCompactor compresses the sealed data pages on request, out of the commit path
+func (f *StreamFile) runCompactor() {
+	for range f.compressRequests {
+		f.mutexCompress.Lock()
+		err := f.compressSealedPages()
+		f.mutexCompress.Unlock()
+		if err != nil {
+			log.Errorf("Error compressing sealed data pages: %v", err)
+		}
+	}
+}
+
+// compressSealedPages compresses the data pages sealed by the committed entries. Must be called with
+// mutexCompress locked
+func (f *StreamFile) compressSealedPages() error {
+	for f.nextCompressPage+PageDataSize < int64(f.getHeaderEntry().TotalLength) {
+		err := f.compressPage(f.nextCompressPage)
+		if err != nil {
+			return err
+		}
+		f.nextCompressPage = f.nextCompressPage + PageDataSize
+	}
+	return nil
+}
+
+// compressPage replaces a sealed data page by its compressed data entries, journaled so a crash while
+// writing the page is undone on the next start
+func (f *StreamFile) compressPage(pageStart int64) error {
+	// Read the data page
+	page := make([]byte, PageDataSize)
+	_, err := f.file.ReadAt(page, pageStart)
+	if err != nil {
+		log.Errorf("Error reading data page to compress: %v", err)
+		return err
+	}
+
+	// Data entries length (pad excluded)
+	if page[0] != PtData {
+		log.Errorf("Error data page not starting with packet of type data(%d). Type: %d", PtData, page[0])
+		return ErrPageNotStartingWithEntryData
+	}
+	dataLength := 0
+	for dataLength+FixedSizeFileEntry <= len(page) && page[dataLength] == PtData {
+		length := int(binary.BigEndian.Uint32(page[dataLength+1 : dataLength+5]))
+		if length < FixedSizeFileEntry || dataLength+length > len(page) {
+			log.Errorf("Error decoding length data entry to compress")
+			return ErrDecodingLengthDataEntry
+		}
+		dataLength = dataLength + length
+	}
+
+	// Compress the data entries, keep the page as it is if it doesn't save space
+	compressed := snappy.Encode(nil, page[:dataLength])
+	if fixedSizeCompressPage+len(compressed) >= dataLength {
+		log.Debugf("Data page %d not compressed. Length:%d Compressed:%d", pageStart, dataLength, len(compressed))
+		return nil
+	}
+
+	// Compressed data page: packet type, compressed length, data entries length, first entry number
+	be := make([]byte, 0, fixedSizeCompressPage+len(compressed))
+	be = append(be, PtCompressed)
+	be = binary.BigEndian.AppendUint32(be, uint32(len(compressed)))
+	be = binary.BigEndian.AppendUint32(be, uint32(dataLength))
+	be = append(be, page[9:17]...)
+	be = append(be, compressed...)
+
+	// Write it excluding the readers, crash-safe (the data entries don't change for the readers in-process)
+	err = f.beginRewrite()
+	if err != nil {
+		return err
+	}
+	f.mutexPages.Lock()
+	err = f.rewrite([]pageWrite{{pos: pageStart, data: be}})
+	if err == nil {
+		f.compressedPages[pageStart] = struct{}{}
+	}
+	f.mutexPages.Unlock()
+	f.endRewrite(false)
+	if err != nil {
+		return err
+	}
+
+	// Release the disk space not used by the compressed page
+	used := (int64(len(be)) + PageHeaderSize - 1) / PageHeaderSize * PageHeaderSize
+	if used < PageDataSize {
+		err = punchHole(f.file, pageStart+used, PageDataSize-used)
+		if err != nil {
+			log.Warnf("Error releasing disk space of compressed data page: %v", err)
+		}
+	}
+
+	log.Debugf("Data page %d compressed. Length:%d Compressed:%d", pageStart, dataLength, len(compressed))
+	return nil
+}
+
+// decompressPage replaces a compressed data page by its data entries, journaled so a crash while
+// writing the page is undone on the next start
+func (f *StreamFile) decompressPage(pageStart int64) error {
+	if !f.isCompressedPage(pageStart) {
+		return nil
+	}
+
+	data, err := readCompressedPage(f.file, pageStart)
+	if err != nil {
+		return err
+	}
+
+	// Write the data entries followed by the pad excluding the readers, crash-safe
+	if len(data) < PageDataSize {
+		data = append(data, PtPadding)
+	}
+	err = f.beginRewrite()
+	if err != nil {
+		return err
+	}
+	f.mutexPages.Lock()
+	err = f.rewrite([]pageWrite{{pos: pageStart, data: data}})
+	if err == nil {
+		delete(f.compressedPages, pageStart)
+		f.pagesGeneration++
+	}
+	f.mutexPages.Unlock()
+	f.endRewrite(false)
+	if err != nil {
+		return err
+	}
+
+	log.Debugf("Data page %d decompressed", pageStart)
+	return nil
+}
+
+// forgetCompressedPages decompresses the data page containing a position and forgets the compressed data pages after it
+func (f *StreamFile) forgetCompressedPages(pos int64) error {
+	pageStart := ((pos-PageHeaderSize)/PageDataSize)*PageDataSize + PageHeaderSize
+	err := f.decompressPage(pageStart)
+	if err != nil {
+		return err
+	}
+
+	f.mutexPages.Lock()
+	for page := range f.compressedPages {
+		if page >= pageStart {
+			delete(f.compressedPages, page)
+		}
+	}
+	f.pagesGeneration++
+	f.mutexPages.Unlock()
+
+	// Compress again from the data page when sealed
+	if f.nextCompressPage > pageStart {
+		f.nextCompressPage = pageStart
+	}
+	return nil
+}
+
+// isCompressedPage returns if the data page containing a file position is compressed
+func (f *StreamFile) isCompressedPage(pos int64) bool {
+	pageStart := ((pos-PageHeaderSize)/PageDataSize)*PageDataSize + PageHeaderSize
+
+	f.mutexPages.RLock()
+	defer f.mutexPages.RUnlock()
+
+	_, ok := f.compressedPages[pageStart]
+	return ok
+}
+
+// readCompressedPage reads and decompresses the data entries of a compressed data page
+func readCompressedPage(file io.ReaderAt, pageStart int64) ([]byte, error) {
+	header := make([]byte, fixedSizeCompressPage)
+	_, err := file.ReadAt(header, pageStart)
+	if err != nil {
+		log.Errorf("Error reading compressed data page: %v", err)
+		return nil, err
+	}
+	if header[0] != PtCompressed {
+		log.Errorf("Error expecting packet of type compressed(%d). Read: %d", PtCompressed, header[0])
+		return nil, ErrDecompressingDataPage
+	}
+	compressedLength := binary.BigEndian.Uint32(header[1:5])
+	dataLength := binary.BigEndian.Uint32(header[5:9])
+	if compressedLength > PageDataSize-fixedSizeCompressPage || dataLength > PageDataSize {
+		log.Errorf("Error decoding compressed data page lengths")
+		return nil, ErrDecompressingDataPage
+	}
+
+	compressed := make([]byte, compressedLength)
+	_, err = file.ReadAt(compressed, pageStart+fixedSizeCompressPage)
+	if err != nil {
+		log.Errorf("Error reading compressed data page: %v", err)
+		return nil, err
+	}
+
+	data, err := snappy.Decode(nil, compressed)
+	if err != nil || uint32(len(data)) != dataLength {
+		log.Errorf("Error decompressing data page: %v", err)
+		return nil, ErrDecompressingDataPage
+	}
+	return data, nil
+}
+
+// newPageReader creates a reader of the logical content of a file with compressed data pages
+func (f *StreamFile) newPageReader(file iteratorReader) *pageReader {
+	return &pageReader{
+		f:       f,
+		file:    file,
+		page:    -1,
+		checked: -1,
+	}
+}
+
+// reset discards the decompressed data page and the data page checked for compression, both maybe rewritten
+func (r *pageReader) reset() {
+	r.page = -1
+	r.data = nil
+	r.checked = -1
+}
+
+// Read reads from the current position of the reader
+func (r *pageReader) Read(p []byte) (int, error) {
+	n, err := r.ReadAt(p, r.pos)
+	r.pos = r.pos + int64(n)
+	return n, err
+}
+
+// ReadAt reads the logical content from a position of the file
+func (r *pageReader) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	for n < len(p) {
+		pos := off + int64(n)
+
+		// Read up to the end of the header page or the data page
+		var end int64
+		if pos < PageHeaderSize {
+			end = PageHeaderSize
+		} else {
+			end = ((pos-PageHeaderSize)/PageDataSize+1)*PageDataSize + PageHeaderSize
+		}
+		if end > off+int64(len(p)) {
+			end = off + int64(len(p))
+		}
+
+		m, err := r.readPage(p[n:n+int(end-pos)], pos)
+		n = n + m
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// readPage reads the logical content of a page from a position
+func (r *pageReader) readPage(p []byte, pos int64) (int, error) {
+	r.f.mutexPages.RLock()
+	defer r.f.mutexPages.RUnlock()
+
+	pageStart := ((pos-PageHeaderSize)/PageDataSize)*PageDataSize + PageHeaderSize
+	if pos < PageHeaderSize || !r.isCompressed(pageStart) {
+		return r.file.ReadAt(p, pos)
+	}
+
+	// Decompress the data page if it's not the last one decompressed
+	if r.page != pageStart || r.generation != r.f.pagesGeneration {
+		data, err := readCompressedPage(r.file, pageStart)
+		if err != nil {
+			return 0, err
+		}
+		r.page = pageStart
+		r.data = data
+		r.generation = r.f.pagesGeneration
+	}
+
+	// Data entries followed by pad
+	n := 0
+	if rel := pos - pageStart; rel < int64(len(r.data)) {
+		n = copy(p, r.data[rel:])
+	}
+	for i := n; i < len(p); i++ {
+		p[i] = PtPadding
+	}
+	return len(p), nil
+}
+
+// isCompressed returns if a data page is compressed. Read from the page for a read-only file, its
+// compressed data pages are not known
+func (r *pageReader) isCompressed(pageStart int64) bool {
+	if !r.f.readOnly {
+		_, ok := r.f.compressedPages[pageStart]
+		return ok
+	}
+
+	if r.checked != pageStart {
+		packet := make([]byte, 1)
+		_, err := r.file.ReadAt(packet, pageStart)
+		r.checked = pageStart
+		r.compressed = err == nil && packet[0] == PtCompressed
+	}
+	return r.compressed
+}
+
+// Seek sets the current position of the reader
+func (r *pageReader) Seek(offset int64, whence int) (int64, error) {
+	var pos int64
+	switch whence {
+	case io.SeekStart:
+		pos = offset
+	case io.SeekCurrent:
+		pos = r.pos + offset
+	case io.SeekEnd:
+		end, err := r.file.Seek(0, io.SeekEnd)
+		if err != nil {
+			return r.pos, err
+		}
+		pos = end + offset
+	}
+	if pos < 0 {
+		return r.pos, ErrInvalidSeekPosition
+	}
+	r.pos = pos
+	return pos, nil
+}
+
+// Close closes the reader of the physical file
+func (r *pageReader) Close() error {
+	return r.file.Close()
+}
+
+// Ratio returns the compression ratio (raw bytes per compressed byte, 0 if nothing compressed)
+func (c CompressionStats) Ratio() float64 {
+	if c.CompressedBytes == 0 {
+		return 0
+	}
+	return float64(c.RawBytes) / float64(c.CompressedBytes)
+}
+
+// add adds the bytes of a compressed batch to the counters
+func (c *CompressionStats) add(raw int, compressed int) {
+	atomic.AddUint64(&c.RawBytes, uint64(raw))
+	atomic.AddUint64(&c.CompressedBytes, uint64(compressed))
+}
+
+// load returns a copy of the counters
+func (c *CompressionStats) load() CompressionStats {
+	return CompressionStats{
+		RawBytes:        atomic.LoadUint64(&c.RawBytes),
+		CompressedBytes: atomic.LoadUint64(&c.CompressedBytes),
+	}
+}
+
+// encodeDataBatch compresses encoded data entries into a batch packet
+func encodeDataBatch(data []byte, stats *CompressionStats) []byte {
+	compressed := snappy.Encode(nil, data)
+
+	be := make([]byte, 0, fixedSizeDataBatch+len(compressed))
+	be = append(be, PtDataBatch)
+	be = binary.BigEndian.AppendUint32(be, uint32(len(compressed)))
+	be = binary.BigEndian.AppendUint32(be, uint32(len(data)))
+	be = append(be, compressed...)
+
+	stats.add(len(data), len(be))
+	return be
+}
+
+// decodeDataBatch decompresses a batch of data entries (compressed data after the header fields)
+func decodeDataBatch(compressed []byte, dataLength uint32) ([]FileEntry, error) {
+	data, err := snappy.Decode(nil, compressed)
+	if err != nil || uint32(len(data)) != dataLength {
+		log.Errorf("Error decompressing data batch: %v", err)
+		return nil, ErrReadingDataBatch
+	}
+
+	entries := []FileEntry{}
+	for len(data) > 0 {
+		if len(data) < FixedSizeFileEntry || data[0] != PtData {
+			log.Errorf("Error decoding data entry in data batch")
+			return nil, ErrReadingDataBatch
+		}
+		length := binary.BigEndian.Uint32(data[1:5])
+		if length < FixedSizeFileEntry || uint64(length) > uint64(len(data)) {
+			log.Errorf("Error decoding length data entry in data batch")
+			return nil, ErrReadingDataBatch
+		}
+		entry, err := DecodeBinaryToFileEntry(data[:length])
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+		data = data[length:]
+	}
+	return entries, nil
+}
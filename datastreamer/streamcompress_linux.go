@@ -0,0 +1,16 @@
+package datastreamer
+
+import (
+	"os"
+	"syscall"
+)
+
+const (
+	fallocKeepSize  = 0x01 // FALLOC_FL_KEEP_SIZE
+	fallocPunchHole = 0x02 // FALLOC_FL_PUNCH_HOLE
+)
+
+// punchHole releases the disk space of a range of the file keeping its size
+func punchHole(file *os.File, offset int64, length int64) error {
+	return syscall.Fallocate(int(file.Fd()), fallocKeepSize|fallocPunchHole, offset, length)
+}
@@ -24,11 +24,16 @@ const (
 	initPages      = 100         // Initial number of data pages
 	nextPages      = 10          // Number of data pages to add when file is full
 
-	PtPadding = 0    // PtPadding is packet type for pad
-	PtHeader  = 1    // PtHeader is packet type just for the header page
-	PtData    = 2    // PtData is packet type for data entry
-	PtDataRsp = 0xfe // PtDataRsp is packet type for command response with data
-	PtResult  = 0xff // PtResult is packet type not stored/present in file (just for client command result)
+	PtPadding    = 0    // PtPadding is packet type for pad
+	PtHeader     = 1    // PtHeader is packet type just for the header page
+	PtData       = 2    // PtData is packet type for data entry
+	PtCompressed = 3    // PtCompressed is packet type for a compressed data page (at the start of the page)
+	PtTimestamp  = 0xfa // PtTimestamp is packet type for the commit time of the next streamed entries (not stored in file)
+	PtPong       = 0xfb // PtPong is packet type for the heartbeat response to the Ping command (not stored in file)
+	PtPing       = 0xfc // PtPing is packet type for the heartbeat sent by the server (not stored in file)
+	PtDataBatch  = 0xfd // PtDataBatch is packet type for a compressed batch of data entries (not stored in file)
+	PtDataRsp    = 0xfe // PtDataRsp is packet type for command response with data
+	PtResult     = 0xff // PtResult is packet type not stored/present in file (just for client command result)
 
 	EtBookmark = 0xb0 // EtBookmark is entry type for bookmarks
 
@@ -52,6 +57,7 @@ type FileEntry struct {
 	Type       EntryType // 0xb0:Bookmark, 1:Event1, 2:Event2,...
 	Number     uint64    // Entry number (sequential starting with 0)
 	Data       []byte
+	Timestamp  uint64 // Commit time in Unix milliseconds of a streamed entry (0 if not requested or unknown)
 }
 
 // StreamFile type to manage a binary stream file
@@ -62,16 +68,49 @@ type StreamFile struct {
 	streamType StreamType
 	maxLength  uint64 // File size in bytes
 
-	fileHeader  *os.File    // File descriptor just for read/write the header
-	header      HeaderEntry // Current header in memory (atomic operation in progress)
-	writtenHead HeaderEntry // Current header written in the file
-	mutexHeader sync.Mutex  // Mutex for update header data
+	fileHeader    *os.File    // File descriptor just for read/write the header
+	header        HeaderEntry // Current header in memory (atomic operation in progress)
+	committedHead HeaderEntry // Header of the last commit (written, or pending to be written by a group commit)
+	writtenHead   HeaderEntry // Current header written in the file
+	mutexHeader   sync.Mutex  // Mutex for update header data
+
+	pageDataEnds  map[int64]int64 // Memoized end position of the data entries (pad excluded) of sealed data pages
+	mutexPageEnds sync.Mutex      // Mutex for the memoized data ends
+
+	mmapReads    bool         // Flag read-only iterators use the shared memory mapping of the file
+	readOnly     bool         // Flag file opened just to read it, maybe while another process writes it
+	mapping      *fileMapping // Current memory mapping of the file
+	mutexMapping sync.Mutex   // Mutex for the memory mapping references
+
+	flags            uint8              // File flags (stored in the header page)
+	compressPages    bool               // Flag compress the data pages when sealed
+	nextCompressPage int64              // Start position of the next data page to compress
+	compressedPages  map[int64]struct{} // Start positions of the compressed data pages
+	pagesGeneration  uint64             // Incremented when a compressed data page is decompressed
+	mutexPages       sync.RWMutex       // Mutex for the compressed data pages rewrites
+	compressRequests chan struct{}      // Requests to the background compactor of the sealed data pages
+	mutexCompress    sync.Mutex         // Mutex for the data pages compression (compactor, updates and truncates)
+
+	journal      *os.File     // Journal of the original content of the data pages being rewritten in place
+	syncRewrites bool         // Flag flush to disk the journal and the data pages rewritten in place
+	rewriteSeq   uint64       // Data pages rewrite sequence (odd while a rewrite is in progress)
+	changedSeq   uint64       // Data pages rewrite sequence after the last rewrite changing the logical content
+	mutexRewrite sync.RWMutex // Mutex for the in-place rewrites of data pages (exclusive) and the entries reads (shared)
 }
 
 type iteratorFile struct {
-	fromEntry uint64
-	file      *os.File
-	Entry     FileEntry
+	fromEntry  uint64 // Entry number to locate, then the next entry number to read
+	file       iteratorReader
+	Entry      FileEntry
+	rewriteSeq uint64 // Data pages rewrite sequence when the entry was located
+}
+
+// iteratorReader is the reader of the stream file used by an iterator (own file descriptor or shared memory mapping)
+type iteratorReader interface {
+	io.Reader
+	io.Seeker
+	io.ReaderAt
+	io.Closer
 }
 
 // NewStreamFile creates stream file struct and opens or creates the stream binary data file
@@ -91,6 +130,10 @@ func NewStreamFile(fn string, st StreamType) (*StreamFile, error) {
 			TotalLength:  0,
 			TotalEntries: 0,
 		},
+
+		pageDataEnds:    map[int64]int64{},
+		compressedPages: map[int64]struct{}{},
+		syncRewrites:    true,
 	}
 
 	// Open (or create) the data stream file
@@ -105,6 +148,57 @@ func NewStreamFile(fn string, st StreamType) (*StreamFile, error) {
 	return &sf, err
 }
 
+// openStreamFileReadOnly opens an existing stream file just to read it, concurrently with its writer
+func openStreamFileReadOnly(fn string, st StreamType) (*StreamFile, error) {
+	sf := StreamFile{
+		fileName:   fn,
+		pageSize:   PageDataSize,
+		streamType: st,
+		readOnly:   true,
+
+		pageDataEnds:    map[int64]int64{},
+		compressedPages: map[int64]struct{}{},
+	}
+
+	// Open the data stream file
+	var err error
+	sf.file, err = os.Open(fn)
+	if err != nil {
+		log.Errorf("Error opening datastream file %s: %v", fn, err)
+		return nil, err
+	}
+	sf.fileHeader, err = os.Open(fn)
+	if err != nil {
+		log.Errorf("Error opening file for read header: %v", err)
+		sf.file.Close()
+		return nil, err
+	}
+
+	// Check magic numbers and the header
+	err = sf.checkMagicNumbers()
+	if err == nil {
+		err = sf.readHeaderEntry()
+	}
+	if err == nil {
+		err = sf.checkHeaderConsistency()
+	}
+	if err != nil {
+		sf.closeFile()
+		return nil, err
+	}
+
+	return &sf, nil
+}
+
+// closeFile closes the file descriptors of the stream file
+func (f *StreamFile) closeFile() {
+	f.file.Close()
+	f.fileHeader.Close()
+	if f.journal != nil {
+		f.journal.Close()
+	}
+}
+
 // openCreateFile opens or creates the stream file and performs multiple checks
 func (f *StreamFile) openCreateFile() error {
 	// Check if file exists (otherwise create it)
@@ -122,6 +216,10 @@ func (f *StreamFile) openCreateFile() error {
 			if err != nil {
 				return err
 			}
+			err = f.openPageJournal(true)
+			if err != nil {
+				return err
+			}
 			err = f.initializeFile()
 		}
 	} else if err == nil {
@@ -134,6 +232,12 @@ func (f *StreamFile) openCreateFile() error {
 		}
 
 		err = f.openFileForHeader()
+		if err != nil {
+			return err
+		}
+
+		// Undo a data pages rewrite interrupted by a crash
+		err = f.openPageJournal(false)
 	} else {
 		log.Errorf("Unable to check datastream file status %s: %v", f.fileName, err)
 	}
@@ -171,6 +275,18 @@ func (f *StreamFile) openCreateFile() error {
 		return err
 	}
 
+	// Read the file flags
+	err = f.readFileFlags()
+	if err != nil {
+		return err
+	}
+
+	// Read the data pages rewrite sequence
+	err = f.readRewriteSeq()
+	if err != nil {
+		return err
+	}
+
 	// Set initial file position to write
 	_, err = f.file.Seek(int64(f.header.TotalLength), io.SeekStart)
 	if err != nil {
@@ -300,6 +416,14 @@ func (f *StreamFile) extendFile() error {
 			return err
 		}
 	}
+
+	// Map again the file with the new size
+	if f.mmapReads {
+		err = f.remapFile()
+		if err != nil {
+			return err
+		}
+	}
 	return err
 }
 
@@ -327,6 +451,7 @@ func (f *StreamFile) readHeaderEntry() error {
 	// Convert to header struct
 	f.mutexHeader.Lock()
 	f.header, err = decodeBinaryToHeaderEntry(binaryHeader)
+	f.committedHead = f.header
 	f.writtenHead = f.header
 	f.mutexHeader.Unlock()
 	if err != nil {
@@ -339,14 +464,13 @@ func (f *StreamFile) readHeaderEntry() error {
 
 // rollbackHeader cancels current file written entries not committed
 func (f *StreamFile) rollbackHeader() error {
-	// Restore header
-	err := f.readHeaderEntry()
-	if err != nil {
-		return err
-	}
+	// Restore header of the last commit (maybe not written yet)
+	f.mutexHeader.Lock()
+	f.header = f.committedHead
+	f.mutexHeader.Unlock()
 
 	// Set file position to write
-	_, err = f.file.Seek(int64(f.header.TotalLength), io.SeekStart)
+	_, err := f.file.Seek(int64(f.header.TotalLength), io.SeekStart)
 	if err != nil {
 		log.Errorf("Error seeking new position to write: %v", err)
 		return err
@@ -355,8 +479,20 @@ func (f *StreamFile) rollbackHeader() error {
 	return nil
 }
 
+// syncFile flushes the stream file (data and header) to disk
+func (f *StreamFile) syncFile() error {
+	err := f.file.Sync()
+	if err != nil {
+		log.Errorf("Error flushing stream file to disk: %v", err)
+		return err
+	}
+	return nil
+}
+
 // getHeaderEntry returns current committed header
 func (f *StreamFile) getHeaderEntry() HeaderEntry {
+	f.mutexHeader.Lock()
+	defer f.mutexHeader.Unlock()
 	return f.writtenHead
 }
 
@@ -374,8 +510,34 @@ func PrintHeaderEntry(e HeaderEntry, title string) {
 	log.Infof("DataPage num=[%d] off=[%d]", numPage, offPage)
 }
 
+// commitHeader sets the memory header as the last commit, to be written later by writeHeader
+func (f *StreamFile) commitHeader() HeaderEntry {
+	f.mutexHeader.Lock()
+	defer f.mutexHeader.Unlock()
+	f.committedHead = f.header
+	return f.header
+}
+
 // writeHeaderEntry writes the memory header struct into the file header
 func (f *StreamFile) writeHeaderEntry() error {
+	header := f.commitHeader()
+	err := f.writeHeader(header)
+	if err != nil {
+		return err
+	}
+	f.setWrittenHeader(header)
+	return nil
+}
+
+// setWrittenHeader sets the header written in the file, making its entries visible to the readers
+func (f *StreamFile) setWrittenHeader(header HeaderEntry) {
+	f.mutexHeader.Lock()
+	f.writtenHead = header
+	f.mutexHeader.Unlock()
+}
+
+// writeHeader writes a header of a commit into the file header, the readers don't see it until setWrittenHeader
+func (f *StreamFile) writeHeader(header HeaderEntry) error {
 	// Position at the beginning of the file
 	_, err := f.fileHeader.Seek(magicNumSize, io.SeekStart)
 	if err != nil {
@@ -384,18 +546,13 @@ func (f *StreamFile) writeHeaderEntry() error {
 	}
 
 	// Write after convert header struct to binary stream
-	binaryHeader := encodeHeaderEntryToBinary(f.header)
+	binaryHeader := encodeHeaderEntryToBinary(header)
 	log.Debugf("writing header entry: %v", binaryHeader)
 	_, err = f.fileHeader.Write(binaryHeader)
 	if err != nil {
 		log.Errorf("Error writing the header: %v", err)
 		return err
 	}
-
-	// Update the written header
-	f.mutexHeader.Lock()
-	f.writtenHead = f.header
-	f.mutexHeader.Unlock()
 	return nil
 }
 
@@ -430,8 +587,12 @@ func decodeBinaryToHeaderEntry(b []byte) (HeaderEntry, error) {
 
 // encodeFileEntryToBinary encodes from a data file entry type to binary bytes
 func encodeFileEntryToBinary(e FileEntry) []byte {
-	be := make([]byte, 1)
-	be[0] = e.packetType
+	return appendFileEntryToBinary(make([]byte, 0, FixedSizeFileEntry+len(e.Data)), e)
+}
+
+// appendFileEntryToBinary appends the encoded data entry to the bytes stream
+func appendFileEntryToBinary(be []byte, e FileEntry) []byte {
+	be = append(be, e.packetType)
 	be = binary.BigEndian.AppendUint32(be, e.Length)
 	be = binary.BigEndian.AppendUint32(be, uint32(e.Type))
 	be = binary.BigEndian.AppendUint64(be, e.Number)
@@ -639,7 +800,7 @@ func DecodeBinaryToFileEntry(b []byte) (FileEntry, error) {
 // iteratorFrom initializes iterator to locate a data entry number in the stream file
 func (f *StreamFile) iteratorFrom(entryNum uint64, readOnly bool) (*iteratorFile, error) {
 	// Check starting entry number
-	if entryNum >= f.writtenHead.TotalEntries {
+	if entryNum >= f.getHeaderEntry().TotalEntries {
 		log.Infof("Invalid starting entry number for iterator")
 		return nil, ErrInvalidEntryNumber
 	}
@@ -652,11 +813,22 @@ func (f *StreamFile) iteratorFrom(entryNum uint64, readOnly bool) (*iteratorFile
 		flag = os.O_RDWR
 	}
 
-	// Open file for read only
-	file, err := os.OpenFile(f.fileName, flag, os.ModePerm)
-	if err != nil {
-		log.Errorf("Error opening file for iterator: %v", err)
-		return nil, err
+	// Use the shared memory mapping for read only, otherwise open the file
+	var file iteratorReader
+	if readOnly && f.mmapReads {
+		file = f.newMmapReader()
+	} else {
+		var err error
+		file, err = os.OpenFile(f.fileName, flag, os.ModePerm)
+		if err != nil {
+			log.Errorf("Error opening file for iterator: %v", err)
+			return nil, err
+		}
+	}
+
+	// Read the logical content if the file has (or can have, written by another process) compressed data pages
+	if f.flags&FlagCompressedPages != 0 || f.readOnly {
+		file = f.newPageReader(file)
 	}
 
 	// Create iterator struct
@@ -668,16 +840,96 @@ func (f *StreamFile) iteratorFrom(entryNum uint64, readOnly bool) (*iteratorFile
 		},
 	}
 
-	// Locate the file start stream point using custom dichotomic search
+	// Locate the file start stream point using custom dichotomic search, excluding the data pages rewrites
+	f.mutexRewrite.RLock()
+	defer f.mutexRewrite.RUnlock()
+	var err error
+	iterator.rewriteSeq, err = f.currentRewriteSeq()
+	if err != nil {
+		return &iterator, err
+	}
+	if iterator.rewriteSeq%2 != 0 {
+		// Rewrite in progress by another process
+		return &iterator, ErrDataPageRewritten
+	}
 	err = f.seekEntry(&iterator)
+	if err != nil {
+		return &iterator, err
+	}
 
-	return &iterator, err
+	// Check the data pages were not rewritten by another process while locating the entry
+	if f.readOnly {
+		seq, err := f.currentRewriteSeq()
+		if err != nil {
+			return &iterator, err
+		}
+		if seq != iterator.rewriteSeq {
+			return &iterator, ErrDataPageRewritten
+		}
+	}
+	return &iterator, nil
+}
+
+// resetReader discards the state cached by the reader of the iterator, the data pages were rewritten
+func (iterator *iteratorFile) resetReader() {
+	if r, ok := iterator.file.(*pageReader); ok {
+		r.reset()
+	}
 }
 
-// iteratorNext gets the next data entry in the file for the iterator, returns the end of entries condition
+// iteratorNext gets the next data entry in the file for the iterator, returns the end of entries condition.
+// The entry is located again if the data pages were rewritten in place (entries moved) since it was located
 func (f *StreamFile) iteratorNext(iterator *iteratorFile) (bool, error) {
 	// Check end of entries condition
-	if iterator.Entry.Number >= f.writtenHead.TotalEntries {
+	if iterator.fromEntry >= f.getHeaderEntry().TotalEntries {
+		return true, nil
+	}
+
+	// Exclude the data pages rewrites while reading the entry
+	f.mutexRewrite.RLock()
+	defer f.mutexRewrite.RUnlock()
+
+	seq, err := f.currentRewriteSeq()
+	if err != nil {
+		return true, err
+	}
+	if seq%2 != 0 {
+		// Rewrite in progress by another process
+		return true, ErrDataPageRewritten
+	}
+	if seq != iterator.rewriteSeq {
+		iterator.resetReader()
+		err = f.seekEntry(iterator)
+		if err != nil {
+			return true, err
+		}
+		iterator.rewriteSeq = seq
+	}
+
+	end, err := f.readNext(iterator)
+	if end || err != nil {
+		return end, err
+	}
+
+	// Check the data page was not rewritten by another process while reading the entry
+	if f.readOnly {
+		seq, err = f.currentRewriteSeq()
+		if err != nil {
+			return true, err
+		}
+		if seq != iterator.rewriteSeq {
+			return true, ErrDataPageRewritten
+		}
+	}
+
+	iterator.fromEntry = iterator.Entry.Number + 1
+	return false, nil
+}
+
+// readNext reads the next data entry from the current position of the iterator, returns the end of entries condition
+func (f *StreamFile) readNext(iterator *iteratorFile) (bool, error) {
+	// Check end of entries condition
+	if iterator.Entry.Number >= f.getHeaderEntry().TotalEntries {
 		return true, nil
 	}
 
@@ -707,7 +959,7 @@ func (f *StreamFile) iteratorNext(iterator *iteratorFile) (bool, error) {
 		}
 
 		// Check end of data pages condition
-		if pos+forward >= int64(f.writtenHead.TotalLength) {
+		if pos+forward >= int64(f.getHeaderEntry().TotalLength) {
 			return true, nil
 		}
 
@@ -778,10 +1030,11 @@ func (f *StreamFile) iteratorEnd(iterator *iteratorFile) {
 // seekEntry uses a file iterator to locate a data entry number using a custom binary search
 func (f *StreamFile) seekEntry(iterator *iteratorFile) error {
 	// Start and end data pages
+	header := f.getHeaderEntry()
 	avg := 0
 	beg := 0
-	end := int((f.writtenHead.TotalLength - PageHeaderSize) / PageDataSize)
-	if (f.writtenHead.TotalLength-PageHeaderSize)%PageDataSize == 0 {
+	end := int((header.TotalLength - PageHeaderSize) / PageDataSize)
+	if (header.TotalLength-PageHeaderSize)%PageDataSize == 0 {
 		end = end - 1
 	}
 
@@ -869,7 +1122,8 @@ func (f *StreamFile) getFirstEntryOnNextPage(iterator *iteratorFile) (uint64, er
 	}
 
 	// Check if it is valid the current file position
-	if curpos < PageHeaderSize || curpos > int64(f.writtenHead.TotalLength) {
+	totalLength := int64(f.getHeaderEntry().TotalLength)
+	if curpos < PageHeaderSize || curpos > totalLength {
 		log.Errorf("Error current file position outside a data page")
 		return 0, ErrCurrentPositionOutsideDataPage
 	}
@@ -882,7 +1136,7 @@ func (f *StreamFile) getFirstEntryOnNextPage(iterator *iteratorFile) (uint64, er
 		forward = PageDataSize - (curpos-PageHeaderSize)%PageDataSize
 	}
 
-	if curpos+forward >= int64(f.writtenHead.TotalLength) {
+	if curpos+forward >= totalLength {
 		return math.MaxUint64, nil
 	}
 
@@ -920,6 +1174,135 @@ func (f *StreamFile) getFirstEntryOnNextPage(iterator *iteratorFile) (uint64, er
 	return entryNum, nil
 }
 
+// dataRange returns the end position of the contiguous data entries bytes (pad excluded) starting at a data entry
+// position, and the position where the following data entries start (next data page)
+func (f *StreamFile) dataRange(file io.ReaderAt, pos int64, totalLength int64) (int64, int64, error) {
+	end := pos
+	for end < totalLength {
+		pageStart := ((end-PageHeaderSize)/PageDataSize)*PageDataSize + PageHeaderSize
+		pageEnd := pageStart + PageDataSize
+
+		// Last page with data, the committed data ends at the total length
+		if totalLength <= pageEnd {
+			return totalLength, totalLength, nil
+		}
+
+		// Sealed page, the contiguous data ends if the page has pad
+		dataEnd, err := f.pageDataEnd(file, pageStart)
+		if err != nil {
+			return 0, 0, err
+		}
+		if dataEnd < pageEnd {
+			return dataEnd, pageEnd, nil
+		}
+		end = pageEnd
+	}
+	return end, end, nil
+}
+
+// relocateEntry returns the position of a data entry, located again if the data pages were rewritten in place
+// since the iterator located it. The start of a data page doesn't move, and an entry not committed yet starts at
+// the end of the committed data. The rewrite mutex must be held
+func (f *StreamFile) relocateEntry(iterator *iteratorFile, pos int64, entryNum uint64, header HeaderEntry) (int64, error) {
+	if iterator.rewriteSeq == f.rewriteSeq {
+		return pos, nil
+	}
+	iterator.resetReader()
+
+	if entryNum >= header.TotalEntries {
+		pos = int64(header.TotalLength)
+	} else if (pos-PageHeaderSize)%PageDataSize != 0 {
+		iterator.fromEntry = entryNum
+		err := f.seekEntry(iterator)
+		if err != nil {
+			return 0, err
+		}
+		pos, err = iterator.file.Seek(0, io.SeekCurrent)
+		if err != nil {
+			log.Errorf("Error seeking current pos for relocated entry: %v", err)
+			return 0, err
+		}
+	}
+	iterator.rewriteSeq = f.rewriteSeq
+	return pos, nil
+}
+
+// copyRange copies a range of the stream file to a writer (a single write from the memory mapping,
+// sendfile from the file when the writer is a TCP connection, regular copy otherwise)
+func copyRange(w io.Writer, file iteratorReader, pos int64, end int64) error {
+	if r, ok := file.(*mmapReader); ok {
+		data, err := r.bytes(pos, end)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	}
+
+	_, err := file.Seek(pos, io.SeekStart)
+	if err != nil {
+		log.Errorf("Error seeking range to copy: %v", err)
+		return err
+	}
+	_, err = io.Copy(w, io.LimitReader(file, end-pos))
+	return err
+}
+
+// pageDataEnd returns the end position of the data entries (pad excluded) of a sealed data page
+func (f *StreamFile) pageDataEnd(file io.ReaderAt, pageStart int64) (int64, error) {
+	f.mutexPageEnds.Lock()
+	dataEnd, ok := f.pageDataEnds[pageStart]
+	f.mutexPageEnds.Unlock()
+	if ok {
+		return dataEnd, nil
+	}
+
+	// Walk the data entries of the page reading just their packet type and length
+	pageEnd := pageStart + PageDataSize
+	buffer := make([]byte, 5) // nolint:gomnd
+	dataEnd = pageStart
+	for pageEnd-dataEnd >= FixedSizeFileEntry {
+		_, err := file.ReadAt(buffer, dataEnd)
+		if err != nil {
+			log.Errorf("Error reading entry for page data end: %v", err)
+			return 0, err
+		}
+
+		if buffer[0] == PtPadding {
+			break
+		} else if buffer[0] != PtData {
+			log.Errorf("Error expecting packet of type data(%d). Read: %d", PtData, buffer[0])
+			return 0, ErrExpectingPacketTypeData
+		}
+
+		length := binary.BigEndian.Uint32(buffer[1:5])
+		if length < FixedSizeFileEntry || dataEnd+int64(length) > pageEnd {
+			log.Errorf("Error decoding length data entry")
+			return 0, ErrDecodingLengthDataEntry
+		}
+		dataEnd = dataEnd + int64(length)
+	}
+
+	f.mutexPageEnds.Lock()
+	f.pageDataEnds[pageStart] = dataEnd
+	f.mutexPageEnds.Unlock()
+
+	return dataEnd, nil
+}
+
+// forgetPageDataEnds removes the memoized data ends of the data pages from a position onwards
+func (f *StreamFile) forgetPageDataEnds(pos int64) {
+	pageStart := ((pos-PageHeaderSize)/PageDataSize)*PageDataSize + PageHeaderSize
+
+	f.mutexPageEnds.Lock()
+	for page := range f.pageDataEnds {
+		if page >= pageStart {
+			delete(f.pageDataEnds, page)
+		}
+	}
+	f.mutexPageEnds.Unlock()
+}
+
 // locateEntry locates the entry number we are looking for using the sequential iterator
 func (f *StreamFile) locateEntry(iterator *iteratorFile) error {
 	// Seek backward to the start of data entry
@@ -930,7 +1313,7 @@ func (f *StreamFile) locateEntry(iterator *iteratorFile) error {
 	}
 
 	for {
-		end, err := f.iteratorNext(iterator)
+		end, err := f.readNext(iterator)
 		if err != nil {
 			return err
 		}
@@ -957,7 +1340,11 @@ func (f *StreamFile) locateEntry(iterator *iteratorFile) error {
 }
 
 // updateEntryData updates the internal data of an entry in the file
-func (f *StreamFile) updateEntryData(entryNum uint64, etype EntryType, data []byte) error {
+func (f *StreamFile) updateEntryData(entryNum uint64, etype EntryType, data []byte, mode UpdateMode) error {
+	// Exclude the background compactor
+	f.mutexCompress.Lock()
+	defer f.mutexCompress.Unlock()
+
 	// Check the entry number
 	if entryNum >= f.writtenHead.TotalEntries {
 		log.Infof("Invalid entry number [%d], not committed in the file", entryNum)
@@ -965,10 +1352,11 @@ func (f *StreamFile) updateEntryData(entryNum uint64, etype EntryType, data []by
 	}
 
 	// Create iterator and locate the entry in the file
-	iterator, err := f.iteratorFrom(entryNum, false)
+	iterator, err := f.iteratorFrom(entryNum, true)
 	if err != nil {
 		return err
 	}
+	defer f.iteratorEnd(iterator)
 
 	// Get current entry data
 	_, err = f.iteratorNext(iterator)
@@ -984,46 +1372,190 @@ func (f *StreamFile) updateEntryData(entryNum uint64, etype EntryType, data []by
 
 	// Check entry type
 	if iterator.Entry.Type != etype {
-		log.Infof("Updating entry to a different entry type not allowed. Current[%d] Update[%d]", iterator.Entry.Type, etype)
-		return ErrUpdateEntryTypeNotAllowed
+		if mode&UmAllowTypeChange == 0 || iterator.Entry.Type == EtBookmark || etype == EtBookmark {
+			log.Infof("Updating entry to a different entry type not allowed. Current[%d] Update[%d]", iterator.Entry.Type, etype)
+			return ErrUpdateEntryTypeNotAllowed
+		}
 	}
 
 	// Check length of data
 	dataLength := iterator.Entry.Length - FixedSizeFileEntry
-	if dataLength != uint32(len(data)) {
+	if dataLength != uint32(len(data)) && mode&UmAllowResize == 0 {
 		log.Infof("Updating entry data to a different length not allowed. Current[%d] Update[%d]", dataLength, uint32(len(data)))
 		return ErrUpdateEntryDifferentSize
 	}
 
-	// Back to the start of the data in the file
-	_, err = iterator.file.Seek(-int64(dataLength), io.SeekCurrent)
+	// Position of the entry in the file
+	curpos, err := iterator.file.Seek(0, io.SeekCurrent)
 	if err != nil {
-		log.Errorf("Error file seeking for update entry data: %v", err)
+		log.Errorf("Error seeking current pos for update entry data: %v", err)
 		return err
 	}
+	pos := curpos - int64(iterator.Entry.Length)
 
-	// Write new data entry
-	_, err = iterator.file.Write(data)
+	// Decompress the data page of the entry (compressed again after the update)
+	compressed := f.isCompressedPage(pos)
+	if compressed {
+		err = f.decompressPage(pos - (pos-PageHeaderSize)%PageDataSize)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Updated entry
+	e := iterator.Entry
+	e.Type = etype
+	e.Length = FixedSizeFileEntry + uint32(len(data))
+	e.Data = data
+	be := encodeFileEntryToBinary(e)
+
+	if e.Length != iterator.Entry.Length {
+		// Different size, rewrite the entry and the following ones within its data page
+		err = f.rewritePageFrom(pos, iterator.Entry.Length, be)
+		if err != nil {
+			return err
+		}
+	} else {
+		// Same size, overwrite the entry in place excluding the readers (no journal, no other entry moves)
+		err = f.beginRewrite()
+		if err != nil {
+			return err
+		}
+		_, err = f.file.WriteAt(be, pos)
+		f.endRewrite(true)
+		if err != nil {
+			log.Errorf("Error writing updated entry: %v", err)
+			return err
+		}
+	}
+
+	// Compress again the data page
+	if compressed {
+		err = f.compressPage(pos - (pos-PageHeaderSize)%PageDataSize)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// rewritePageFrom replaces an entry by a different size one moving the following entries of its data page.
+// The readers locate again their entries after the rewrite
+func (f *StreamFile) rewritePageFrom(pos int64, oldLength uint32, be []byte) error {
+	// Data page limits
+	pageStart := ((pos-PageHeaderSize)/PageDataSize)*PageDataSize + PageHeaderSize
+	pageEnd := pageStart + PageDataSize
+
+	// Check if it's the last page with data (entries added after the update change the header)
+	tail := int64(f.writtenHead.TotalLength) <= pageEnd
+	if tail && f.header != f.writtenHead {
+		log.Infof("Updating entry data to a different length not allowed in the last data page with an atomic operation in progress")
+		return ErrUpdateResizeNotAllowed
+	}
+
+	// Read the data page from the end of the entry
+	restPos := pos + int64(oldLength)
+	var restEnd int64
+	if tail {
+		restEnd = int64(f.writtenHead.TotalLength)
+	} else {
+		restEnd = pageEnd
+	}
+	rest := make([]byte, restEnd-restPos)
+	_, err := f.file.ReadAt(rest, restPos)
 	if err != nil {
-		log.Errorf("Error writing updated entry data: %v", err)
+		log.Errorf("Error reading data page for update entry data: %v", err)
 		return err
 	}
 
-	// Flush data to disk
-	err = iterator.file.Sync()
+	// Keep just the data entries (discard the pad)
+	var dataLength int
+	for dataLength < len(rest) && rest[dataLength] == PtData {
+		if len(rest)-dataLength < FixedSizeFileEntry {
+			log.Errorf("Error decoding data entry for update entry data")
+			return ErrDecodingLengthDataEntry
+		}
+		length := int(binary.BigEndian.Uint32(rest[dataLength+1 : dataLength+5]))
+		if length < FixedSizeFileEntry || dataLength+length > len(rest) {
+			log.Errorf("Error decoding length data entry for update entry data")
+			return ErrDecodingLengthDataEntry
+		}
+		dataLength = dataLength + length
+	}
+	oldEnd := restPos + int64(dataLength)
+
+	// Check the page can hold the updated entry and the following ones
+	newPage := append(be, rest[:dataLength]...)
+	newEnd := pos + int64(len(newPage))
+	if newEnd > pageEnd {
+		log.Infof("Updating entry data to a different length not allowed, doesn't fit in its data page. Page end[%d] New end[%d]", pageEnd, newEnd)
+		return ErrUpdateEntryNotFitInPage
+	}
+
+	// Clear the released space (or set the pad entry if the page is sealed)
+	if newEnd < pageEnd {
+		clearEnd := oldEnd
+		if !tail && clearEnd < newEnd+1 {
+			clearEnd = newEnd + 1
+		}
+		if clearEnd > newEnd {
+			newPage = append(newPage, make([]byte, clearEnd-newEnd)...)
+		}
+	}
+
+	// New header if the data length of the last page changes
+	header := f.writtenHead
+	writes := []pageWrite{{pos: pos, data: newPage}}
+	if tail {
+		header.TotalLength = uint64(int64(header.TotalLength) + newEnd - oldEnd)
+		writes = append(writes, pageWrite{pos: magicNumSize, data: encodeHeaderEntryToBinary(header)})
+	}
+
+	// Write the page from the entry position (and the header) excluding the readers, crash-safe
+	err = f.beginRewrite()
+	if err != nil {
+		return err
+	}
+	err = f.rewrite(writes)
+	if err == nil {
+		if tail {
+			f.mutexHeader.Lock()
+			f.header = header
+			f.committedHead = header
+			f.writtenHead = header
+			f.mutexHeader.Unlock()
+		} else {
+			// The data end of the page changed
+			f.mutexPageEnds.Lock()
+			delete(f.pageDataEnds, pageStart)
+			f.mutexPageEnds.Unlock()
+		}
+	}
+	f.endRewrite(true)
 	if err != nil {
-		log.Errorf("Error flushing updated entry data to disk: %v", err)
 		return err
 	}
 
-	// Close iterator
-	f.iteratorEnd(iterator)
+	// Set new file position to write
+	if tail {
+		_, err = f.file.Seek(int64(header.TotalLength), io.SeekStart)
+		if err != nil {
+			log.Errorf("Error seeking new position to write: %v", err)
+			return err
+		}
+	}
 
+	log.Debugf("Data page rewritten from position %d, data end moved from %d to %d", pos, oldEnd, newEnd)
 	return nil
 }
 
 // truncateFile truncates file from an entry number onwards
 func (f *StreamFile) truncateFile(entryNum uint64) error {
+	// Exclude the background compactor
+	f.mutexCompress.Lock()
+	defer f.mutexCompress.Unlock()
+
 	// Create iterator and locate the entry in the file
 	iterator, err := f.iteratorFrom(entryNum, true)
 	if err != nil {
@@ -1032,22 +1564,38 @@ func (f *StreamFile) truncateFile(entryNum uint64) error {
 
 	// Current file position
 	curpos, err := iterator.file.Seek(0, io.SeekCurrent)
+	f.iteratorEnd(iterator)
 	if err != nil {
 		log.Errorf("Error seeking current pos: %v", err)
 		return err
 	}
 
+	// Data pages from the truncated one are no longer sealed
+	f.forgetPageDataEnds(curpos)
+	err = f.forgetCompressedPages(curpos)
+	if err != nil {
+		return err
+	}
+
 	// Update internal header
 	f.mutexHeader.Lock()
 	f.header.TotalEntries = entryNum
 	f.header.TotalLength = uint64(curpos)
+	f.committedHead = f.header
 	f.writtenHead = f.header
 	f.mutexHeader.Unlock()
 
 	// Write the header into the file (commit changes)
 	err = f.writeHeaderEntry()
 	if err != nil {
-		return nil
+		return err
+	}
+
+	// Flush the header to disk before any dependent index (bookmarks) is updated
+	err = f.fileHeader.Sync()
+	if err != nil {
+		log.Errorf("Error flushing truncated header to disk: %v", err)
+		return err
 	}
 
 	// Set new file position to write
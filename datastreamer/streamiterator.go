@@ -0,0 +1,338 @@
+package datastreamer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	"github.com/0xPolygonHermez/zkevm-data-streamer/log"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// Iterator type to scan the data entries of a stream file in-process, forward or in reverse order
+type Iterator struct {
+	f        *StreamFile
+	bookmark *StreamBookmark // Bookmarks database (nil to scan the stream file for the bookmarks)
+	reverse  bool            // Flag iterate from the entry to the first one
+
+	iterator  *iteratorFile // File iterator (nil until the starting entry is set)
+	next      uint64        // Next entry number to read
+	end       bool          // Flag no more entries to read (reverse)
+	positions []int64       // Positions of the entries of the current data page still to read (reverse)
+	pageStart int64         // Start position of the current data page (reverse)
+	entry     FileEntry     // Last entry read
+}
+
+// NewIterator creates an iterator of the stream file entries. Bookmarks are located scanning the file
+func (f *StreamFile) NewIterator(reverse bool) *Iterator {
+	return &Iterator{
+		f:       f,
+		reverse: reverse,
+	}
+}
+
+// NewIterator creates an iterator of the stream entries. Bookmarks are located in the bookmarks database
+func (s *StreamServer) NewIterator(reverse bool) *Iterator {
+	return &Iterator{
+		f:        s.streamFile,
+		bookmark: s.bookmark,
+		reverse:  reverse,
+	}
+}
+
+// From sets the entry number to start iterating from
+func (it *Iterator) From(entryNum uint64) error {
+	it.Close()
+
+	// Locate the entry
+	iterator, err := it.f.iteratorFrom(entryNum, true)
+	if err != nil {
+		if iterator != nil {
+			it.f.iteratorEnd(iterator)
+		}
+		return err
+	}
+	it.iterator = iterator
+	it.next = entryNum
+	it.end = false
+
+	if !it.reverse {
+		return nil
+	}
+
+	// Positions of the entries of its data page up to the entry, excluding the data pages rewrites
+	pos, err := iterator.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		log.Errorf("Error seeking current pos for iterator: %v", err)
+		it.Close()
+		return err
+	}
+	it.pageStart = ((pos-PageHeaderSize)/PageDataSize)*PageDataSize + PageHeaderSize
+	it.f.mutexRewrite.RLock()
+	it.positions, err = entryPositions(iterator.file, it.pageStart, pos+1)
+	it.f.mutexRewrite.RUnlock()
+	if err != nil {
+		it.Close()
+		return err
+	}
+	return nil
+}
+
+// FromLast sets the last committed entry to start iterating from
+func (it *Iterator) FromLast() error {
+	total := it.f.getHeaderEntry().TotalEntries
+	if total == 0 {
+		it.Close()
+		return ErrInvalidEntryNumber
+	}
+	return it.From(total - 1)
+}
+
+// FromBookmark sets the entry pointed by the bookmark to start iterating from
+func (it *Iterator) FromBookmark(bookmark []byte) error {
+	var entryNum uint64
+	var err error
+	if it.bookmark != nil {
+		entryNum, err = it.bookmark.GetBookmark(bookmark)
+		if err == leveldb.ErrNotFound {
+			err = ErrBookmarkNotFound
+		}
+	} else {
+		entryNum, err = it.f.scanBookmark(bookmark)
+	}
+	if err != nil {
+		return err
+	}
+	return it.From(entryNum)
+}
+
+// Next reads the next entry of the iteration, returns the end of entries condition
+func (it *Iterator) Next() (bool, error) {
+	if it.iterator == nil {
+		return true, ErrInvalidEntryNumber
+	}
+
+	var err error
+	if it.reverse {
+		err = it.prev()
+	} else {
+		if it.next >= it.f.getHeaderEntry().TotalEntries {
+			return true, nil
+		}
+		_, err = it.f.iteratorNext(it.iterator)
+		if err == nil {
+			it.entry = it.iterator.Entry
+		}
+	}
+	if err == io.EOF {
+		return true, nil
+	} else if err != nil {
+		return true, err
+	}
+
+	// Check the entry read is the one expected
+	if it.entry.Number != it.next {
+		log.Errorf("Error iterator entry number %d read, expected %d", it.entry.Number, it.next)
+		return true, ErrInvalidEntryNumber
+	}
+	if it.reverse {
+		it.end = it.next == 0
+		it.next--
+	} else {
+		it.next++
+	}
+	return false, nil
+}
+
+// prev reads the previous entry, walking the data pages backwards
+func (it *Iterator) prev() error {
+	if it.end {
+		return io.EOF
+	}
+
+	// Exclude the data pages rewrites while reading, locating again the entry if they were rewritten
+	for {
+		it.f.mutexRewrite.RLock()
+		seq, err := it.f.currentRewriteSeq()
+		if err == nil && seq%2 == 0 && seq == it.iterator.rewriteSeq {
+			break
+		}
+		it.f.mutexRewrite.RUnlock()
+		if err != nil {
+			return err
+		} else if seq%2 != 0 {
+			return ErrDataPageRewritten
+		}
+		err = it.From(it.next)
+		if err != nil {
+			return err
+		}
+	}
+	defer it.f.mutexRewrite.RUnlock()
+
+	// Previous data page (sealed, its entries end at the pad)
+	for len(it.positions) == 0 {
+		if it.pageStart <= PageHeaderSize {
+			return io.EOF
+		}
+		it.pageStart = it.pageStart - PageDataSize
+
+		var err error
+		it.positions, err = entryPositions(it.iterator.file, it.pageStart, it.pageStart+PageDataSize)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Last entry of the data page not read yet
+	pos := it.positions[len(it.positions)-1]
+	it.positions = it.positions[:len(it.positions)-1]
+
+	var err error
+	it.entry, err = readEntryAt(it.iterator.file, pos)
+	if err != nil {
+		return err
+	}
+
+	// Check the data page was not rewritten by another process while reading the entry
+	if it.f.readOnly {
+		seq, err := it.f.currentRewriteSeq()
+		if err != nil {
+			return err
+		} else if seq != it.iterator.rewriteSeq {
+			return ErrDataPageRewritten
+		}
+	}
+	return nil
+}
+
+// Entry returns the last entry read by Next
+func (it *Iterator) Entry() FileEntry {
+	return it.entry
+}
+
+// Close finalizes the iterator
+func (it *Iterator) Close() {
+	if it.iterator != nil {
+		it.f.iteratorEnd(it.iterator)
+		it.iterator = nil
+	}
+	it.positions = nil
+}
+
+// entryPositions returns the positions of the data entries of a data page starting before the end position
+func entryPositions(file io.ReaderAt, pageStart int64, end int64) ([]int64, error) {
+	positions := []int64{}
+	pageEnd := pageStart + PageDataSize
+	buffer := make([]byte, 5) // nolint:gomnd
+	for pos := pageStart; pos < end && pageEnd-pos >= FixedSizeFileEntry; {
+		_, err := file.ReadAt(buffer, pos)
+		if err != nil {
+			log.Errorf("Error reading entry for iterator: %v", err)
+			return nil, err
+		}
+
+		if buffer[0] == PtPadding {
+			break
+		} else if buffer[0] != PtData {
+			log.Errorf("Error expecting packet of type data(%d). Read: %d", PtData, buffer[0])
+			return nil, ErrExpectingPacketTypeData
+		}
+
+		length := binary.BigEndian.Uint32(buffer[1:5])
+		if length < FixedSizeFileEntry || pos+int64(length) > pageEnd {
+			log.Errorf("Error decoding length data entry")
+			return nil, ErrDecodingLengthDataEntry
+		}
+		positions = append(positions, pos)
+		pos = pos + int64(length)
+	}
+	return positions, nil
+}
+
+// readEntryAt reads the data entry at a position of the file
+func readEntryAt(file io.ReaderAt, pos int64) (FileEntry, error) {
+	buffer := make([]byte, FixedSizeFileEntry)
+	_, err := file.ReadAt(buffer, pos)
+	if err != nil {
+		log.Errorf("Error reading entry for iterator: %v", err)
+		return FileEntry{}, err
+	}
+
+	length := binary.BigEndian.Uint32(buffer[1:5])
+	if length < FixedSizeFileEntry {
+		log.Errorf("Error decoding length data entry")
+		return FileEntry{}, ErrDecodingLengthDataEntry
+	}
+	if length > FixedSizeFileEntry {
+		data := make([]byte, length-FixedSizeFileEntry)
+		_, err = file.ReadAt(data, pos+FixedSizeFileEntry)
+		if err != nil {
+			log.Errorf("Error reading data for iterator: %v", err)
+			return FileEntry{}, err
+		}
+		buffer = append(buffer, data...)
+	}
+
+	return DecodeBinaryToFileEntry(buffer)
+}
+
+// scanBookmark returns the entry number of the last bookmark entry with the bookmark, scanning the stream
+// file backwards (updated bookmarks point to the latest)
+func (f *StreamFile) scanBookmark(bookmark []byte) (uint64, error) {
+	it := f.NewIterator(true)
+	defer it.Close()
+	err := it.FromLast()
+	if err == ErrInvalidEntryNumber {
+		return 0, ErrBookmarkNotFound
+	} else if err != nil {
+		return 0, err
+	}
+
+	for {
+		end, err := it.Next()
+		if err != nil {
+			return 0, err
+		} else if end {
+			return 0, ErrBookmarkNotFound
+		}
+		if it.entry.Type == EtBookmark && bytes.Equal(it.entry.Data, bookmark) {
+			return it.entry.Number, nil
+		}
+	}
+}
+
+// scanBookmarks returns the entry number of the last bookmark entry before entryNum of each bookmark, scanning
+// the stream file backwards (bookmarks without a bookmark entry before entryNum are not returned)
+func (f *StreamFile) scanBookmarks(bookmarks map[string]struct{}, entryNum uint64) (map[string]uint64, error) {
+	found := map[string]uint64{}
+	if entryNum == 0 {
+		return found, nil
+	}
+
+	it := f.NewIterator(true)
+	defer it.Close()
+	err := it.From(entryNum - 1)
+	if err != nil {
+		return nil, err
+	}
+
+	for len(found) < len(bookmarks) {
+		end, err := it.Next()
+		if err != nil {
+			return nil, err
+		} else if end {
+			break
+		}
+		if it.entry.Type != EtBookmark {
+			continue
+		}
+		if _, ok := bookmarks[string(it.entry.Data)]; !ok {
+			continue
+		}
+		if _, ok := found[string(it.entry.Data)]; !ok {
+			found[string(it.entry.Data)] = it.entry.Number
+		}
+	}
+	return found, nil
+}
@@ -0,0 +1,269 @@
+package datastreamer
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/0xPolygonHermez/zkevm-data-streamer/log"
+)
+
+const (
+	rewriteSeqPos       = fileFlagsPos + 1 // Position of the data pages rewrite sequence in the header page
+	fixedSizeJournalRec = 12               // Fixed size in bytes of a page journal record header (8+4)
+	journalChecksumSize = 4                // Size in bytes of the page journal checksum
+)
+
+// pageWrite type for a write over the committed data of the stream file
+type pageWrite struct {
+	pos  int64
+	data []byte
+}
+
+// pageJournalName returns the name of the data pages rewrite journal of a stream file
+func pageJournalName(fileName string) string {
+	return strings.TrimSuffix(fileName, filepath.Ext(fileName)) + ".jnl"
+}
+
+// openPageJournal opens or creates the data pages rewrite journal. A complete journal left by a rewrite
+// interrupted by a crash is restored into the stream file, it's discarded on a new stream file
+func (f *StreamFile) openPageJournal(newFile bool) error {
+	fn := pageJournalName(f.fileName)
+	_, err := os.Stat(fn)
+	created := os.IsNotExist(err)
+
+	f.journal, err = os.OpenFile(fn, os.O_CREATE|os.O_RDWR, fileMode)
+	if err != nil {
+		log.Errorf("Error opening or creating data pages journal %s: %v", fn, err)
+		return err
+	}
+
+	// Make the journal file itself durable before relying on it
+	if created {
+		return syncDir(fn)
+	}
+
+	if newFile {
+		return f.clearPageJournal()
+	}
+	return f.recoverPageJournal()
+}
+
+// recoverPageJournal writes back the original content saved in the journal by an interrupted rewrite.
+// A journal not completely written is discarded (the rewrite didn't start)
+func (f *StreamFile) recoverPageJournal() error {
+	info, err := f.journal.Stat()
+	if err != nil {
+		log.Errorf("Error getting data pages journal info: %v", err)
+		return err
+	}
+	if info.Size() == 0 {
+		return nil
+	}
+
+	journal := make([]byte, info.Size())
+	_, err = f.journal.ReadAt(journal, 0)
+	if err != nil {
+		log.Errorf("Error reading data pages journal: %v", err)
+		return err
+	}
+
+	// Check it's complete
+	if len(journal) < journalChecksumSize {
+		log.Warnf("Discarding incomplete data pages journal")
+		return f.clearPageJournal()
+	}
+	records := journal[:len(journal)-journalChecksumSize]
+	if crc32.ChecksumIEEE(records) != binary.BigEndian.Uint32(journal[len(records):]) {
+		log.Warnf("Discarding incomplete data pages journal")
+		return f.clearPageJournal()
+	}
+
+	// Write back the original content
+	count := 0
+	for len(records) > 0 {
+		if len(records) < fixedSizeJournalRec {
+			log.Errorf("Error decoding data pages journal record")
+			return ErrInvalidPageJournal
+		}
+		pos := int64(binary.BigEndian.Uint64(records[0:8]))
+		length := int(binary.BigEndian.Uint32(records[8:12]))
+		if len(records) < fixedSizeJournalRec+length {
+			log.Errorf("Error decoding data pages journal record length")
+			return ErrInvalidPageJournal
+		}
+		_, err = f.file.WriteAt(records[fixedSizeJournalRec:fixedSizeJournalRec+length], pos)
+		if err != nil {
+			log.Errorf("Error restoring data pages journal record: %v", err)
+			return err
+		}
+		records = records[fixedSizeJournalRec+length:]
+		count++
+	}
+	err = f.file.Sync()
+	if err != nil {
+		log.Errorf("Error flushing restored data pages journal: %v", err)
+		return err
+	}
+
+	log.Warnf("Restored %d writes of an interrupted data pages rewrite", count)
+	return f.clearPageJournal()
+}
+
+// clearPageJournal empties the journal, flushed to disk
+func (f *StreamFile) clearPageJournal() error {
+	err := f.journal.Truncate(0)
+	if err == nil && f.syncRewrites {
+		err = f.journal.Sync()
+	}
+	if err != nil {
+		log.Errorf("Error clearing data pages journal: %v", err)
+	}
+	return err
+}
+
+// readRewriteSeq reads the data pages rewrite sequence, finishing the one of a rewrite interrupted by a crash
+func (f *StreamFile) readRewriteSeq() error {
+	buffer := make([]byte, 8) // nolint:gomnd
+	_, err := f.fileHeader.ReadAt(buffer, rewriteSeqPos)
+	if err != nil {
+		log.Errorf("Error reading data pages rewrite sequence: %v", err)
+		return err
+	}
+	f.rewriteSeq = binary.BigEndian.Uint64(buffer)
+
+	if f.rewriteSeq%2 != 0 {
+		f.rewriteSeq++
+		return f.writeRewriteSeq()
+	}
+	return nil
+}
+
+// writeRewriteSeq writes the data pages rewrite sequence into the header page
+func (f *StreamFile) writeRewriteSeq() error {
+	_, err := f.fileHeader.WriteAt(binary.BigEndian.AppendUint64(nil, f.rewriteSeq), rewriteSeqPos)
+	if err != nil {
+		log.Errorf("Error writing data pages rewrite sequence: %v", err)
+	}
+	return err
+}
+
+// currentRewriteSeq returns the data pages rewrite sequence, read from the file if it's written by another process.
+// It's odd while a rewrite is in progress
+func (f *StreamFile) currentRewriteSeq() (uint64, error) {
+	if !f.readOnly {
+		return f.rewriteSeq, nil
+	}
+
+	buffer := make([]byte, 8) // nolint:gomnd
+	_, err := f.fileHeader.ReadAt(buffer, rewriteSeqPos)
+	if err != nil {
+		log.Errorf("Error reading data pages rewrite sequence: %v", err)
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(buffer), nil
+}
+
+// beginRewrite excludes the readers of the data pages and flags the rewrite in progress for the readers of
+// other processes (odd rewrite sequence)
+func (f *StreamFile) beginRewrite() error {
+	f.mutexRewrite.Lock()
+
+	f.rewriteSeq++
+	err := f.writeRewriteSeq()
+	if err != nil {
+		f.rewriteSeq--
+		f.mutexRewrite.Unlock()
+		return err
+	}
+	return nil
+}
+
+// endRewrite flags the rewrite finished, so the readers locate again their entries, and allows the readers.
+// A rewrite not changing the logical content (a data page compression) doesn't invalidate the data already read
+func (f *StreamFile) endRewrite(changed bool) {
+	f.rewriteSeq++
+	if changed {
+		f.changedSeq = f.rewriteSeq
+	}
+	err := f.writeRewriteSeq()
+	if err != nil {
+		log.Warnf("Error finishing data pages rewrite sequence: %v", err)
+	}
+	f.mutexRewrite.Unlock()
+}
+
+// rewrite writes over committed data of the stream file in place. The original content is saved to the journal
+// first, so a crash while writing is undone on the next start. The journal and the new content are flushed to
+// disk only if syncRewrites is set. Must be called within beginRewrite and endRewrite
+func (f *StreamFile) rewrite(writes []pageWrite) error {
+	// Journal record of each write: position, length and the original content
+	var journal []byte
+	for _, w := range writes {
+		original := make([]byte, len(w.data))
+		_, err := f.file.ReadAt(original, w.pos)
+		if err != nil {
+			log.Errorf("Error reading data to journal: %v", err)
+			return err
+		}
+		journal = binary.BigEndian.AppendUint64(journal, uint64(w.pos))
+		journal = binary.BigEndian.AppendUint32(journal, uint32(len(original)))
+		journal = append(journal, original...)
+	}
+	journal = binary.BigEndian.AppendUint32(journal, crc32.ChecksumIEEE(journal))
+
+	// Write the journal
+	_, err := f.journal.WriteAt(journal, 0)
+	if err == nil {
+		err = f.journal.Truncate(int64(len(journal)))
+	}
+	if err == nil && f.syncRewrites {
+		err = f.journal.Sync()
+	}
+	if err != nil {
+		log.Errorf("Error writing data pages journal: %v", err)
+		return err
+	}
+
+	// Write the new content, restoring the original one if it fails
+	for _, w := range writes {
+		_, err = f.file.WriteAt(w.data, w.pos)
+		if err != nil {
+			log.Errorf("Error rewriting data pages: %v", err)
+			break
+		}
+	}
+	if err == nil && f.syncRewrites {
+		err = f.file.Sync()
+		if err != nil {
+			log.Errorf("Error flushing rewritten data pages: %v", err)
+		}
+	}
+	if err != nil {
+		if errRecover := f.recoverPageJournal(); errRecover != nil {
+			log.Errorf("Error restoring data pages after a failed rewrite: %v", errRecover)
+		}
+		return err
+	}
+
+	// Done, the journal is no longer needed
+	return f.clearPageJournal()
+}
+
+// syncDir flushes to disk the directory entry of a file
+func syncDir(fn string) error {
+	dir, err := os.Open(filepath.Dir(fn))
+	if err != nil {
+		log.Errorf("Error opening directory of %s: %v", fn, err)
+		return err
+	}
+	defer dir.Close()
+
+	err = dir.Sync()
+	if err != nil {
+		log.Errorf("Error flushing directory of %s: %v", fn, err)
+	}
+	return err
+}
@@ -0,0 +1,156 @@
+package datastreamer
+
+import (
+	"io"
+
+	"github.com/0xPolygonHermez/zkevm-data-streamer/log"
+)
+
+// fileMapping type for a read-only memory mapping of the stream file
+type fileMapping struct {
+	data []byte
+	refs int // Readers using the mapping (+1 while it's the current mapping of the file)
+}
+
+// mmapReader type for an iterator reader using the shared memory mapping of the stream file
+type mmapReader struct {
+	f       *StreamFile
+	mapping *fileMapping
+	pos     int64
+}
+
+// enableMmapReads maps the stream file to use the memory mapping in the read-only iterators
+func (f *StreamFile) enableMmapReads() error {
+	data, err := mmapFile(f.file, int(f.maxLength))
+	if err != nil {
+		log.Errorf("Error mapping stream file into memory: %v", err)
+		return err
+	}
+
+	f.mutexMapping.Lock()
+	f.mapping = &fileMapping{data: data, refs: 1}
+	f.mmapReads = true
+	f.mutexMapping.Unlock()
+
+	log.Infof("Memory mapped stream file reads enabled. Mapped length: %d", f.maxLength)
+	return nil
+}
+
+// remapFile maps again the stream file after a size change, the old mapping is released when not used
+func (f *StreamFile) remapFile() error {
+	data, err := mmapFile(f.file, int(f.maxLength))
+	if err != nil {
+		log.Errorf("Error remapping stream file into memory: %v", err)
+		return err
+	}
+
+	f.mutexMapping.Lock()
+	old := f.mapping
+	f.mapping = &fileMapping{data: data, refs: 1}
+	f.mutexMapping.Unlock()
+
+	f.releaseMapping(old)
+	return nil
+}
+
+// acquireMapping returns the current memory mapping of the file adding a reference
+func (f *StreamFile) acquireMapping() *fileMapping {
+	f.mutexMapping.Lock()
+	defer f.mutexMapping.Unlock()
+
+	f.mapping.refs++
+	return f.mapping
+}
+
+// releaseMapping removes a reference of a memory mapping, unmapped when not used anymore
+func (f *StreamFile) releaseMapping(m *fileMapping) {
+	f.mutexMapping.Lock()
+	defer f.mutexMapping.Unlock()
+
+	m.refs--
+	if m.refs == 0 {
+		err := munmapFile(m.data)
+		if err != nil {
+			log.Errorf("Error unmapping stream file: %v", err)
+		}
+		m.data = nil
+	}
+}
+
+// newMmapReader creates a reader of the current memory mapping of the file
+func (f *StreamFile) newMmapReader() *mmapReader {
+	return &mmapReader{
+		f:       f,
+		mapping: f.acquireMapping(),
+		pos:     0,
+	}
+}
+
+// Read reads from the current position of the reader
+func (r *mmapReader) Read(p []byte) (int, error) {
+	n, err := r.ReadAt(p, r.pos)
+	r.pos = r.pos + int64(n)
+	return n, err
+}
+
+// ReadAt reads from a position of the file, moving to the current mapping if it's beyond the mapped length
+func (r *mmapReader) ReadAt(p []byte, off int64) (int, error) {
+	if off+int64(len(p)) > int64(len(r.mapping.data)) {
+		r.refresh()
+	}
+	if off < 0 || off >= int64(len(r.mapping.data)) {
+		return 0, io.EOF
+	}
+
+	n := copy(p, r.mapping.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Seek sets the current position of the reader
+func (r *mmapReader) Seek(offset int64, whence int) (int64, error) {
+	var pos int64
+	switch whence {
+	case io.SeekStart:
+		pos = offset
+	case io.SeekCurrent:
+		pos = r.pos + offset
+	case io.SeekEnd:
+		r.refresh()
+		pos = int64(len(r.mapping.data)) + offset
+	}
+	if pos < 0 {
+		return r.pos, ErrInvalidSeekPosition
+	}
+	r.pos = pos
+	return pos, nil
+}
+
+// Close releases the memory mapping used by the reader
+func (r *mmapReader) Close() error {
+	if r.mapping != nil {
+		r.f.releaseMapping(r.mapping)
+		r.mapping = nil
+	}
+	return nil
+}
+
+// bytes returns the mapped bytes of a range of the file
+func (r *mmapReader) bytes(pos int64, end int64) ([]byte, error) {
+	if end > int64(len(r.mapping.data)) {
+		r.refresh()
+	}
+	if pos < 0 || pos > end || end > int64(len(r.mapping.data)) {
+		return nil, io.EOF
+	}
+	return r.mapping.data[pos:end], nil
+}
+
+// refresh moves the reader to the current memory mapping of the file
+func (r *mmapReader) refresh() {
+	current := r.f.acquireMapping()
+	r.f.releaseMapping(r.mapping)
+	r.mapping = current
+}
@@ -0,0 +1,17 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package datastreamer
+
+import (
+	"os"
+)
+
+// mmapFile memory mapping not supported in this platform
+func mmapFile(file *os.File, length int) ([]byte, error) {
+	return nil, ErrMmapNotSupported
+}
+
+// munmapFile memory mapping not supported in this platform
+func munmapFile(data []byte) error {
+	return ErrMmapNotSupported
+}
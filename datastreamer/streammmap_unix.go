@@ -0,0 +1,18 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package datastreamer
+
+import (
+	"os"
+	"syscall"
+)
+
+// mmapFile maps into memory (read-only and shared) the first length bytes of the file
+func mmapFile(file *os.File, length int) ([]byte, error) {
+	return syscall.Mmap(int(file.Fd()), 0, length, syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+// munmapFile unmaps a memory mapping of the file
+func munmapFile(data []byte) error {
+	return syscall.Munmap(data)
+}
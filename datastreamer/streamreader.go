@@ -0,0 +1,379 @@
+package datastreamer
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/0xPolygonHermez/zkevm-data-streamer/log"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+const (
+	defaultPollInterval = 100 * time.Millisecond // Default interval to check the header for new entries
+	readerRetries       = 3                      // Attempts to read an entry rewritten meanwhile by the writer
+	readerRetryDelay    = 10 * time.Millisecond  // Delay to read again an entry whose data page is being rewritten
+)
+
+// StreamReader type to read a stream file locally, without the server. It's read-only, so it can be used
+// concurrently with the server writing the file
+type StreamReader struct {
+	streamFile   *StreamFile
+	dbName       string        // Bookmarks database name
+	pollInterval time.Duration // Interval to check the header for new entries when tailing
+
+	iterator  *iteratorFile // Iterator of the streaming (nil until the next entry is located)
+	nextEntry uint64        // Next entry number to stream
+	mutex     sync.Mutex    // Mutex for the file reads and the streaming position
+
+	subscription chan FileEntry // Channel to deliver the streamed entries when subscribed (nil if not)
+
+	closed    chan struct{} // Channel closed when the reader is closed
+	closeOnce sync.Once
+	closeErr  error // Error that stopped the subscription (nil if closed by Close)
+}
+
+// NewReader opens a stream file to read it locally
+func NewReader(fileName string, streamType StreamType) (*StreamReader, error) {
+	return NewReaderWithConfig(ReaderConfig{Filename: fileName, StreamType: streamType})
+}
+
+// NewReaderWithConfig opens a stream file to read it locally using the configuration
+func NewReaderWithConfig(cfg ReaderConfig) (*StreamReader, error) {
+	// Open the stream file read-only
+	f, err := openStreamFileReadOnly(cfg.Filename, cfg.StreamType)
+	if err != nil {
+		return nil, err
+	}
+
+	// Defaults
+	if cfg.PollInterval == 0 {
+		cfg.PollInterval = defaultPollInterval
+	}
+
+	r := StreamReader{
+		streamFile:   f,
+		dbName:       bookmarksDbName(cfg.Filename),
+		pollInterval: cfg.PollInterval,
+		closed:       make(chan struct{}),
+	}
+
+	return &r, nil
+}
+
+// Close closes the stream file and stops the subscription
+func (r *StreamReader) Close() error {
+	r.closeWithError(nil)
+	return nil
+}
+
+// closeWithError closes the reader once, keeping the error that stopped it
+func (r *StreamReader) closeWithError(err error) {
+	r.closeOnce.Do(func() {
+		r.closeErr = err
+		close(r.closed)
+
+		r.mutex.Lock()
+		r.endIterator()
+		r.streamFile.closeFile()
+		r.mutex.Unlock()
+	})
+}
+
+// isClosed returns if the reader is closed
+func (r *StreamReader) isClosed() bool {
+	select {
+	case <-r.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// GetHeader returns the current header of the stream file
+func (r *StreamReader) GetHeader(ctx context.Context) (HeaderEntry, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.isClosed() {
+		return HeaderEntry{}, ErrReaderClosed
+	}
+	err := r.streamFile.readHeaderEntry()
+	if err != nil {
+		return HeaderEntry{}, err
+	}
+	return r.streamFile.getHeaderEntry(), nil
+}
+
+// GetEntry returns the entry of the stream file by entry number
+func (r *StreamReader) GetEntry(ctx context.Context, entryNum uint64) (FileEntry, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.isClosed() {
+		return FileEntry{}, ErrReaderClosed
+	}
+	err := r.streamFile.readHeaderEntry()
+	if err != nil {
+		return FileEntry{}, err
+	}
+	if entryNum >= r.streamFile.getHeaderEntry().TotalEntries {
+		return FileEntry{}, ErrEntryNotFound
+	}
+
+	return r.readEntry(entryNum)
+}
+
+// GetBookmark returns the first entry of the stream file after the bookmark
+func (r *StreamReader) GetBookmark(ctx context.Context, bookmark []byte) (FileEntry, error) {
+	entryNum, err := r.locateBookmark(bookmark)
+	if err != nil {
+		return FileEntry{}, err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.isClosed() {
+		return FileEntry{}, ErrReaderClosed
+	}
+	err = r.streamFile.readHeaderEntry()
+	if err != nil {
+		return FileEntry{}, err
+	}
+
+	// Skip the bookmark entries
+	total := r.streamFile.getHeaderEntry().TotalEntries
+	for n := entryNum; n < total; n++ {
+		e, err := r.readEntry(n)
+		if err != nil || e.Type != EtBookmark {
+			return e, err
+		}
+	}
+	return FileEntry{}, ErrEntryNotFound
+}
+
+// StreamFrom sets the streaming to start from the entry number
+func (r *StreamReader) StreamFrom(ctx context.Context, fromEntry uint64) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.isClosed() {
+		return ErrReaderClosed
+	}
+	r.endIterator()
+	r.nextEntry = fromEntry
+	return nil
+}
+
+// StreamFromBookmark sets the streaming to start from the entry pointed by the bookmark
+func (r *StreamReader) StreamFromBookmark(ctx context.Context, bookmark []byte) error {
+	entryNum, err := r.locateBookmark(bookmark)
+	if err != nil {
+		return err
+	}
+	return r.StreamFrom(ctx, entryNum)
+}
+
+// Subscribe returns a channel receiving the streamed entries, closed when the reader is closed
+func (r *StreamReader) Subscribe(buffer int) <-chan FileEntry {
+	r.subscription = make(chan FileEntry, buffer)
+	go r.subscribe()
+	return r.subscription
+}
+
+// subscribe sends the streamed entries to the subscription channel until the reader is closed
+func (r *StreamReader) subscribe() {
+	defer close(r.subscription)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-r.closed
+		cancel()
+	}()
+
+	for {
+		e, err := r.next(ctx)
+		if err != nil {
+			if !r.isClosed() {
+				log.Errorf("Error reading the next entry: %v", err)
+				r.closeWithError(err)
+			}
+			return
+		}
+
+		select {
+		case r.subscription <- e:
+		case <-r.closed:
+			return
+		}
+	}
+}
+
+// Next returns the next streamed entry, waiting until it's committed or the context is done
+func (r *StreamReader) Next(ctx context.Context) (FileEntry, error) {
+	if r.subscription == nil {
+		return r.next(ctx)
+	}
+
+	select {
+	case e, ok := <-r.subscription:
+		if !ok {
+			if r.closeErr != nil {
+				return FileEntry{}, r.closeErr
+			}
+			return FileEntry{}, ErrReaderClosed
+		}
+		return e, nil
+	case <-ctx.Done():
+		return FileEntry{}, ctx.Err()
+	}
+}
+
+// next reads the next streamed entry, checking the header for new entries every poll interval
+func (r *StreamReader) next(ctx context.Context) (FileEntry, error) {
+	for {
+		e, ok, err := r.tryNext()
+		if err != nil || ok {
+			return e, err
+		}
+
+		// Wait for new entries
+		select {
+		case <-time.After(r.pollInterval):
+		case <-r.closed:
+			return FileEntry{}, ErrReaderClosed
+		case <-ctx.Done():
+			return FileEntry{}, ctx.Err()
+		}
+	}
+}
+
+// tryNext reads the next streamed entry if it's committed
+func (r *StreamReader) tryNext() (FileEntry, bool, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.isClosed() {
+		return FileEntry{}, false, ErrReaderClosed
+	}
+
+	// Check new entries
+	if r.nextEntry >= r.streamFile.getHeaderEntry().TotalEntries {
+		err := r.streamFile.readHeaderEntry()
+		if err != nil {
+			return FileEntry{}, false, err
+		}
+		if r.nextEntry >= r.streamFile.getHeaderEntry().TotalEntries {
+			return FileEntry{}, false, nil
+		}
+	}
+
+	// Read it, locating it again if the data page was rewritten by the writer meanwhile
+	var err error
+	for i := 0; i < readerRetries; i++ {
+		if r.iterator == nil {
+			r.iterator, err = r.streamFile.iteratorFrom(r.nextEntry, true)
+			if err != nil {
+				r.endIterator()
+				waitRewrite(err)
+				continue
+			}
+		}
+
+		_, err = r.streamFile.iteratorNext(r.iterator)
+		if err == nil && r.iterator.Entry.Number == r.nextEntry {
+			r.nextEntry++
+			return r.iterator.Entry, true, nil
+		}
+		if err == nil {
+			log.Warnf("Entry %d read, expected %d. Locating it again", r.iterator.Entry.Number, r.nextEntry)
+			err = ErrInvalidEntryNumber
+		}
+		r.endIterator()
+		waitRewrite(err)
+	}
+	return FileEntry{}, false, err
+}
+
+// readEntry reads an entry by its number with a new iterator. The mutex must be held
+func (r *StreamReader) readEntry(entryNum uint64) (FileEntry, error) {
+	var err error
+	for i := 0; i < readerRetries; i++ {
+		var iterator *iteratorFile
+		iterator, err = r.streamFile.iteratorFrom(entryNum, true)
+		if err != nil {
+			if iterator != nil {
+				r.streamFile.iteratorEnd(iterator)
+			}
+			waitRewrite(err)
+			continue
+		}
+		_, err = r.streamFile.iteratorNext(iterator)
+		r.streamFile.iteratorEnd(iterator)
+		if err == nil && iterator.Entry.Number == entryNum {
+			return iterator.Entry, nil
+		}
+		if err == nil {
+			err = ErrInvalidEntryNumber
+		}
+		waitRewrite(err)
+	}
+	return FileEntry{}, err
+}
+
+// waitRewrite waits for the writer to finish the rewrite of a data page read meanwhile
+func waitRewrite(err error) {
+	if err == ErrDataPageRewritten {
+		time.Sleep(readerRetryDelay)
+	}
+}
+
+// endIterator closes the streaming iterator. The mutex must be held
+func (r *StreamReader) endIterator() {
+	if r.iterator != nil {
+		r.streamFile.iteratorEnd(r.iterator)
+		r.iterator = nil
+	}
+}
+
+// locateBookmark returns the entry number of a bookmark from the bookmarks database. The database can't be
+// opened while the server is running, then the stream file is scanned for the bookmark entry
+func (r *StreamReader) locateBookmark(bookmark []byte) (uint64, error) {
+	// Bookmarks database opened just for the lookup
+	db, err := leveldb.OpenFile(r.dbName, &opt.Options{ReadOnly: true, ErrorIfMissing: true})
+	if err == nil {
+		b := StreamBookmark{dbName: r.dbName, db: db}
+		entryNum, err := b.GetBookmark(bookmark)
+		db.Close()
+		if err == leveldb.ErrNotFound {
+			return 0, ErrBookmarkNotFound
+		}
+		return entryNum, err
+	}
+	log.Debugf("Bookmarks DB %s not available, scanning the stream file: %v", r.dbName, err)
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.isClosed() {
+		return 0, ErrReaderClosed
+	}
+	err = r.streamFile.readHeaderEntry()
+	if err != nil {
+		return 0, err
+	}
+
+	// Scan it again if the data pages are rewritten by the writer meanwhile
+	var entryNum uint64
+	for i := 0; i < readerRetries; i++ {
+		entryNum, err = r.streamFile.scanBookmark(bookmark)
+		if err != ErrDataPageRewritten {
+			break
+		}
+		waitRewrite(err)
+	}
+	return entryNum, err
+}
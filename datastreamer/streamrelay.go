@@ -1,6 +1,10 @@
 package datastreamer
 
-import "github.com/0xPolygonHermez/zkevm-data-streamer/log"
+import (
+	"context"
+
+	"github.com/0xPolygonHermez/zkevm-data-streamer/log"
+)
 
 // StreamRelay type to manage a data stream relay
 type StreamRelay struct {
@@ -43,12 +47,12 @@ func (r *StreamRelay) Start() error {
 	}
 
 	// Get total entries from the master server
-	err = r.client.ExecCommand(CmdHeader)
+	header, err := r.client.GetHeader(context.Background())
 	if err != nil {
 		log.Errorf("Error executing header command: %v", err)
 		return err
 	}
-	r.server.initEntry = r.client.Header.TotalEntries
+	r.server.initEntry = header.TotalEntries
 
 	// Start server side before exec command `CmdStart`
 	err = r.server.Start()
@@ -58,9 +62,9 @@ func (r *StreamRelay) Start() error {
 	}
 
 	// Sync with master server from latest received entry
-	r.client.FromEntry = r.server.GetHeader().TotalEntries
-	log.Infof("TotalEntries: RELAY %d of MASTER %d", r.client.FromEntry, r.server.initEntry)
-	err = r.client.ExecCommand(CmdStart)
+	fromEntry := r.server.GetHeader().TotalEntries
+	log.Infof("TotalEntries: RELAY %d of MASTER %d", fromEntry, r.server.initEntry)
+	err = r.client.StreamFrom(context.Background(), fromEntry)
 	if err != nil {
 		log.Errorf("Error executing start command: %v", err)
 		return err
@@ -69,6 +73,11 @@ func (r *StreamRelay) Start() error {
 	return nil
 }
 
+// Errors returns the channel where the errors of the client side are reported (the relay stops relaying on error)
+func (r *StreamRelay) Errors() <-chan error {
+	return r.client.Errors()
+}
+
 // relayEntry relays the entry received as client to the clients connected to the server
 func relayEntry(e *FileEntry, c *StreamClient, s *StreamServer) error {
 	// Start atomic operation
@@ -2,9 +2,11 @@ package datastreamer
 
 import (
 	"encoding/binary"
+	"errors"
 	"io"
 	"math"
 	"net"
+	"os"
 	"strconv"
 	"strings"
 	"sync"
@@ -33,13 +35,23 @@ type StreamType uint64
 // CommandError type for the command responses
 type CommandError uint32
 
+// UpdateMode type for the update entry data mode flags
+type UpdateMode uint32
+
+// CompressionMode type for the compression of the streamed data entries sent to a client
+type CompressionMode uint32
+
 // EntryTypeNotFound is the entry type value for CmdEntry/CmdBookmark when entry/bookmark not found
 const EntryTypeNotFound = math.MaxUint32
 
 const (
-	maxConnections    = 100 // Maximum number of connected clients
-	streamBuffer      = 256 // Buffers for the stream channel
-	maxBookmarkLength = 16  // Maximum number of bytes for a bookmark
+	maxConnections    = 100  // Maximum number of connected clients
+	streamBuffer      = 256  // Buffers for the stream channel
+	maxBookmarkLength = 16   // Maximum number of bytes for a bookmark
+	maxLatestEntries  = 1000 // Maximum number of entries returned by the LatestEntries command
+
+	minAcceptDelay = 5 * time.Millisecond // Initial delay to accept connections again after an accept error
+	maxAcceptDelay = time.Second          // Maximum delay to accept connections again after accept errors
 )
 
 const (
@@ -49,6 +61,12 @@ const (
 	CmdStartBookmark                    // CmdStartBookmark for the start from bookmark TCP client command
 	CmdEntry                            // CmdEntry for the get entry TCP client command
 	CmdBookmark                         // CmdBookmark for the get bookmark TCP client command
+	CmdCompression                      // CmdCompression for the set streaming compression TCP client command
+	CmdPing                             // CmdPing for the heartbeat TCP client command (answered with a pong packet)
+	CmdLatestEntries                    // CmdLatestEntries for the get latest entries of a type TCP client command
+	CmdStartTime                        // CmdStartTime for the start from time TCP client command
+	CmdEntryTime                        // CmdEntryTime for the get entry from time TCP client command
+	CmdTimestamps                       // CmdTimestamps for the send commit time of the streamed entries TCP client command
 )
 
 const (
@@ -57,9 +75,21 @@ const (
 	CmdErrAlreadyStopped                      // CmdErrAlreadyStopped for client already stopped error
 	CmdErrBadFromEntry                        // CmdErrBadFromEntry for invalid starting entry number
 	CmdErrBadFromBookmark                     // CmdErrBadFromBookmark for invalid starting bookmark
+	CmdErrBadCompression                      // CmdErrBadCompression for unknown compression mode
 	CmdErrInvalidCommand  CommandError = 9    // CmdErrInvalidCommand for invalid/unknown command error
 )
 
+const (
+	UmStrict          UpdateMode = 0      // UmStrict for updates keeping the entry type and the data length
+	UmAllowResize     UpdateMode = 1 << 0 // UmAllowResize for updates changing the data length within the entry data page (rewrites it)
+	UmAllowTypeChange UpdateMode = 1 << 1 // UmAllowTypeChange for updates changing the entry type (bookmarks excluded)
+)
+
+const (
+	CompressionNone   CompressionMode = 0 // CompressionNone for data entries sent as they are
+	CompressionSnappy CompressionMode = 1 // CompressionSnappy for batches of data entries compressed with snappy
+)
+
 const (
 	// Client status
 	csSyncing ClientStatus = iota + 1
@@ -93,6 +123,12 @@ var (
 		CmdStartBookmark: "StartBookmark",
 		CmdEntry:         "Entry",
 		CmdBookmark:      "Bookmark",
+		CmdCompression:   "Compression",
+		CmdPing:          "Ping",
+		CmdLatestEntries: "LatestEntries",
+		CmdStartTime:     "StartTime",
+		CmdEntryTime:     "EntryTime",
+		CmdTimestamps:    "Timestamps",
 	}
 
 	// StrCommandErrors for TCP command errors description
@@ -102,6 +138,7 @@ var (
 		CmdErrAlreadyStopped:  "Already stopped",
 		CmdErrBadFromEntry:    "Bad from entry",
 		CmdErrBadFromBookmark: "Bad from bookmark",
+		CmdErrBadCompression:  "Bad compression mode",
 		CmdErrInvalidCommand:  "Invalid command",
 	}
 )
@@ -120,10 +157,32 @@ type StreamServer struct {
 	nextEntry uint64 // Next sequential entry number
 	initEntry uint64 // Only used by the relay (initial next entry in the master server)
 
-	atomicOp   streamAO      // Current in progress (if any) atomic operation
-	stream     chan streamAO // Channel to stream committed atomic operations
-	streamFile *StreamFile
-	bookmark   *StreamBookmark
+	atomicOp      streamAO      // Current in progress (if any) atomic operation
+	atomicOpTx    *StreamTx     // Transaction handle owning the current atomic operation (nil if not started by Begin)
+	mutexAtomicOp sync.Mutex    // Mutex for the atomic operation state, the entry number and the stream file writes
+	mutexWriter   sync.Mutex    // Mutex to serialize writers, held while an atomic operation is in progress
+	stream        chan streamAO // Channel to stream committed atomic operations
+	streamFile    *StreamFile
+	bookmark      *StreamBookmark
+	timestamps    *timestampIndex // Commit time of the atomic operations
+	inconsistent  bool            // Stream file or indexes in unknown state after a failure, writes refused until restart
+
+	durability      DurabilityMode // Durability level of the commits
+	groupInterval   time.Duration  // Maximum time between flushes in group durability mode
+	groupMaxCommits uint64         // Number of pending commits that triggers a flush in group durability mode
+	groupPending    []groupCommit  // Commits waiting to be flushed in group durability mode
+	groupFlush      chan struct{}  // Channel to trigger a flush in group durability mode
+	mutexGroup      sync.Mutex     // Mutex for the pending commits in group durability mode
+
+	cache *entryCache // Recent committed entries cache (nil if disabled)
+
+	wireStats CompressionStats // Data entries bytes sent to the clients with compression
+
+	heartbeatInterval time.Duration // Interval to send ping packets to the clients (0 disables)
+	heartbeatTimeout  time.Duration // Maximum time without receiving commands from a client (0 disables)
+
+	commandTimeout time.Duration // Maximum time to receive the parameters of a command (0 disables)
+	writeTimeout   time.Duration // Maximum time to send a packet or a data range to a client (0 disables)
 }
 
 // streamAO type to manage atomic operations
@@ -131,14 +190,48 @@ type streamAO struct {
 	status     AOStatus
 	startEntry uint64
 	entries    []FileEntry
+	bookmarks  []bookmarkAO // Bookmarks pending to be written to the index on commit
+	timestamp  uint64       // Commit time in Unix milliseconds
+}
+
+// encodedAO type for a committed atomic operation encoded once to be sent to all the clients
+type encodedAO struct {
+	firstEntry uint64 // Number of the first entry
+	data       []byte // Encoded entries
+	offsets    []int  // Start position of each entry in the encoded data
+	compressed []byte // Compressed batch of all the encoded entries (created on first use)
+	timestamp  []byte // Timestamp packet with the commit time
+}
+
+// groupCommit type for a commit waiting to be flushed in group durability mode
+type groupCommit struct {
+	atomicOp *streamAO   // Committed atomic operation to write and broadcast on flush (nil for just a flush)
+	header   HeaderEntry // Header of the stream file including the atomic operation
+	done     chan error  // Channel to acknowledge the flush
+}
+
+// bookmarkAO type for a bookmark added in an atomic operation
+type bookmarkAO struct {
+	bookmark []byte
+	entryNum uint64
+}
+
+// StreamTx type to manage an atomic operation through a transaction handle
+type StreamTx struct {
+	server *StreamServer
+	done   bool // Flag transaction finished (committed or rollbacked)
 }
 
 // client type for the server to manage clients
 type client struct {
-	conn      net.Conn
-	status    ClientStatus
-	fromEntry uint64
-	clientId  string
+	conn        net.Conn
+	status      ClientStatus
+	fromEntry   uint64
+	clientId    string
+	compression CompressionMode // Compression of the streamed data entries
+	timestamps  bool            // Flag send the commit time of the streamed entries
+	mutexWrite  sync.Mutex      // Mutex to serialize the writes to the client connection
+	timeout     time.Duration   // Maximum time of each write to the client connection (0 disables)
 }
 
 // ResultEntry type for a result entry
@@ -151,10 +244,39 @@ type ResultEntry struct {
 
 // NewServer creates a new data stream server
 func NewServer(port uint16, streamType StreamType, fileName string, cfg *log.Config) (*StreamServer, error) {
+	return newServer(Config{Port: port, Filename: fileName}, streamType, cfg)
+}
+
+// NewServerWithConfig creates a new data stream server using the configuration (log initialized if level is set)
+func NewServerWithConfig(cfg Config, streamType StreamType) (*StreamServer, error) {
+	var logCfg *log.Config
+	if cfg.Log.Level != "" {
+		logCfg = &cfg.Log
+	}
+	return newServer(cfg, streamType, logCfg)
+}
+
+// newServer creates a new data stream server
+func newServer(config Config, streamType StreamType, cfg *log.Config) (*StreamServer, error) {
+	// Check durability mode
+	if config.Durability == "" {
+		config.Durability = DurabilityBuffered
+	}
+	if config.Durability != DurabilityBuffered && config.Durability != DurabilityFsync && config.Durability != DurabilityGroup {
+		log.Errorf("Invalid durability mode: %s", config.Durability)
+		return nil, ErrInvalidDurabilityMode
+	}
+	if config.GroupCommitInterval == 0 {
+		config.GroupCommitInterval = defaultGroupCommitInterval
+	}
+	if config.GroupCommitMaxCommits == 0 {
+		config.GroupCommitMaxCommits = defaultGroupCommitMaxCommits
+	}
+
 	// Create the server data stream
 	s := StreamServer{
-		port:     port,
-		fileName: fileName,
+		port:     config.Port,
+		fileName: config.Filename,
 		started:  false,
 
 		streamType: streamType,
@@ -167,8 +289,21 @@ func NewServer(port uint16, streamType StreamType, fileName string, cfg *log.Con
 			status:     aoNone,
 			startEntry: 0,
 			entries:    []FileEntry{},
+			bookmarks:  []bookmarkAO{},
 		},
 		stream: make(chan streamAO, streamBuffer),
+
+		durability:      config.Durability,
+		groupInterval:   config.GroupCommitInterval,
+		groupMaxCommits: config.GroupCommitMaxCommits,
+		groupPending:    []groupCommit{},
+		groupFlush:      make(chan struct{}, 1),
+
+		heartbeatInterval: config.HeartbeatInterval,
+		heartbeatTimeout:  config.HeartbeatTimeout,
+
+		commandTimeout: config.CommandTimeout,
+		writeTimeout:   config.WriteTimeout,
 	}
 
 	// Add file extension if not present
@@ -189,19 +324,83 @@ func NewServer(port uint16, streamType StreamType, fileName string, cfg *log.Con
 		return nil, err
 	}
 
+	// Rewrites in place are flushed to disk unless the durability is buffered
+	s.streamFile.syncRewrites = s.durability != DurabilityBuffered
+
+	// Compress the sealed data pages
+	if config.CompressPages {
+		err = s.streamFile.enableCompression()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Recent entries cache
+	if config.CacheEntries > 0 {
+		s.cache = newEntryCache(config.CacheEntries)
+	}
+
+	// Use the memory mapping of the file for reads (fallback to file reads if not possible)
+	if config.MmapReads {
+		err = s.streamFile.enableMmapReads()
+		if err != nil {
+			log.Warnf("Memory mapped reads not enabled, using file reads: %v", err)
+		}
+	}
+
 	// Initialize the data entry number
 	s.nextEntry = s.streamFile.header.TotalEntries
 
 	// Open (or create) the bookmarks DB
-	name := s.fileName[0:strings.IndexRune(s.fileName, '.')] + ".db"
-	s.bookmark, err = NewBookmark(name)
+	s.bookmark, err = NewBookmark(bookmarksDbName(s.fileName))
+	if err != nil {
+		return &s, err
+	}
+
+	// Check bookmarks consistency with the stream file
+	err = s.checkBookmarksConsistency()
+	if err != nil {
+		return &s, err
+	}
+
+	// Open (or create) the timestamp index
+	s.timestamps, err = openTimestampIndex(timestampIndexName(s.fileName))
+	if err != nil {
+		return &s, err
+	}
+
+	// Check timestamp index consistency with the stream file
+	count, err := s.timestamps.truncate(s.nextEntry)
 	if err != nil {
 		return &s, err
 	}
+	if count > 0 {
+		log.Warnf("Removed %d timestamps of entries not present in the file (total entries: %d)", count, s.nextEntry)
+	}
 
 	return &s, nil
 }
 
+// checkBookmarksConsistency restores bookmarks pointing to entries not present in the stream file
+func (s *StreamServer) checkBookmarksConsistency() error {
+	// Bookmarks written by older versions are indexed by entry number once
+	err := s.bookmark.migrateBookmarks()
+	if err != nil {
+		return err
+	}
+
+	// Bookmarks can point past the end of the file if a commit was interrupted before the header write
+	// or a truncate was interrupted after the header write
+	count, err := s.bookmark.restoreBookmarksFrom(s.nextEntry, s.streamFile)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		log.Warnf("Restored %d bookmarks pointing to entries not present in the file (total entries: %d)", count, s.nextEntry)
+	}
+	return nil
+}
+
 // Start opens access to TCP clients and starts broadcasting
 func (s *StreamServer) Start() error {
 	// Start the server data stream
@@ -215,6 +414,16 @@ func (s *StreamServer) Start() error {
 	// Goroutine to broadcast committed atomic operations
 	go s.broadcastAtomicOp()
 
+	// Goroutine to flush the group commits
+	if s.durability == DurabilityGroup {
+		go s.groupCommitLoop()
+	}
+
+	// Goroutine to send the heartbeat to the clients
+	if s.heartbeatInterval > 0 {
+		go s.heartbeatLoop()
+	}
+
 	// Goroutine to wait for clients connections
 	log.Infof("Listening on port: %d", s.port)
 	go s.waitConnections()
@@ -229,19 +438,26 @@ func (s *StreamServer) Start() error {
 func (s *StreamServer) waitConnections() {
 	defer s.ln.Close()
 
+	var delay time.Duration
 	for {
 		conn, err := s.ln.Accept()
 		if err != nil {
-			log.Errorf("Error accepting new connection: %v", err)
-			time.Sleep(2 * time.Second) // nolint:gomnd
+			// Retry backing off while accepting fails (e.g. too many open files)
+			if delay == 0 {
+				delay = minAcceptDelay
+			} else if delay = 2 * delay; delay > maxAcceptDelay {
+				delay = maxAcceptDelay
+			}
+			log.Errorf("Error accepting new connection, retrying in %v: %v", delay, err)
+			time.Sleep(delay)
 			continue
 		}
+		delay = 0
 
 		// Check max connections allowed
 		if s.getSafeClientsLen() >= maxConnections {
 			log.Warnf("Unable to accept client connection, maximum number of connections reached (%d)", maxConnections)
 			conn.Close()
-			time.Sleep(2 * time.Second) // nolint:gomnd
 			continue
 		}
 
@@ -263,19 +479,39 @@ func (s *StreamServer) handleConnection(conn net.Conn) {
 		status:    csStopped,
 		fromEntry: 0,
 		clientId:  clientId,
+		timeout:   s.writeTimeout,
 	}
 	s.mutexClients.Unlock()
 
 	for {
+		// Idle clients are killed if heartbeat timeout is set
+		deadline := time.Time{}
+		if s.heartbeatTimeout > 0 {
+			deadline = time.Now().Add(s.heartbeatTimeout)
+		}
+		_ = conn.SetReadDeadline(deadline)
+
 		// Read command
 		command, err := readFullUint64(conn)
 		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				log.Warnf("Heartbeat lost from client %s, killed", clientId)
+			}
 			s.killClient(clientId)
 			return
 		}
+
+		// The rest of the command must be received within the command timeout
+		if s.commandTimeout > 0 {
+			_ = conn.SetReadDeadline(time.Now().Add(s.commandTimeout))
+		}
+
 		// Read stream type
 		stUint64, err := readFullUint64(conn)
 		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				log.Warnf("Command %d timeout from client %s, killed", command, clientId)
+			}
 			s.killClient(clientId)
 			return
 		}
@@ -292,8 +528,10 @@ func (s *StreamServer) handleConnection(conn net.Conn) {
 		log.Debugf("Command %d[%s] received from %s", command, StrCommand[Command(command)], clientId)
 		err = s.processCommand(Command(command), s.getSafeClient(clientId))
 		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				log.Warnf("Command %d timeout from client %s, killed", command, clientId)
+			}
 			// Kill client connection
-			time.Sleep(2 * time.Second) // nolint:gomnd
 			s.killClient(clientId)
 			return
 		}
@@ -305,20 +543,70 @@ func (s *StreamServer) StartAtomicOp() error {
 	start := time.Now().UnixNano()
 	defer log.Debugf("StartAtomicOp process time: %vns", time.Now().UnixNano()-start)
 
-	log.Debugf("!AtomicOp START (%d)", s.nextEntry)
 	// Check status of the server
 	if !s.started {
 		log.Errorf("AtomicOp not allowed. Server is not started")
 		return ErrAtomicOpNotAllowed
 	}
+
+	// Get the writer access without waiting (another atomic operation is in progress)
+	if !s.mutexWriter.TryLock() {
+		log.Errorf("AtomicOp already started and in progress")
+		return ErrStartAtomicOpNotAllowed
+	}
+
+	s.mutexAtomicOp.Lock()
+	defer s.mutexAtomicOp.Unlock()
+
+	err := s.startAtomicOp(nil)
+	if err != nil {
+		s.mutexWriter.Unlock()
+	}
+	return err
+}
+
+// Begin waits until no other atomic operation is in progress and starts a new one owned by the returned transaction
+func (s *StreamServer) Begin() (*StreamTx, error) {
+	// Check status of the server
+	if !s.started {
+		log.Errorf("AtomicOp not allowed. Server is not started")
+		return nil, ErrAtomicOpNotAllowed
+	}
+
+	// Wait for the writer access
+	s.mutexWriter.Lock()
+
+	s.mutexAtomicOp.Lock()
+	defer s.mutexAtomicOp.Unlock()
+
+	tx := &StreamTx{server: s}
+	err := s.startAtomicOp(tx)
+	if err != nil {
+		s.mutexWriter.Unlock()
+		return nil, err
+	}
+	return tx, nil
+}
+
+// startAtomicOp starts a new atomic operation owned by the transaction tx (nil if not started by Begin)
+func (s *StreamServer) startAtomicOp(tx *StreamTx) error {
+	log.Debugf("!AtomicOp START (%d)", s.nextEntry)
+
+	// Check the indexes are consistent with the stream file
+	if s.inconsistent {
+		log.Errorf("AtomicOp not allowed, server inconsistent")
+		return ErrServerInconsistent
+	}
+
 	// Check status of the atomic operation
-	if s.atomicOp.status == aoStarted {
+	if s.atomicOp.status != aoNone {
 		log.Errorf("AtomicOp already started and in progress after entry %d", s.atomicOp.startEntry)
 		return ErrStartAtomicOpNotAllowed
 	}
 
 	s.atomicOp.status = aoStarted
 	s.atomicOp.startEntry = s.nextEntry
+	s.atomicOpTx = tx
 	return nil
 }
 
@@ -327,6 +615,15 @@ func (s *StreamServer) AddStreamEntry(etype EntryType, data []byte) (uint64, err
 	start := time.Now().UnixNano()
 	defer log.Debugf("AddStreamEntry process time: %vns", time.Now().UnixNano()-start)
 
+	s.mutexAtomicOp.Lock()
+	defer s.mutexAtomicOp.Unlock()
+
+	// Check the atomic operation is not owned by a transaction
+	if s.atomicOpTx != nil {
+		log.Errorf("Add stream entry not allowed, AtomicOp is owned by a transaction")
+		return 0, ErrAtomicOpOwnedByTx
+	}
+
 	// Add to the stream file
 	entryNum, err := s.addStream("Data", etype, data)
 
@@ -338,17 +635,31 @@ func (s *StreamServer) AddStreamBookmark(bookmark []byte) (uint64, error) {
 	start := time.Now().UnixNano()
 	defer log.Debugf("AddStreamBookmark process time: %vns", time.Now().UnixNano()-start)
 
+	s.mutexAtomicOp.Lock()
+	defer s.mutexAtomicOp.Unlock()
+
+	// Check the atomic operation is not owned by a transaction
+	if s.atomicOpTx != nil {
+		log.Errorf("Add stream bookmark not allowed, AtomicOp is owned by a transaction")
+		return 0, ErrAtomicOpOwnedByTx
+	}
+
+	return s.addBookmark(bookmark)
+}
+
+// addBookmark adds a new bookmark entry and stages it for the bookmarks index
+func (s *StreamServer) addBookmark(bookmark []byte) (uint64, error) {
 	// Add to the stream file
 	entryNum, err := s.addStream("Bookmark", EtBookmark, bookmark)
 	if err != nil {
 		return 0, err
 	}
 
-	// Add to the bookmark index
-	err = s.bookmark.AddBookmark(bookmark, entryNum)
-	if err != nil {
-		return entryNum, err
-	}
+	// Stage a copy of the bookmark, it's written to the index when the atomic operation is committed
+	s.atomicOp.bookmarks = append(s.atomicOp.bookmarks, bookmarkAO{
+		bookmark: append([]byte(nil), bookmark...),
+		entryNum: entryNum,
+	})
 
 	return entryNum, nil
 }
@@ -379,7 +690,8 @@ func (s *StreamServer) addStream(desc string, etype EntryType, data []byte) (uin
 		return 0, nil
 	}
 
-	// Save the entry in the atomic operation in progress
+	// Save the entry in the atomic operation in progress (own copy of the data, broadcasted after the commit)
+	e.Data = append([]byte(nil), data...)
 	s.atomicOp.entries = append(s.atomicOp.entries, e)
 
 	// Increase sequential entry number
@@ -393,41 +705,299 @@ func (s *StreamServer) CommitAtomicOp() error {
 	start := time.Now().UnixNano()
 	defer log.Debugf("CommitAtomicOp process time: %vns", time.Now().UnixNano()-start)
 
+	s.mutexAtomicOp.Lock()
+
+	// Check the atomic operation is not owned by a transaction
+	if s.atomicOpTx != nil {
+		s.mutexAtomicOp.Unlock()
+		log.Errorf("Commit not allowed, AtomicOp is owned by a transaction")
+		return ErrAtomicOpOwnedByTx
+	}
+
+	done, err := s.commitAtomicOp()
+	s.mutexAtomicOp.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// Wait until durable (group durability mode)
+	return waitGroupCommit(done)
+}
+
+// commitAtomicOp commits the current atomic operation and releases the writer access.
+// In group durability mode returns the channel to wait for the flush of the commit
+func (s *StreamServer) commitAtomicOp() (chan error, error) {
 	log.Infof("!AtomicOp COMMIT (%d)", s.atomicOp.startEntry)
 	if s.atomicOp.status != aoStarted {
 		log.Errorf("Commit not allowed, AtomicOp is not in the started state")
-		return ErrCommitNotAllowed
+		return nil, ErrCommitNotAllowed
 	}
 
 	s.atomicOp.status = aoCommitting
 
-	// Update header into the file (commit the new entries)
-	err := s.streamFile.writeHeaderEntry()
-	if err != nil {
-		return err
-	}
-
-	// Do broadcast of the committed atomic operation to the stream clients
+	// Committed atomic operation to write and broadcast to the stream clients
 	atomic := streamAO{
 		status:     s.atomicOp.status,
 		startEntry: s.atomicOp.startEntry,
+		timestamp:  unixMilli(time.Now()),
 	}
 	atomic.entries = make([]FileEntry, len(s.atomicOp.entries))
 	copy(atomic.entries, s.atomicOp.entries)
+	atomic.bookmarks = make([]bookmarkAO, len(s.atomicOp.bookmarks))
+	copy(atomic.bookmarks, s.atomicOp.bookmarks)
+
+	// In group durability mode it's written and published by the next flush,
+	// meanwhile the next atomic operations are added after it
+	if s.durability == DurabilityGroup {
+		done := s.addGroupCommit(&atomic, s.streamFile.commitHeader())
+		s.clearAtomicOp()
+		return done, nil
+	}
 
-	s.stream <- atomic
+	// Write it (durable in fsync durability mode) and then make it visible
+	header := s.streamFile.header
+	err := s.writeCommits([]*streamAO{&atomic}, header, s.durability == DurabilityFsync)
+	if err != nil {
+		if s.inconsistent {
+			// Writes refused from now on, nothing to rollback
+			s.clearAtomicOp()
+		} else {
+			s.atomicOp.status = aoStarted
+		}
+		return nil, err
+	}
+	s.streamFile.commitHeader()
+	s.publishCommits([]*streamAO{&atomic})
 
 	// No atomic operation in progress
 	s.clearAtomicOp()
 
+	return nil, nil
+}
+
+// writeCommits writes the indexes and the header of committed atomic operations. If sync, the data entries are
+// flushed to disk before the header is written and the header after it. A failed flush leaves the server inconsistent
+func (s *StreamServer) writeCommits(atomicOps []*streamAO, header HeaderEntry, sync bool) error {
+	// Make the data entries durable before committing them
+	if sync {
+		err := s.streamFile.syncFile()
+		if err != nil {
+			s.setInconsistent("flushing the data entries", err)
+			return err
+		}
+	}
+
+	// Write the staged bookmarks to the index before the header. If the process crashes
+	// in between, the bookmarks point past the end of the file and they are restored to
+	// their previous value by the bookmarks consistency check on next start
+	bookmarks := []bookmarkAO{}
+	for _, atomicOp := range atomicOps {
+		bookmarks = append(bookmarks, atomicOp.bookmarks...)
+	}
+	err := s.bookmark.AddBookmarks(bookmarks, s.durability != DurabilityBuffered)
+	if err != nil {
+		return err
+	}
+
+	// Record the commit time in the index before the header too
+	for _, atomicOp := range atomicOps {
+		if len(atomicOp.entries) == 0 {
+			continue
+		}
+		atomicOp.timestamp, err = s.timestamps.add(atomicOp.startEntry, atomicOp.timestamp, s.durability != DurabilityBuffered)
+		if err != nil {
+			s.restoreIndexesFrom(atomicOps[0].startEntry)
+			return err
+		}
+	}
+
+	// Update header into the file (commit the new entries)
+	err = s.streamFile.writeHeader(header)
+	if err != nil {
+		s.restoreIndexesFrom(atomicOps[0].startEntry)
+		return err
+	}
+
+	// Make the header durable
+	if sync {
+		err = s.streamFile.syncFile()
+		if err != nil {
+			s.setInconsistent("flushing the header", err)
+			return err
+		}
+	}
+
+	// Make the entries visible to the readers
+	s.streamFile.setWrittenHeader(header)
 	return nil
 }
 
+// publishCommits adds written atomic operations to the recent entries cache and broadcasts them to the clients
+func (s *StreamServer) publishCommits(atomicOps []*streamAO) {
+	for _, atomicOp := range atomicOps {
+		if s.cache != nil {
+			s.cache.add(atomicOp.entries)
+		}
+		s.stream <- *atomicOp
+	}
+
+	// Compress the data pages sealed by the commits in background
+	s.streamFile.requestCompression()
+}
+
+// restoreIndexesFrom restores the bookmarks and deletes the commit time of the entries of a failed commit. If they
+// can't be restored the indexes point past the end of the file, so the writes are refused until the server
+// is restarted and the consistency checks fix them
+func (s *StreamServer) restoreIndexesFrom(entryNum uint64) {
+	_, err := s.timestamps.truncate(entryNum)
+	if err == nil {
+		_, err = s.bookmark.restoreBookmarksFrom(entryNum, s.streamFile)
+	}
+	if err != nil {
+		log.Errorf("Error restoring indexes from entry %d, server inconsistent until restart: %v", entryNum, err)
+		s.inconsistent = true
+	}
+}
+
+// setInconsistent refuses the writes until the server is restarted, after a failure leaving the stream file
+// or the indexes in an unknown state (a failed flush may have lost the written data)
+func (s *StreamServer) setInconsistent(desc string, err error) {
+	log.Errorf("Error %s, server inconsistent until restart: %v", desc, err)
+	s.inconsistent = true
+}
+
+// syncData makes durable the stream file changes not related to an atomic operation
+// according to the durability mode. In group durability mode returns the channel to wait for the flush
+func (s *StreamServer) syncData() (chan error, error) {
+	switch s.durability {
+	case DurabilityFsync:
+		err := s.streamFile.syncFile()
+		if err != nil {
+			s.setInconsistent("flushing the stream file", err)
+		}
+		return nil, err
+	case DurabilityGroup:
+		return s.addGroupCommit(nil, HeaderEntry{}), nil
+	default:
+		return nil, nil
+	}
+}
+
+// addGroupCommit adds a commit with its header (or just a flush request if atomicOp is nil) pending to be flushed
+func (s *StreamServer) addGroupCommit(atomicOp *streamAO, header HeaderEntry) chan error {
+	done := make(chan error, 1)
+
+	s.mutexGroup.Lock()
+	s.groupPending = append(s.groupPending, groupCommit{atomicOp: atomicOp, header: header, done: done})
+	full := uint64(len(s.groupPending)) >= s.groupMaxCommits
+	s.mutexGroup.Unlock()
+
+	// Trigger the flush if the maximum number of pending commits is reached
+	if full {
+		select {
+		case s.groupFlush <- struct{}{}:
+		default:
+		}
+	}
+
+	return done
+}
+
+// waitGroupCommit waits for the flush of a group commit (nothing to wait if done is nil)
+func waitGroupCommit(done chan error) error {
+	if done == nil {
+		return nil
+	}
+	return <-done
+}
+
+// groupCommitLoop flushes the pending commits every interval or when the maximum number of commits is reached
+func (s *StreamServer) groupCommitLoop() {
+	ticker := time.NewTicker(s.groupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.groupFlush:
+		}
+		s.mutexAtomicOp.Lock()
+		_ = s.flushGroupCommits()
+		s.mutexAtomicOp.Unlock()
+	}
+}
+
+// flushGroupCommits writes the pending commits at once (flushing the data entries before the header and
+// the header after it), then broadcasts the atomic operations and acknowledges the commits.
+// The atomic operations mutex must be held
+func (s *StreamServer) flushGroupCommits() error {
+	// Get the pending commits
+	s.mutexGroup.Lock()
+	pending := s.groupPending
+	s.groupPending = make([]groupCommit, 0, len(pending))
+	s.mutexGroup.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	// Atomic operations in commit order, committed with the header of the last one
+	atomicOps := []*streamAO{}
+	var header HeaderEntry
+	for _, p := range pending {
+		if p.atomicOp != nil {
+			atomicOps = append(atomicOps, p.atomicOp)
+			header = p.header
+		}
+	}
+
+	// Write and flush to disk all of them
+	start := time.Now().UnixNano()
+	var err error
+	switch {
+	case s.inconsistent:
+		err = ErrServerInconsistent
+	case len(atomicOps) > 0:
+		err = s.writeCommits(atomicOps, header, true)
+	default:
+		err = s.streamFile.syncFile()
+	}
+	log.Debugf("Group commit of %d commits flushed in %vns", len(pending), time.Now().UnixNano()-start)
+
+	// The next atomic operations may be added after the failed ones, so they can't be reverted
+	if err != nil && !s.inconsistent {
+		s.setInconsistent("flushing group commit", err)
+	}
+
+	// Publish the atomic operations and acknowledge the commits
+	if err == nil {
+		s.publishCommits(atomicOps)
+	}
+	for _, p := range pending {
+		p.done <- err
+	}
+	return err
+}
+
 // RollbackAtomicOp cancels the current atomic operation and rollbacks the changes
 func (s *StreamServer) RollbackAtomicOp() error {
 	start := time.Now().UnixNano()
 	defer log.Debugf("RollbackAtomicOp process time: %vns", time.Now().UnixNano()-start)
 
+	s.mutexAtomicOp.Lock()
+	defer s.mutexAtomicOp.Unlock()
+
+	// Check the atomic operation is not owned by a transaction
+	if s.atomicOpTx != nil {
+		log.Errorf("Rollback not allowed, AtomicOp is owned by a transaction")
+		return ErrAtomicOpOwnedByTx
+	}
+
+	return s.rollbackAtomicOp()
+}
+
+// rollbackAtomicOp cancels the current atomic operation and releases the writer access
+func (s *StreamServer) rollbackAtomicOp() error {
 	log.Infof("!AtomicOp ROLLBACK (%d)", s.atomicOp.startEntry)
 	if s.atomicOp.status != aoStarted {
 		log.Errorf("Rollback not allowed, AtomicOp is not in the started state")
@@ -439,6 +1009,7 @@ func (s *StreamServer) RollbackAtomicOp() error {
 	// Restore header in memory (discard current) from the file header (rollback entries)
 	err := s.streamFile.rollbackHeader()
 	if err != nil {
+		s.atomicOp.status = aoStarted
 		return err
 	}
 
@@ -451,25 +1022,102 @@ func (s *StreamServer) RollbackAtomicOp() error {
 	return nil
 }
 
+// AddEntry adds a new entry in the transaction atomic operation
+func (tx *StreamTx) AddEntry(etype EntryType, data []byte) (uint64, error) {
+	s := tx.server
+	s.mutexAtomicOp.Lock()
+	defer s.mutexAtomicOp.Unlock()
+
+	if tx.done {
+		return 0, ErrTxDone
+	}
+	return s.addStream("Data", etype, data)
+}
+
+// AddBookmark adds a new bookmark in the transaction atomic operation
+func (tx *StreamTx) AddBookmark(bookmark []byte) (uint64, error) {
+	s := tx.server
+	s.mutexAtomicOp.Lock()
+	defer s.mutexAtomicOp.Unlock()
+
+	if tx.done {
+		return 0, ErrTxDone
+	}
+	return s.addBookmark(bookmark)
+}
+
+// Commit commits the transaction atomic operation and streams it to the clients
+func (tx *StreamTx) Commit() error {
+	s := tx.server
+	s.mutexAtomicOp.Lock()
+
+	if tx.done {
+		s.mutexAtomicOp.Unlock()
+		return ErrTxDone
+	}
+	done, err := s.commitAtomicOp()
+	if err == nil {
+		tx.done = true
+	}
+	s.mutexAtomicOp.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// Wait until durable (group durability mode)
+	return waitGroupCommit(done)
+}
+
+// Rollback cancels the transaction atomic operation and rollbacks the changes
+func (tx *StreamTx) Rollback() error {
+	s := tx.server
+	s.mutexAtomicOp.Lock()
+	defer s.mutexAtomicOp.Unlock()
+
+	if tx.done {
+		return ErrTxDone
+	}
+	err := s.rollbackAtomicOp()
+	if err == nil {
+		tx.done = true
+	}
+	return err
+}
+
 // TruncateFile truncates stream data file from an entry number onwards
 func (s *StreamServer) TruncateFile(entryNum uint64) error {
+	s.mutexAtomicOp.Lock()
+	defer s.mutexAtomicOp.Unlock()
+
 	// Check the entry number
 	if entryNum >= s.nextEntry {
 		log.Errorf("Invalid entry number [%d], it doesn't exist", entryNum)
 		return ErrInvalidEntryNumber
 	}
 
+	// Check the indexes are consistent with the stream file
+	if s.inconsistent {
+		log.Errorf("Truncate not allowed, server inconsistent")
+		return ErrServerInconsistent
+	}
+
 	// Check atomic operation is not in progress
 	if s.atomicOp.status != aoNone {
 		log.Errorf("Truncate not allowed, atomic operation in progress")
 		return ErrTruncateNotAllowed
 	}
 
+	// Write the commits pending to be flushed (group durability mode)
+	err := s.flushGroupCommits()
+	if err != nil {
+		return err
+	}
+
 	// Log previous header
 	PrintHeaderEntry(s.streamFile.header, "(before truncate)")
 
 	// Truncate entries in the file
-	err := s.streamFile.truncateFile(entryNum)
+	err = s.streamFile.truncateFile(entryNum)
 	if err != nil {
 		return err
 	}
@@ -477,6 +1125,24 @@ func (s *StreamServer) TruncateFile(entryNum uint64) error {
 	// Update entry number sequence
 	s.nextEntry = s.streamFile.header.TotalEntries
 
+	// Remove truncated entries from the recent entries cache
+	if s.cache != nil {
+		s.cache.truncate(entryNum)
+	}
+
+	// Restore bookmarks pointing to truncated entries (after the header is committed,
+	// so an interrupted truncate is completed by the consistency check on next start)
+	_, err = s.bookmark.restoreBookmarksFrom(entryNum, s.streamFile)
+	if err != nil {
+		return err
+	}
+
+	// Delete the commit time of the truncated entries
+	_, err = s.timestamps.truncate(entryNum)
+	if err != nil {
+		return err
+	}
+
 	// Log current header
 	log.Infof("File truncated! Removed entries from %d (included) until end of file", entryNum)
 	PrintHeaderEntry(s.streamFile.header, "(after truncate)")
@@ -484,27 +1150,72 @@ func (s *StreamServer) TruncateFile(entryNum uint64) error {
 	return nil
 }
 
-// UpdateEntryData updates the internal data of an entry
+// UpdateEntryData updates the internal data of an entry keeping its entry type and data length
 func (s *StreamServer) UpdateEntryData(entryNum uint64, etype EntryType, data []byte) error {
+	return s.UpdateEntryDataMode(entryNum, etype, data, UmStrict)
+}
+
+// UpdateEntryDataMode updates the internal data of an entry allowing the changes set in the mode flags.
+// With UmAllowResize the entries after the updated one in its data page are moved, so entry numbers
+// (and bookmarks) don't change. Entries are never relocated to another data page, so the update fails
+// with ErrUpdateEntryNotFitInPage if its data page can't hold the new data length
+func (s *StreamServer) UpdateEntryDataMode(entryNum uint64, etype EntryType, data []byte, mode UpdateMode) error {
+	done, err := s.updateEntryData(entryNum, etype, data, mode)
+	if err != nil {
+		return err
+	}
+
+	// Wait until durable (group durability mode)
+	return waitGroupCommit(done)
+}
+
+// updateEntryData updates the internal data of an entry and makes it durable according to the durability mode
+func (s *StreamServer) updateEntryData(entryNum uint64, etype EntryType, data []byte, mode UpdateMode) (chan error, error) {
+	s.mutexAtomicOp.Lock()
+	defer s.mutexAtomicOp.Unlock()
+
 	// Check the entry number
 	if entryNum >= s.nextEntry {
 		log.Errorf("Invalid entry number [%d], it doesn't exist", entryNum)
-		return ErrInvalidEntryNumber
+		return nil, ErrInvalidEntryNumber
+	}
+
+	// Check the indexes are consistent with the stream file
+	if s.inconsistent {
+		log.Errorf("Update entry data not allowed, server inconsistent")
+		return nil, ErrServerInconsistent
 	}
 
 	// Check entry not in current atomic operation
 	if s.atomicOp.status != aoNone && entryNum >= s.atomicOp.startEntry {
 		log.Errorf("Entry number [%d] not allowed for update, it's in the current atomic operation", entryNum)
-		return ErrUpdateNotAllowed
+		return nil, ErrUpdateNotAllowed
+	}
+
+	// Write the commits pending to be flushed (group durability mode)
+	err := s.flushGroupCommits()
+	if err != nil {
+		return nil, err
 	}
 
 	// Update entry data in the stream file
-	err := s.streamFile.updateEntryData(entryNum, etype, data)
+	err = s.streamFile.updateEntryData(entryNum, etype, data, mode)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	// Update entry in the recent entries cache
+	if s.cache != nil {
+		s.cache.update(FileEntry{
+			packetType: PtData,
+			Length:     FixedSizeFileEntry + uint32(len(data)),
+			Type:       etype,
+			Number:     entryNum,
+			Data:       data,
+		})
+	}
+
+	return s.syncData()
 }
 
 // GetHeader returns the current committed header
@@ -516,6 +1227,14 @@ func (s *StreamServer) GetHeader() HeaderEntry {
 
 // GetEntry searches in the stream file and returns the data for the requested entry
 func (s *StreamServer) GetEntry(entryNum uint64) (FileEntry, error) {
+	// Get it from the recent entries cache
+	if s.cache != nil {
+		entry, ok := s.cache.get(entryNum)
+		if ok {
+			return entry, nil
+		}
+	}
+
 	// Initialize file stream iterator
 	iterator, err := s.streamFile.iteratorFrom(entryNum, true)
 	if err != nil {
@@ -534,6 +1253,14 @@ func (s *StreamServer) GetEntry(entryNum uint64) (FileEntry, error) {
 	return iterator.Entry, nil
 }
 
+// GetCacheStats returns the counters of the recent entries cache (zero values if disabled)
+func (s *StreamServer) GetCacheStats() CacheStats {
+	if s.cache == nil {
+		return CacheStats{}
+	}
+	return s.cache.stats()
+}
+
 // GetBookmark returns the entry number pointed by the bookmark
 func (s *StreamServer) GetBookmark(bookmark []byte) (uint64, error) {
 	return s.bookmark.GetBookmark(bookmark)
@@ -573,52 +1300,135 @@ func (s *StreamServer) GetFirstEventAfterBookmark(bookmark []byte) (FileEntry, e
 	return iterator.Entry, err
 }
 
+// GetLatestEntries returns up to count entries of the entry type, from the last one committed backwards
+func (s *StreamServer) GetLatestEntries(entryType EntryType, count uint64) ([]FileEntry, error) {
+	entries := []FileEntry{}
+	if count == 0 || s.streamFile.getHeaderEntry().TotalEntries == 0 {
+		return entries, nil
+	}
+
+	// Initialize reverse file stream iterator from the last entry
+	it := s.NewIterator(true)
+	defer it.Close()
+	err := it.FromLast()
+	if err != nil {
+		return nil, err
+	}
+
+	// Loop until the entries are found or the first entry is read
+	for uint64(len(entries)) < count {
+		end, err := it.Next()
+		if err != nil {
+			return nil, err
+		} else if end {
+			break
+		}
+		if it.Entry().Type == entryType {
+			entries = append(entries, it.Entry())
+		}
+	}
+
+	return entries, nil
+}
+
+// GetEntryTimestamp returns the commit time of an entry
+func (s *StreamServer) GetEntryTimestamp(entryNum uint64) (time.Time, error) {
+	if entryNum >= s.streamFile.getHeaderEntry().TotalEntries {
+		return time.Time{}, ErrInvalidEntryNumber
+	}
+	timestamp, _, found, err := s.timestamps.entryTimestamp(entryNum)
+	if err != nil {
+		return time.Time{}, err
+	} else if !found {
+		return time.Time{}, ErrTimestampNotFound
+	}
+	return time.UnixMilli(int64(timestamp)), nil
+}
+
+// GetEntryFromTime returns the number of the first entry committed at or after the time
+func (s *StreamServer) GetEntryFromTime(t time.Time) (uint64, error) {
+	entryNum, found, err := s.timestamps.entryFromTime(unixMilli(t))
+	if err != nil {
+		return 0, err
+	} else if !found || entryNum >= s.streamFile.getHeaderEntry().TotalEntries {
+		return 0, ErrEntryNotFound
+	}
+	return entryNum, nil
+}
+
 // clearAtomicOp sets the current atomic operation to none
 func (s *StreamServer) clearAtomicOp() {
-	// No atomic operation in progress and empty entries slice
+	// No atomic operation in progress and empty entries and bookmarks slices
 	s.atomicOp.entries = s.atomicOp.entries[:0]
+	s.atomicOp.bookmarks = s.atomicOp.bookmarks[:0]
 	s.atomicOp.status = aoNone
+	s.atomicOpTx = nil
+
+	// Release the writer access
+	s.mutexWriter.Unlock()
 }
 
 // broadcastAtomicOp broadcasts committed atomic operations to the clients
 func (s *StreamServer) broadcastAtomicOp() {
 	defer s.streamFile.file.Close()
 	defer s.bookmark.db.Close()
+	defer s.timestamps.close()
 
 	var err error
 	for {
 		// Wait for new atomic operation to broadcast
 		broadcastOp := <-s.stream
 		start := time.Now().UnixMilli()
+
+		// Get also the atomic operations already waiting to be broadcast, sent in the same writes
+		batch := []encodedAO{encodeAtomicOp(broadcastOp)}
+		for pending := true; pending && len(batch) < streamBuffer; {
+			select {
+			case op := <-s.stream:
+				batch = append(batch, encodeAtomicOp(op))
+			default:
+				pending = false
+			}
+		}
+
 		var killedClientMap = map[string]struct{}{}
 		s.mutexClients.Lock()
 		// For each connected and started client
-		log.Debugf("Clients: %d, AO-entries: %d", len(s.clients), len(broadcastOp.entries))
+		log.Debugf("Clients: %d, AOs: %d", len(s.clients), len(batch))
 		for id, cli := range s.clients {
 			log.Infof("Client %s status %d[%s]", id, cli.status, StrClientStatus[cli.status])
 			if cli.status != csSynced {
 				continue
 			}
 
-			// Send entries
-			for _, entry := range broadcastOp.entries {
-				if entry.Number >= cli.fromEntry {
-					log.Debugf("Sending data entry %d (type %d) to %s", entry.Number, entry.Type, id)
-					binaryEntry := encodeFileEntryToBinary(entry)
-
-					// Send the file data entry
-					if cli.conn != nil {
-						_, err = cli.conn.Write(binaryEntry)
-					} else {
-						err = ErrNilConnection
-					}
-					if err != nil {
-						// Kill client connection
-						log.Warnf("Error sending entry to %s: %v", id, err)
-						killedClientMap[id] = struct{}{}
+			// Encoded entries to send
+			buffers := make(net.Buffers, 0, len(batch))
+			for i := range batch {
+				var data []byte
+				if cli.compression == CompressionSnappy {
+					data = batch[i].compressedFrom(cli.fromEntry, &s.wireStats)
+				} else {
+					data = batch[i].from(cli.fromEntry)
+				}
+				if len(data) > 0 {
+					if cli.timestamps {
+						buffers = append(buffers, batch[i].timestamp)
 					}
+					buffers = append(buffers, data)
 				}
 			}
+			if len(buffers) == 0 {
+				continue
+			}
+
+			// Send the entries in a single vectored write
+			log.Debugf("Sending %d AOs to %s", len(buffers), id)
+			err = cli.writeBuffers(buffers)
+			if err != nil {
+				// Kill client connection
+				log.Warnf("Error sending entries to %s: %v", id, err)
+				killedClientMap[id] = struct{}{}
+			}
 		}
 		s.mutexClients.Unlock()
 
@@ -630,6 +1440,56 @@ func (s *StreamServer) broadcastAtomicOp() {
 	}
 }
 
+// encodeAtomicOp encodes the entries of a committed atomic operation into a single buffer
+func encodeAtomicOp(op streamAO) encodedAO {
+	length := 0
+	for _, entry := range op.entries {
+		length = length + FixedSizeFileEntry + len(entry.Data)
+	}
+
+	e := encodedAO{
+		data:      make([]byte, 0, length),
+		offsets:   make([]int, len(op.entries)),
+		timestamp: encodeTimestamp(op.timestamp),
+	}
+	if len(op.entries) > 0 {
+		e.firstEntry = op.entries[0].Number
+	}
+	for i, entry := range op.entries {
+		e.offsets[i] = len(e.data)
+		e.data = appendFileEntryToBinary(e.data, entry)
+	}
+	return e
+}
+
+// from returns the encoded entries starting from an entry number
+func (e encodedAO) from(entryNum uint64) []byte {
+	if entryNum <= e.firstEntry {
+		return e.data
+	}
+	if entryNum-e.firstEntry >= uint64(len(e.offsets)) {
+		return nil
+	}
+	return e.data[e.offsets[entryNum-e.firstEntry]:]
+}
+
+// compressedFrom returns the compressed batch of the encoded entries starting from an entry number
+func (e *encodedAO) compressedFrom(entryNum uint64, stats *CompressionStats) []byte {
+	data := e.from(entryNum)
+	if len(data) == 0 {
+		return nil
+	}
+	if len(data) < len(e.data) {
+		return encodeDataBatch(data, stats)
+	}
+	if e.compressed == nil {
+		e.compressed = encodeDataBatch(data, stats)
+	} else {
+		stats.add(len(data), len(e.compressed))
+	}
+	return e.compressed
+}
+
 // killClient disconnects the client and removes it from server clients struct
 func (s *StreamServer) killClient(clientId string) {
 	s.mutexClients.Lock()
@@ -658,10 +1518,10 @@ func (s *StreamServer) processCommand(command Command, client *client) error {
 			err = ErrClientAlreadyStarted
 			_ = s.sendResultEntry(uint32(CmdErrAlreadyStarted), StrCommandErrors[CmdErrAlreadyStarted], client)
 		} else {
-			cli.status = csSyncing
+			s.setSafeClientStatus(cli, csSyncing)
 			err = s.processCmdStart(client)
 			if err == nil {
-				cli.status = csSynced
+				s.setSafeClientStatus(cli, csSynced)
 			}
 		}
 
@@ -671,10 +1531,10 @@ func (s *StreamServer) processCommand(command Command, client *client) error {
 			err = ErrClientAlreadyStarted
 			_ = s.sendResultEntry(uint32(CmdErrAlreadyStarted), StrCommandErrors[CmdErrAlreadyStarted], client)
 		} else {
-			cli.status = csSyncing
+			s.setSafeClientStatus(cli, csSyncing)
 			err = s.processCmdStartBookmark(client)
 			if err == nil {
-				cli.status = csSynced
+				s.setSafeClientStatus(cli, csSynced)
 			}
 		}
 
@@ -684,35 +1544,57 @@ func (s *StreamServer) processCommand(command Command, client *client) error {
 			err = ErrClientAlreadyStopped
 			_ = s.sendResultEntry(uint32(CmdErrAlreadyStopped), StrCommandErrors[CmdErrAlreadyStopped], client)
 		} else {
-			cli.status = csStopped
+			s.setSafeClientStatus(cli, csStopped)
 			err = s.processCmdStop(client)
 		}
 
 	case CmdHeader:
+		err = s.processCmdHeader(client)
+
+	case CmdEntry:
+		err = s.processCmdEntry(client)
+
+	case CmdBookmark:
+		err = s.processCmdBookmark(client)
+
+	case CmdCompression:
 		if cli.status != csStopped {
-			log.Error("Header command not allowed, stream started!")
-			err = ErrHeaderCommandNotAllowed
+			log.Error("Compression command not allowed, stream started!")
+			err = ErrCompressionCommandNotAllowed
 			_ = s.sendResultEntry(uint32(CmdErrAlreadyStarted), StrCommandErrors[CmdErrAlreadyStarted], client)
 		} else {
-			err = s.processCmdHeader(client)
+			err = s.processCmdCompression(client)
 		}
 
-	case CmdEntry:
+	case CmdPing:
+		err = s.processCmdPing(client)
+
+	case CmdLatestEntries:
+		err = s.processCmdLatestEntries(client)
+
+	case CmdStartTime:
 		if cli.status != csStopped {
-			log.Error("Entry command not allowed, stream started!")
-			err = ErrEntryCommandNotAllowed
+			log.Error("Stream to client already started!")
+			err = ErrClientAlreadyStarted
 			_ = s.sendResultEntry(uint32(CmdErrAlreadyStarted), StrCommandErrors[CmdErrAlreadyStarted], client)
 		} else {
-			err = s.processCmdEntry(client)
+			s.setSafeClientStatus(cli, csSyncing)
+			err = s.processCmdStartTime(client)
+			if err == nil {
+				s.setSafeClientStatus(cli, csSynced)
+			}
 		}
 
-	case CmdBookmark:
+	case CmdEntryTime:
+		err = s.processCmdEntryTime(client)
+
+	case CmdTimestamps:
 		if cli.status != csStopped {
-			log.Error("Bookmark command not allowed, stream started!")
-			err = ErrBookmarkCommandNotAllowed
+			log.Error("Timestamps command not allowed, stream started!")
+			err = ErrTimestampsCommandNotAllowed
 			_ = s.sendResultEntry(uint32(CmdErrAlreadyStarted), StrCommandErrors[CmdErrAlreadyStarted], client)
 		} else {
-			err = s.processCmdBookmark(client)
+			err = s.processCmdTimestamps(client)
 		}
 
 	default:
@@ -737,7 +1619,8 @@ func (s *StreamServer) processCmdStart(client *client) error {
 	log.Infof("Client %s command Start from %d", client.clientId, fromEntry)
 
 	// Check received param
-	if fromEntry > s.nextEntry && fromEntry > s.initEntry {
+	nextEntry := s.getSafeNextEntry()
+	if fromEntry > nextEntry && fromEntry > s.initEntry {
 		log.Infof("Start command invalid from entry %d for client %s", fromEntry, client.clientId)
 		err = ErrStartCommandInvalidParamFromEntry
 		_ = s.sendResultEntry(uint32(CmdErrBadFromEntry), StrCommandErrors[CmdErrBadFromEntry], client)
@@ -751,7 +1634,7 @@ func (s *StreamServer) processCmdStart(client *client) error {
 	}
 
 	// Stream entries data from the requested entry number
-	if fromEntry < s.nextEntry {
+	if fromEntry < nextEntry {
 		err = s.streamingFromEntry(client, fromEntry)
 	}
 
@@ -798,13 +1681,50 @@ func (s *StreamServer) processCmdStartBookmark(client *client) error {
 
 	// Stream entries data from the entry number marked by the bookmark
 	log.Infof("Client %s Bookmark [%v] is the entry number [%d]", client.clientId, bookmark, entryNum)
-	if entryNum < s.nextEntry {
+	if entryNum < s.getSafeNextEntry() {
 		err = s.streamingFromEntry(client, entryNum)
 	}
 
 	return err
 }
 
+// processCmdStartTime processes the TCP Start Time command from the clients
+func (s *StreamServer) processCmdStartTime(client *client) error {
+	// Read from time parameter
+	fromTime, err := readFullUint64(client.conn)
+	if err != nil {
+		return err
+	}
+
+	// Log
+	log.Infof("Client %s command StartTime from %d", client.clientId, fromTime)
+
+	// First entry committed from the time (or the next entry to commit)
+	totalEntries := s.streamFile.getHeaderEntry().TotalEntries
+	fromEntry, found, err := s.timestamps.entryFromTime(fromTime)
+	if err != nil {
+		return err
+	}
+	if !found || fromEntry > totalEntries {
+		fromEntry = totalEntries
+	}
+	client.fromEntry = fromEntry
+
+	// Send a command result entry OK
+	err = s.sendResultEntry(0, "OK", client)
+	if err != nil {
+		return err
+	}
+
+	// Stream entries data from the entry number
+	log.Infof("Client %s time [%d] is the entry number [%d]", client.clientId, fromTime, fromEntry)
+	if fromEntry < totalEntries {
+		err = s.streamingFromEntry(client, fromEntry)
+	}
+
+	return err
+}
+
 // processCmdStop processes the TCP Stop command from the clients
 func (s *StreamServer) processCmdStop(client *client) error {
 	// Log
@@ -831,11 +1751,7 @@ func (s *StreamServer) processCmdHeader(client *client) error {
 	binaryHeader := encodeHeaderEntryToBinary(header)
 
 	// Send header entry to the client
-	if client.conn != nil {
-		_, err = client.conn.Write(binaryHeader)
-	} else {
-		err = ErrNilConnection
-	}
+	err = client.write(binaryHeader)
 	if err != nil {
 		log.Warnf("Error sending header entry to %s: %v", client.clientId, err)
 		return err
@@ -872,11 +1788,7 @@ func (s *StreamServer) processCmdEntry(client *client) error {
 	binaryEntry := encodeFileEntryToBinary(entry)
 
 	// Send entry to the client
-	if client.conn != nil {
-		_, err = client.conn.Write(binaryEntry)
-	} else {
-		err = ErrNilConnection
-	}
+	err = client.write(binaryEntry)
 	if err != nil {
 		log.Warnf("Error sending entry to %s: %v", client.clientId, err)
 		return err
@@ -926,11 +1838,96 @@ func (s *StreamServer) processCmdBookmark(client *client) error {
 	binaryEntry := encodeFileEntryToBinary(entry)
 
 	// Send entry to the client
-	if client.conn != nil {
-		_, err = client.conn.Write(binaryEntry)
-	} else {
-		err = ErrNilConnection
+	err = client.write(binaryEntry)
+	if err != nil {
+		log.Warnf("Error sending entry to %s: %v", client.clientId, err)
+		return err
+	}
+
+	return nil
+}
+
+// processCmdLatestEntries processes the TCP LatestEntries command from the clients
+func (s *StreamServer) processCmdLatestEntries(client *client) error {
+	// Read entry type parameter
+	entryType, err := readFullUint32(client.conn)
+	if err != nil {
+		return err
+	}
+
+	// Read number of entries parameter
+	count, err := readFullUint64(client.conn)
+	if err != nil {
+		return err
+	}
+
+	// Log
+	log.Infof("Client %s command LatestEntries type %d count %d", client.clientId, entryType, count)
+
+	// Send a command result entry OK
+	err = s.sendResultEntry(0, "OK", client)
+	if err != nil {
+		return err
+	}
+
+	// Get the requested entries
+	if count > maxLatestEntries {
+		count = maxLatestEntries
+	}
+	entries, err := s.GetLatestEntries(EntryType(entryType), count)
+	if err != nil {
+		log.Infof("Error getting latest entries of type %d: %v", entryType, err)
+		entries = nil
+	}
+
+	// Send the entries to the client, followed by a not found entry as the end of the list
+	end := FileEntry{Length: FixedSizeFileEntry, Type: EntryTypeNotFound}
+	for _, entry := range append(entries, end) {
+		entry.packetType = PtDataRsp
+		err = client.write(encodeFileEntryToBinary(entry))
+		if err != nil {
+			log.Warnf("Error sending entry to %s: %v", client.clientId, err)
+			return err
+		}
 	}
+
+	return nil
+}
+
+// processCmdEntryTime processes the TCP Entry Time command from the clients
+func (s *StreamServer) processCmdEntryTime(client *client) error {
+	// Read from time parameter
+	fromTime, err := readFullUint64(client.conn)
+	if err != nil {
+		return err
+	}
+
+	// Log
+	log.Infof("Client %s command EntryTime %d", client.clientId, fromTime)
+
+	// Send a command result entry OK
+	err = s.sendResultEntry(0, "OK", client)
+	if err != nil {
+		return err
+	}
+
+	// Get the first entry committed from the time
+	var entry FileEntry
+	entryNum, err := s.GetEntryFromTime(time.UnixMilli(int64(fromTime)))
+	if err == nil {
+		entry, err = s.GetEntry(entryNum)
+	}
+	if err != nil {
+		log.Infof("Error getting entry from time, not found? %d: %v", fromTime, err)
+		entry = FileEntry{}
+		entry.Length = FixedSizeFileEntry
+		entry.Type = EntryTypeNotFound
+	}
+	entry.packetType = PtDataRsp
+	binaryEntry := encodeFileEntryToBinary(entry)
+
+	// Send entry to the client
+	err = client.write(binaryEntry)
 	if err != nil {
 		log.Warnf("Error sending entry to %s: %v", client.clientId, err)
 		return err
@@ -939,47 +1936,321 @@ func (s *StreamServer) processCmdBookmark(client *client) error {
 	return nil
 }
 
-// streamingFromEntry sends to the client the stream data starting from the requested entry number
+// processCmdTimestamps processes the TCP Timestamps command from the clients
+func (s *StreamServer) processCmdTimestamps(client *client) error {
+	// Log
+	log.Infof("Client %s command Timestamps", client.clientId)
+
+	// Send the commit time of the streamed data entries
+	s.mutexClients.Lock()
+	client.timestamps = true
+	s.mutexClients.Unlock()
+
+	// Send a command result entry OK
+	return s.sendResultEntry(0, "OK", client)
+}
+
+// processCmdCompression processes the TCP Compression command from the clients
+func (s *StreamServer) processCmdCompression(client *client) error {
+	// Read compression mode parameter
+	mode, err := readFullUint32(client.conn)
+	if err != nil {
+		return err
+	}
+
+	// Log
+	log.Infof("Client %s command Compression %d", client.clientId, mode)
+
+	// Check received param
+	if CompressionMode(mode) != CompressionNone && CompressionMode(mode) != CompressionSnappy {
+		log.Infof("Compression command invalid mode %d for client %s", mode, client.clientId)
+		err = ErrInvalidCompressionMode
+		_ = s.sendResultEntry(uint32(CmdErrBadCompression), StrCommandErrors[CmdErrBadCompression], client)
+		return err
+	}
+
+	// Set the compression of the streamed data entries
+	s.mutexClients.Lock()
+	client.compression = CompressionMode(mode)
+	s.mutexClients.Unlock()
+
+	// Send a command result entry OK
+	return s.sendResultEntry(0, "OK", client)
+}
+
+// processCmdPing processes the TCP Ping command from the clients
+func (s *StreamServer) processCmdPing(client *client) error {
+	// Send a pong packet (no command result entry)
+	err := client.write([]byte{PtPong})
+	if err != nil {
+		log.Warnf("Error sending pong to %s: %v", client.clientId, err)
+		return err
+	}
+	return nil
+}
+
+// heartbeatLoop sends a ping packet to the clients every heartbeat interval
+func (s *StreamServer) heartbeatLoop() {
+	ticker := time.NewTicker(s.heartbeatInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.mutexClients.Lock()
+		clients := make([]*client, 0, len(s.clients))
+		for _, cli := range s.clients {
+			clients = append(clients, cli)
+		}
+		s.mutexClients.Unlock()
+
+		for _, cli := range clients {
+			// Skip the clients receiving data, the data also keeps the connection alive
+			if !cli.mutexWrite.TryLock() {
+				continue
+			}
+			if cli.conn != nil {
+				cli.setWriteDeadline()
+				_, err := cli.conn.Write([]byte{PtPing})
+				if err != nil {
+					log.Warnf("Error sending ping to %s: %v", cli.clientId, err)
+				}
+			}
+			cli.mutexWrite.Unlock()
+		}
+	}
+}
+
+// write sends data to the client connection
+func (c *client) write(data []byte) error {
+	c.mutexWrite.Lock()
+	defer c.mutexWrite.Unlock()
+	if c.conn == nil {
+		return ErrNilConnection
+	}
+	c.setWriteDeadline()
+	_, err := c.conn.Write(data)
+	return err
+}
+
+// writeBuffers sends the buffers to the client connection in a single vectored write
+func (c *client) writeBuffers(buffers net.Buffers) error {
+	c.mutexWrite.Lock()
+	defer c.mutexWrite.Unlock()
+	if c.conn == nil {
+		return ErrNilConnection
+	}
+	c.setWriteDeadline()
+	_, err := buffers.WriteTo(c.conn)
+	return err
+}
+
+// copyRange sends a byte range of the stream file to the client connection
+func (c *client) copyRange(file iteratorReader, pos int64, end int64) error {
+	c.mutexWrite.Lock()
+	defer c.mutexWrite.Unlock()
+	if c.conn == nil {
+		return ErrNilConnection
+	}
+	c.setWriteDeadline()
+	return copyRange(c.conn, file, pos, end)
+}
+
+// setWriteDeadline sets the deadline of the next write to the client connection
+func (c *client) setWriteDeadline() {
+	if c.timeout > 0 {
+		_ = c.conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	}
+}
+
+// sendEntries sends encoded data entries to the client using its compression
+func (s *StreamServer) sendEntries(client *client, data []byte) error {
+	if client.compression == CompressionSnappy {
+		data = encodeDataBatch(data, &s.wireStats)
+	}
+	return client.write(data)
+}
+
+// GetCompressionStats returns the bytes of data entries sent to the clients with compression
+func (s *StreamServer) GetCompressionStats() CompressionStats {
+	return s.wireStats.load()
+}
+
+// streamingFromEntry sends to the client the stream data starting from the requested entry number.
+// The data entries are sent as contiguous byte ranges of the data pages directly from the file
+// (or its memory mapping), until the client is caught up
 func (s *StreamServer) streamingFromEntry(client *client, fromEntry uint64) error {
 	// Log
 	log.Infof("SYNCING %s from entry %d...", client.clientId, fromEntry)
 
+	// Send the atomic operations with their commit time
+	if client.timestamps {
+		return s.streamingTimestamps(client, fromEntry)
+	}
+
+	// Send the entries from the recent entries cache while they are there
+	for s.cache != nil {
+		data, count, ok := s.cache.getFrom(fromEntry)
+		if !ok {
+			break
+		}
+
+		log.Debugf("Sending cached data entries [%d, %d) to %s", fromEntry, fromEntry+count, client.clientId)
+		err := s.sendEntries(client, data)
+		if err != nil {
+			log.Warnf("Error sending data entries to %s: %v", client.clientId, err)
+			return err
+		}
+		fromEntry = fromEntry + count
+
+		// Check if caught up
+		if s.setSafeClientSynced(client, fromEntry) {
+			return nil
+		}
+	}
+
 	// Start file stream iterator
 	iterator, err := s.streamFile.iteratorFrom(fromEntry, true)
 	if err != nil {
 		return err
 	}
+	defer s.streamFile.iteratorEnd(iterator)
+
+	// Position of the requested data entry, with its entry number to locate it again if the data pages are rewritten
+	pos, err := iterator.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		log.Errorf("Error seeking current pos for streaming: %v", err)
+		return err
+	}
+	posEntry := fromEntry
 
-	// Loop data entries from file stream iterator
 	for {
-		end, err := s.streamFile.iteratorNext(iterator)
+		synced, next, nextEntry, err := s.sendDataRange(client, iterator, pos, posEntry)
+		if err != nil || synced {
+			return err
+		}
+		pos = next
+		posEntry = nextEntry
+	}
+}
+
+// sendDataRange sends to the client the contiguous data entries range from a position (of the entry number if it's
+// not the start of a data page). The range is located excluding the in-place rewrites of the data pages, but it's
+// sent without holding them off. Returns if the client is caught up, and the position and entry number where the
+// following data entries start
+func (s *StreamServer) sendDataRange(client *client, iterator *iteratorFile, pos int64, posEntry uint64) (bool, int64, uint64, error) {
+	// Locate the range, again if the data pages were rewritten
+	s.streamFile.mutexRewrite.RLock()
+	header := s.streamFile.getHeaderEntry()
+	pos, err := s.streamFile.relocateEntry(iterator, pos, posEntry, header)
+	if err != nil {
+		s.streamFile.mutexRewrite.RUnlock()
+		return false, 0, 0, err
+	}
+
+	// Check if caught up
+	if pos >= int64(header.TotalLength) && s.setSafeClientSynced(client, header.TotalEntries) {
+		s.streamFile.mutexRewrite.RUnlock()
+		return true, pos, posEntry, nil
+	}
+
+	// Contiguous data entries range
+	end, next, err := s.streamFile.dataRange(iterator.file, pos, int64(header.TotalLength))
+	s.streamFile.mutexRewrite.RUnlock()
+	if err != nil {
+		return false, 0, 0, err
+	}
+
+	// The entry number is known at the end of the committed data
+	nextEntry := posEntry
+	if next >= int64(header.TotalLength) {
+		nextEntry = header.TotalEntries
+	}
+
+	// Nothing to send
+	if end <= pos {
+		return false, next, nextEntry, nil
+	}
+
+	// Send the data entries range
+	log.Debugf("Sending data entries bytes [%d, %d) to %s", pos, end, client.clientId)
+	if client.compression == CompressionNone {
+		err = client.copyRange(iterator.file, pos, end)
+		if err == nil && s.rewrittenSince(iterator.rewriteSeq) {
+			// Part of the range sent could be rewritten meanwhile, the client has to resume the streaming
+			log.Warnf("Data pages rewritten while sending data entries to %s", client.clientId)
+			err = ErrDataPageRewritten
+		}
+	} else {
+		data := make([]byte, end-pos)
+		_, err = iterator.file.ReadAt(data, pos)
+		if err == nil && s.rewrittenSince(iterator.rewriteSeq) {
+			// Read again the range located again
+			return false, pos, posEntry, nil
+		}
+		if err == nil {
+			err = s.sendEntries(client, data)
+		}
+	}
+	if err != nil {
+		log.Warnf("Error sending data entries to %s: %v", client.clientId, err)
+		return false, 0, 0, err
+	}
+
+	return false, next, nextEntry, nil
+}
+
+// rewrittenSince returns if the logical content of the data pages was rewritten in place since the rewrite sequence
+func (s *StreamServer) rewrittenSince(rewriteSeq uint64) bool {
+	s.streamFile.mutexRewrite.RLock()
+	defer s.streamFile.mutexRewrite.RUnlock()
+	return s.streamFile.changedSeq > rewriteSeq
+}
+
+// streamingTimestamps sends to the client the stream data starting from the requested entry number, the entries
+// of each atomic operation preceded by a timestamp packet with its commit time, until the client is caught up
+func (s *StreamServer) streamingTimestamps(client *client, fromEntry uint64) error {
+	// Start stream iterator
+	it := s.NewIterator(false)
+	defer it.Close()
+	err := it.From(fromEntry)
+	if err != nil {
+		return err
+	}
+
+	nextEntry := fromEntry
+	for !s.setSafeClientSynced(client, nextEntry) {
+		// Commit time of the atomic operation of the next entry (0 if committed before the index existed)
+		timestamp, nextOp, _, err := s.timestamps.entryTimestamp(nextEntry)
 		if err != nil {
 			return err
 		}
 
-		// Check if end of iterator
-		if end {
-			break
+		// Encoded entries of the atomic operation (limited to a data page size per send)
+		data := []byte{}
+		for nextEntry < nextOp && len(data) < PageDataSize {
+			end, err := it.Next()
+			if err != nil {
+				return err
+			} else if end {
+				break
+			}
+			data = appendFileEntryToBinary(data, it.Entry())
+			nextEntry++
+		}
+		if len(data) == 0 {
+			continue
 		}
 
-		// Send the file data entry
-		binaryEntry := encodeFileEntryToBinary(iterator.Entry)
-		log.Debugf("Sending data entry %d (type %d) to %s", iterator.Entry.Number, iterator.Entry.Type, client.clientId)
-		if client.conn != nil {
-			_, err = client.conn.Write(binaryEntry)
-		} else {
-			err = ErrNilConnection
+		// Send the timestamp packet and the data entries
+		log.Debugf("Sending data entries until %d with timestamp %d to %s", nextEntry, timestamp, client.clientId)
+		err = client.write(encodeTimestamp(timestamp))
+		if err == nil {
+			err = s.sendEntries(client, data)
 		}
 		if err != nil {
-			log.Warnf("Error sending entry %d to %s: %v", iterator.Entry.Number, client.clientId, err)
+			log.Warnf("Error sending data entries to %s: %v", client.clientId, err)
 			return err
 		}
 	}
-	log.Infof("Synced %s until %d!", client.clientId, iterator.Entry.Number)
-
-	// Close iterator
-	s.streamFile.iteratorEnd(iterator)
-
 	return nil
 }
 
@@ -1002,11 +2273,7 @@ func (s *StreamServer) sendResultEntry(errorNum uint32, errorStr string, client
 
 	// Send the result entry to the client
 	var err error
-	if client.conn != nil {
-		_, err = client.conn.Write(binaryEntry)
-	} else {
-		err = ErrNilConnection
-	}
+	err = client.write(binaryEntry)
 	if err != nil {
 		log.Warnf("Error sending result entry to %s: %v", client.clientId, err)
 		return err
@@ -1021,6 +2288,36 @@ func (s *StreamServer) getSafeClient(clientId string) *client {
 	return client
 }
 
+func (s *StreamServer) getSafeNextEntry() uint64 {
+	s.mutexAtomicOp.Lock()
+	nextEntry := s.nextEntry
+	s.mutexAtomicOp.Unlock()
+	return nextEntry
+}
+
+// setSafeClientSynced sets the client as synced if all the committed entries were sent (next entry to send
+// is the total entries), then the new entries will come from the broadcast
+func (s *StreamServer) setSafeClientSynced(client *client, nextEntry uint64) bool {
+	s.mutexClients.Lock()
+	defer s.mutexClients.Unlock()
+
+	if s.streamFile.getHeaderEntry().TotalEntries > nextEntry {
+		return false
+	}
+	client.fromEntry = nextEntry
+	client.status = csSynced
+
+	log.Infof("Synced %s until %d!", client.clientId, nextEntry-1)
+	return true
+}
+
+// setSafeClientStatus sets the status of a client (read by the broadcast of the clients)
+func (s *StreamServer) setSafeClientStatus(client *client, status ClientStatus) {
+	s.mutexClients.Lock()
+	client.status = status
+	s.mutexClients.Unlock()
+}
+
 func (s *StreamServer) getSafeClientsLen() int {
 	s.mutexClients.Lock()
 	clientLen := len(s.clients)
@@ -0,0 +1,250 @@
+package datastreamer
+
+import (
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/0xPolygonHermez/zkevm-data-streamer/log"
+)
+
+const (
+	timestampRecordSize = 16 // Size of a timestamp index record (u64 first entry number, u64 commit time in ms)
+	fixedSizeTimestamp  = 9  // Size in bytes of a timestamp packet (1+8)
+)
+
+// timestampIndex type to manage the sidecar index of the atomic operations commit time. Each record
+// has the number of the first entry of an atomic operation and its commit time (Unix milliseconds)
+type timestampIndex struct {
+	fileName string
+	file     *os.File
+	records  uint64 // Number of records in the index
+	last     uint64 // Commit time of the last record
+	mutex    sync.Mutex
+}
+
+// timestampIndexName returns the name of the timestamp index of a stream file
+func timestampIndexName(fileName string) string {
+	return strings.TrimSuffix(fileName, filepath.Ext(fileName)) + ".tsi"
+}
+
+// openTimestampIndex opens or creates the timestamp index file
+func openTimestampIndex(fn string) (*timestampIndex, error) {
+	log.Infof("Opening/creating timestamp index for datastream: %s", fn)
+	file, err := os.OpenFile(fn, os.O_CREATE|os.O_RDWR, fileMode)
+	if err != nil {
+		log.Errorf("Error opening or creating timestamp index %s: %v", fn, err)
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		log.Errorf("Error getting timestamp index %s info: %v", fn, err)
+		file.Close()
+		return nil, err
+	}
+
+	t := timestampIndex{
+		fileName: fn,
+		file:     file,
+		records:  uint64(info.Size()) / timestampRecordSize,
+	}
+
+	// Remove a partial record written by an interrupted commit
+	if uint64(info.Size())%timestampRecordSize != 0 {
+		log.Warnf("Removing partial record of timestamp index %s", fn)
+		err = t.truncateRecords(t.records)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	if t.records > 0 {
+		_, t.last, err = t.readRecord(t.records - 1)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+
+	return &t, nil
+}
+
+// add appends the commit time of an atomic operation starting at the entry number. The time never
+// goes backwards, so the index is sorted by both fields. Returns the commit time recorded
+func (t *timestampIndex) add(firstEntry uint64, timestamp uint64, sync bool) (uint64, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if timestamp < t.last {
+		timestamp = t.last
+	}
+
+	record := binary.BigEndian.AppendUint64(nil, firstEntry)
+	record = binary.BigEndian.AppendUint64(record, timestamp)
+	_, err := t.file.WriteAt(record, int64(t.records*timestampRecordSize))
+	if err == nil && sync {
+		err = t.file.Sync()
+	}
+	if err != nil {
+		log.Errorf("Error writing timestamp index %s: %v", t.fileName, err)
+		return 0, err
+	}
+
+	t.records++
+	t.last = timestamp
+	return timestamp, nil
+}
+
+// truncate removes the records of the atomic operations from an entry number onwards, returns the
+// number of records removed
+func (t *timestampIndex) truncate(entryNum uint64) (uint64, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	// First record from the entry number
+	var err error
+	i := sort.Search(int(t.records), func(i int) bool {
+		if err != nil {
+			return true
+		}
+		var firstEntry uint64
+		firstEntry, _, err = t.readRecord(uint64(i))
+		return firstEntry >= entryNum
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	removed := t.records - uint64(i)
+	if removed == 0 {
+		return 0, nil
+	}
+	err = t.truncateRecords(uint64(i))
+	if err != nil {
+		return 0, err
+	}
+
+	t.last = 0
+	if t.records > 0 {
+		_, t.last, err = t.readRecord(t.records - 1)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return removed, nil
+}
+
+// truncateRecords truncates the index file to a number of records
+func (t *timestampIndex) truncateRecords(records uint64) error {
+	err := t.file.Truncate(int64(records * timestampRecordSize))
+	if err != nil {
+		log.Errorf("Error truncating timestamp index %s: %v", t.fileName, err)
+		return err
+	}
+	t.records = records
+	return nil
+}
+
+// entryTimestamp returns the commit time of an entry and the first entry of the next atomic operation
+// (MaxUint64 if none), false if the entry was committed before the index existed
+func (t *timestampIndex) entryTimestamp(entryNum uint64) (uint64, uint64, bool, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	// First record after the entry number
+	var err error
+	i := sort.Search(int(t.records), func(i int) bool {
+		if err != nil {
+			return true
+		}
+		var firstEntry uint64
+		firstEntry, _, err = t.readRecord(uint64(i))
+		return firstEntry > entryNum
+	})
+	if err != nil {
+		return 0, 0, false, err
+	}
+
+	next := uint64(math.MaxUint64)
+	if uint64(i) < t.records {
+		next, _, err = t.readRecord(uint64(i))
+		if err != nil {
+			return 0, 0, false, err
+		}
+	}
+	if i == 0 {
+		return 0, next, false, nil
+	}
+
+	_, timestamp, err := t.readRecord(uint64(i - 1))
+	if err != nil {
+		return 0, 0, false, err
+	}
+	return timestamp, next, true, nil
+}
+
+// entryFromTime returns the first entry committed at or after the time, false if none
+func (t *timestampIndex) entryFromTime(timestamp uint64) (uint64, bool, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	// First record at or after the time
+	var err error
+	i := sort.Search(int(t.records), func(i int) bool {
+		if err != nil {
+			return true
+		}
+		var recordTime uint64
+		_, recordTime, err = t.readRecord(uint64(i))
+		return recordTime >= timestamp
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	if uint64(i) >= t.records {
+		return 0, false, nil
+	}
+
+	firstEntry, _, err := t.readRecord(uint64(i))
+	if err != nil {
+		return 0, false, err
+	}
+	return firstEntry, true, nil
+}
+
+// readRecord reads a record of the index. The mutex must be held
+func (t *timestampIndex) readRecord(i uint64) (uint64, uint64, error) {
+	record := make([]byte, timestampRecordSize)
+	_, err := t.file.ReadAt(record, int64(i*timestampRecordSize))
+	if err != nil {
+		log.Errorf("Error reading timestamp index %s record %d: %v", t.fileName, i, err)
+		return 0, 0, err
+	}
+	return binary.BigEndian.Uint64(record[0:8]), binary.BigEndian.Uint64(record[8:16]), nil
+}
+
+// close closes the index file
+func (t *timestampIndex) close() error {
+	return t.file.Close()
+}
+
+// encodeTimestamp encodes a timestamp packet with the commit time of the next streamed entries
+func encodeTimestamp(timestamp uint64) []byte {
+	packet := make([]byte, 1, fixedSizeTimestamp)
+	packet[0] = PtTimestamp
+	return binary.BigEndian.AppendUint64(packet, timestamp)
+}
+
+// unixMilli returns the time in Unix milliseconds (0 for times before 1970)
+func unixMilli(t time.Time) uint64 {
+	if t.UnixMilli() < 0 {
+		return 0
+	}
+	return uint64(t.UnixMilli())
+}
@@ -183,10 +183,15 @@ func run(ctx *cli.Context) error {
 		return err
 	}
 
-	// Wait for interrupt signal
+	// Wait for interrupt signal or relay error
 	interruptSignal := make(chan os.Signal, 1)
 	signal.Notify(interruptSignal, os.Interrupt, syscall.SIGTERM)
-	<-interruptSignal
+	select {
+	case <-interruptSignal:
+	case err = <-r.Errors():
+		log.Errorf(">> Relay server: relay error! (%v)", err)
+		return err
+	}
 
 	log.Info(">> Relay server finished")
 	return nil